@@ -0,0 +1,71 @@
+// ## Admonitions
+//
+// Go doc comments mark special paragraphs with a leading word, like
+// `Deprecated: Use Bar instead.` With `-admonitions`, such paragraphs stand
+// out as callouts instead of plain prose. The leading words are
+// `Deprecated:`, `Warning:`, `Note:`, and `TODO:`. The style of the
+// callouts is either
+//
+// * `blockquote`, a block quote with an icon, like
+//   `> ⚠️ **Deprecated:** Use Bar instead.`, which any renderer shows, or
+// * `github`, an alert like `> [!WARNING]`, which GitHub renders with a
+//   colored bar and an icon of its own.
+//
+// With `-format asciidoc`, the paragraphs become the admonition paragraphs
+// of AsciiDoc, like `CAUTION: Deprecated: Use Bar instead.`, whatever the
+// style.
+
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// admonitionWord matches the leading word of an admonition paragraph.
+var admonitionWord = regexp.MustCompile(`^(Deprecated|Warning|Note|TODO):\s*`)
+
+// admonitionKinds maps the leading words to the icons for `blockquote`,
+// and to the alert types of GitHub and the admonition types of AsciiDoc.
+var admonitionKinds = map[string]struct{ icon, alert, adoc string }{
+	"Deprecated": {"⚠️", "CAUTION", "CAUTION"},
+	"Warning":    {"⚠️", "WARNING", "WARNING"},
+	"Note":       {"ℹ️", "NOTE", "NOTE"},
+	"TODO":       {"📝", "NOTE", "NOTE"},
+}
+
+// admonitionStyles are the values of `-admonitions`.
+var admonitionStyles = map[string]bool{"blockquote": true, "github": true}
+
+// admonitionStart returns the leading word if the line `text`, the first
+// line of a paragraph, starts an admonition.
+func admonitionStart(text string) string {
+	if m := admonitionWord.FindStringSubmatch(text); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// admonish formats the line `text` of an admonition paragraph with the
+// leading word `word`. `first` is true for the first line of the paragraph.
+func admonish(text, word string, first bool, style, format string) string {
+	kind := admonitionKinds[word]
+	rest := admonitionWord.ReplaceAllString(text, "")
+	if format == "asciidoc" {
+		switch {
+		case !first:
+			return text
+		case kind.adoc == strings.ToUpper(word):
+			// `NOTE: Note: text` would say it twice.
+			return kind.adoc + ": " + rest
+		}
+		return kind.adoc + ": " + text
+	}
+	if !first {
+		return "> " + text
+	}
+	if style == "github" {
+		return "> [!" + kind.alert + "]\n> **" + word + ":** " + rest
+	}
+	return "> " + kind.icon + " **" + word + ":** " + rest
+}
@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func TestAdmonitions(t *testing.T) {
+	const in = "// Deprecated: Use Bar instead.\n// It is slow.\n//\n// Warning: Hot.\n//\n// Note: See Baz.\n//\n// TODO: Fix it.\n//\n// Plain: not a keyword.\n// Note: not at the start of a paragraph.\npackage p\n"
+	tests := []struct {
+		style, format, want string
+	}{
+		{
+			style: "blockquote",
+			want:  "> ⚠️ **Deprecated:** Use Bar instead.\n> It is slow.\n\n> ⚠️ **Warning:** Hot.\n\n> ℹ️ **Note:** See Baz.\n\n> 📝 **TODO:** Fix it.\n\nPlain: not a keyword.\nNote: not at the start of a paragraph.\n\n```go\npackage p\n```\n",
+		},
+		{
+			style: "github",
+			want:  "> [!CAUTION]\n> **Deprecated:** Use Bar instead.\n> It is slow.\n\n> [!WARNING]\n> **Warning:** Hot.\n\n> [!NOTE]\n> **Note:** See Baz.\n\n> [!NOTE]\n> **TODO:** Fix it.\n\nPlain: not a keyword.\nNote: not at the start of a paragraph.\n\n```go\npackage p\n```\n",
+		},
+		{
+			style:  "blockquote",
+			format: "asciidoc",
+			want:   "CAUTION: Deprecated: Use Bar instead.\nIt is slow.\n\nWARNING: Hot.\n\nNOTE: See Baz.\n\nNOTE: TODO: Fix it.\n\nPlain: not a keyword.\nNote: not at the start of a paragraph.\n\n[source,go]\n----\npackage p\n----\n",
+		},
+		{
+			want: "Deprecated: Use Bar instead.\nIt is slow.\n\nWarning: Hot.\n\nNote: See Baz.\n\nTODO: Fix it.\n\nPlain: not a keyword.\nNote: not at the start of a paragraph.\n\n```go\npackage p\n```\n",
+		},
+	}
+	for _, tt := range tests {
+		got, _, err := convert(in, Options{Admonitions: tt.style, Format: tt.format})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%s %s: got\n%q\nwant\n%q", tt.style, tt.format, got, tt.want)
+		}
+	}
+}
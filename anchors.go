@@ -0,0 +1,68 @@
+// ## Heading anchors
+//
+// Renderers derive the ids of headings in their own ways, so links into a
+// section of a document can break when the renderer changes. With
+// `-anchors`, every heading gets an explicit id in the attribute syntax
+// that goldmark (and thus Hugo), Pandoc, and others understand:
+//
+//     ## Converting a file {#converting-a-file}
+//
+// The id is the heading text in lower case, with spaces turned into dashes
+// and punctuation removed. If several headings have the same text, the
+// second one gets the suffix `-1`, the third one `-2`, and so on.
+
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// headingID matches an explicit id at the end of a heading; submatch 1 is the id.
+var headingID = regexp.MustCompile(`\s*\{#([^}\s]+)\}\s*$`)
+
+// slug returns the id for the heading text `text`.
+func slug(text string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_':
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			dash = false
+			b.WriteRune(r)
+		case unicode.IsSpace(r) || r == '-':
+			dash = true
+		}
+	}
+	if b.Len() == 0 {
+		return "section"
+	}
+	return b.String()
+}
+
+// anchorHeading adds an id to the heading `line`, unless it has one
+// already. `ids` holds the ids taken so far in the document. Lines that are
+// no headings are returned unchanged.
+func anchorHeading(line string, ids map[string]bool) string {
+	loc := heading.FindStringIndex(line)
+	if loc == nil {
+		return line
+	}
+	if m := headingID.FindStringSubmatch(line); m != nil {
+		ids[m[1]] = true
+		return line
+	}
+	text := strings.TrimSpace(strings.TrimRight(strings.TrimSpace(line[loc[1]:]), "#"))
+	id := slug(text)
+	for i := 1; ids[id]; i++ {
+		id = slug(text) + "-" + strconv.Itoa(i)
+	}
+	ids[id] = true
+	// A closing sequence of #s would hide the id, so it gets dropped.
+	return line[:loc[1]] + text + " {#" + id + "}"
+}
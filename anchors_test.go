@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestSlug(t *testing.T) {
+	for text, want := range map[string]string{
+		"Converting a File":      "converting-a-file",
+		"What's new? (v2.0)":     "whats-new-v20",
+		"  -- Dashes -- and _  ": "dashes-and-_",
+		"Größe & Gewicht":        "größe-gewicht",
+		"?!":                     "section",
+	} {
+		if got := slug(text); got != want {
+			t.Errorf("%q: got %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestAnchorHeading(t *testing.T) {
+	ids := map[string]bool{}
+	for _, tt := range []struct{ line, want string }{
+		{"# Usage", "# Usage {#usage}"},
+		{"## Usage ##", "## Usage {#usage-1}"},
+		{"### Usage", "### Usage {#usage-2}"},
+		{"## Flags: -v, -j!", "## Flags: -v, -j! {#flags-v-j}"},
+		{"## Custom {#usage-3}", "## Custom {#usage-3}"},
+		{"## Usage", "## Usage {#usage-4}"},
+		{"Not a heading", "Not a heading"},
+	} {
+		if got := anchorHeading(tt.line, ids); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
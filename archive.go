@@ -0,0 +1,175 @@
+// ## Converting an archive
+//
+// CI pipelines often pass Go sources around as an archive. With
+// `-archive sources.zip` (or a `.tar`, `.tar.gz`, or `.tgz` file),
+// `gotomarkdown` converts every `.go` file in the archive. The output
+// mirrors the directory structure of the archive below the output
+// directory, and the media paths of each file are relative to the file's
+// directory within the archive.
+//
+// The archive gets extracted to a temporary directory first, so that the
+// media files can be copied from there like from any other directory.
+
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// convertArchive converts the Go files in the archive `name`. It returns
+// the names of the Go files within the archive, and their media files.
+// The media files are in a temporary directory that `cleanup` removes.
+func convertArchive(name string, opts Options) (filenames []string, media map[string]map[string]string, cleanup func(), err error) {
+	tmp, err := ioutil.TempDir("", "gotomarkdown")
+	if err != nil {
+		return nil, nil, nil, errors.New("Cannot create a temporary directory\n" + err.Error())
+	}
+	cleanup = func() { os.RemoveAll(tmp) }
+	entries, err := extractArchive(name, tmp)
+	if err != nil {
+		cleanup()
+		return nil, nil, nil, errors.New("Cannot extract " + name + "\n" + err.Error())
+	}
+	media = map[string]map[string]string{}
+	opts = opts.withRun()
+	opts.PreservePaths = true
+	if opts.AutoWeight {
+		var sources []string
+		for _, entry := range entries {
+			if strings.HasSuffix(entry, ".go") {
+				sources = append(sources, entry)
+			}
+		}
+		opts.weights = weightsOf(sources)
+	}
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry, ".go") {
+			continue
+		}
+		if opts.Verbose {
+			log.Println("Converting", name+":"+entry)
+		}
+		src, err := ioutil.ReadFile(filepath.Join(tmp, entry))
+		if err != nil {
+			cleanup()
+			return nil, nil, nil, err
+		}
+		o := opts
+		o.BaseDir = filepath.Join(tmp, filepath.Dir(entry))
+		m, err := convertSource(string(src), entry, o)
+		if err != nil {
+			cleanup()
+			return nil, nil, nil, err
+		}
+		filenames = append(filenames, entry)
+		media[entry] = m
+	}
+	return filenames, media, cleanup, nil
+}
+
+// extractArchive extracts the zip or tar archive `name` to the directory
+// `dir`, and returns the names of the files in the archive, in order.
+func extractArchive(name, dir string) (entries []string, err error) {
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return extractZip(name, dir)
+	case strings.HasSuffix(name, ".tar"), strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return extractTar(name, dir)
+	}
+	return nil, errors.New("Unknown archive type, expected .zip, .tar, .tar.gz, or .tgz")
+}
+
+// extractZip extracts the zip archive `name` to `dir`.
+func extractZip(name, dir string) (entries []string, err error) {
+	zr, err := zip.OpenReader(name)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		entry, err := extractFile(dir, f.Name, r)
+		r.Close()
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// extractTar extracts the tar archive `name`, which may be gzipped, to `dir`.
+func extractTar(name, dir string) (entries []string, err error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if !strings.HasSuffix(name, ".tar") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		entry, err := extractFile(dir, hdr.Name, tr)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// extractFile writes the content of the archive entry `name` from `r` to
+// the directory `dir`, and returns the cleaned name. Entries outside of the
+// archive, like `../x.go`, are refused.
+func extractFile(dir, name string, r io.Reader) (string, error) {
+	name = path.Clean(strings.TrimLeft(name, "/"))
+	if name == ".." || strings.HasPrefix(name, "../") {
+		return "", errors.New("Refusing archive entry outside of the archive: " + name)
+	}
+	target := filepath.Join(dir, filepath.FromSlash(name))
+	err := createPath(filepath.Dir(target))
+	if err != nil {
+		return "", err
+	}
+	out, err := os.Create(target)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(out, r)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return filepath.FromSlash(name), err
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// archiveFiles are the files of the test archives.
+var archiveFiles = map[string]string{
+	"a/foo.go":  "// # A\n//\n// ![img](img.png)\n\npackage a\n",
+	"a/img.png": "png",
+	"b/foo.go":  "// # B\n\npackage b\n",
+}
+
+func zipArchive(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func tgzArchive(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(content))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// outputFiles returns the files below `dir`, with slashes.
+func outputFiles(t *testing.T, dir string) []string {
+	var files []string
+	err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err == nil && !fi.IsDir() {
+			rel, _ := filepath.Rel(dir, p)
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	return files
+}
+
+func TestConvertArchive(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"src.zip": zipArchive(t, archiveFiles),
+		"src.tgz": tgzArchive(t, archiveFiles),
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			archive := filepath.Join(dir, name)
+			if err := os.WriteFile(archive, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			opts := Options{OutDir: filepath.Join(dir, "out")}
+			filenames, media, cleanup, err := convertArchive(archive, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			opts.PreservePaths = true
+			err = copyMedia(media, opts)
+			cleanup()
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort.Strings(filenames)
+			if want := []string{filepath.FromSlash("a/foo.go"), filepath.FromSlash("b/foo.go")}; !reflect.DeepEqual(filenames, want) {
+				t.Errorf("got files %v, want %v", filenames, want)
+			}
+			if got, want := outputFiles(t, opts.OutDir), []string{"a/foo.md", "a/img.png", "b/foo.md"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("got output %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestConvertArchiveOutside(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "bad.zip")
+	if err := os.WriteFile(archive, zipArchive(t, map[string]string{"../x.go": "package x\n"}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := convertArchive(archive, Options{OutDir: filepath.Join(dir, "out")}); err == nil {
+		t.Error("got no error for an entry outside of the archive")
+	}
+}
@@ -0,0 +1,69 @@
+// ## Checking that the documents are up to date
+//
+// Generated documents that live in a repository next to their Go files
+// fall behind as soon as someone edits a Go file and forgets to convert it
+// again. In CI, `-check` catches that. The files get converted as usual,
+// but instead of writing the documents, `gotomarkdown` compares each one
+// with the output file that exists already. It lists the documents that
+// differ or are missing, and exits with an error if there are any.
+//
+// `-check` writes nothing: it copies no media files, writes no manifest,
+// and does not touch the existing documents.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// checkOutput is the OutputFS of `-check`. It compares the documents with
+// the files on the disk instead of writing them.
+type checkOutput struct {
+	sync.Mutex // convertFiles writes concurrently
+	stale      []string
+}
+
+func (c *checkOutput) WriteFile(name string, data []byte) error {
+	old, err := ioutil.ReadFile(name)
+	if err == nil && bytes.Equal(old, data) {
+		return nil
+	}
+	reason := " is out of date"
+	if err != nil {
+		reason = " is missing"
+	}
+	c.Lock()
+	c.stale = append(c.stale, name+reason)
+	c.Unlock()
+	return nil
+}
+
+func (c *checkOutput) Exists(name string) bool {
+	return osOutput{}.Exists(name)
+}
+
+// report returns the documents that are stale, in lexical order.
+func (c *checkOutput) report() []string {
+	sort.Strings(c.stale)
+	return c.stale
+}
+
+// result logs the stale documents, and returns an error if there are any,
+// which makes `gotomarkdown` fail. `n` is the number of documents checked.
+func (c *checkOutput) result(n int) error {
+	stale := c.report()
+	for _, s := range stale {
+		log.Println(s)
+	}
+	if len(stale) > 0 {
+		return errors.New(strconv.Itoa(len(stale)) + " of " + strconv.Itoa(n) + " documents are not up to date")
+	}
+	log.Println("All " + strconv.Itoa(n) + " documents are up to date.")
+	return nil
+}
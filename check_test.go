@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCheckOutput(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	var files []string
+	for _, name := range []string{"a.go", "b.go", "c.go"} {
+		f := filepath.Join(dir, name)
+		if err := os.WriteFile(f, []byte("// Text.\npackage p\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, f)
+	}
+	if _, err := convertFiles(files, Options{OutDir: out}); err != nil {
+		t.Fatal(err)
+	}
+	a, b, c := filepath.Join(out, "a.md"), filepath.Join(out, "b.md"), filepath.Join(out, "c.md")
+	before, err := os.ReadFile(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func() (stale []string, err error) {
+		checked := &checkOutput{}
+		if _, err := convertFiles(files, Options{OutDir: out, Jobs: 2, out: checked}); err != nil {
+			t.Fatal(err)
+		}
+		err = checked.result(len(files))
+		return checked.report(), err
+	}
+	if stale, err := check(); err != nil || len(stale) > 0 {
+		t.Errorf("up to date: got %v, error %v", stale, err)
+	}
+
+	// b.md gets edited, and c.md is gone. -check writes neither.
+	if err := os.WriteFile(b, []byte("edited"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(c); err != nil {
+		t.Fatal(err)
+	}
+	stale, err := check()
+	if err == nil {
+		t.Error("stale: got no error")
+	}
+	if want := []string{b + " is out of date", c + " is missing"}; !reflect.DeepEqual(stale, want) {
+		t.Errorf("stale: got %v, want %v", stale, want)
+	}
+	if got, _ := os.ReadFile(b); string(got) != "edited" {
+		t.Errorf("%s: got %q, want it unchanged", b, got)
+	}
+	if _, err := os.Stat(c); err == nil {
+		t.Errorf("%s: got a new file", c)
+	}
+	if got, _ := os.ReadFile(a); string(got) != string(before) {
+		t.Errorf("%s: got %q, want %q", a, got, before)
+	}
+}
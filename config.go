@@ -0,0 +1,127 @@
+// ## Reading defaults from a config file
+//
+// Flags that are used over and over again can go into a config file,
+// either `.gotomarkdown.toml` or `.gotomarkdown.yaml` in the current
+// directory, or the file given by `-config`. The file contains one setting
+// per line, named like the flag, as in:
+//
+//     outdir = "docs"
+//     nocopy = true
+//     heading-offset = 1
+//
+// (YAML files use `key: value` instead.) Only flat key-value pairs are
+// supported, which is all that flags need. Flags given on the command line
+// override the config file, which in turn overrides the built-in defaults.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// defaultConfigFiles are the config files searched for in the current
+// directory if `-config` is not given.
+var defaultConfigFiles = []string{".gotomarkdown.toml", ".gotomarkdown.yaml", ".gotomarkdown.yml"}
+
+// loadConfig applies the settings from the config file `name` to all flags
+// of `flags` that have not been set on the command line. If `name` is empty,
+// the default config files are tried. loadConfig must be called after
+// the flags are parsed.
+func loadConfig(flags *flag.FlagSet, name string) error {
+	explicit := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	if name == "" {
+		for _, candidate := range defaultConfigFiles {
+			if _, err := os.Stat(candidate); err == nil {
+				name = candidate
+				break
+			}
+		}
+		if name == "" {
+			return nil
+		}
+	}
+	settings, err := readConfig(name)
+	if err != nil {
+		return err
+	}
+	for _, s := range settings {
+		if flags.Lookup(s.key) == nil || s.key == "config" {
+			return errors.New("Unknown setting " + s.key + " in config file " + name + ", line " + strconv.Itoa(s.line))
+		}
+		if explicit[s.key] {
+			continue
+		}
+		err = flags.Set(s.key, s.value)
+		if err != nil {
+			return errors.New("Invalid value for " + s.key + " in config file " + name + ", line " + strconv.Itoa(s.line) + "\n" + err.Error())
+		}
+	}
+	return nil
+}
+
+// setting is a key-value pair from a config file.
+type setting struct {
+	key, value string
+	line       int
+}
+
+// readConfig reads the settings from a TOML or YAML config file.
+// Blank lines, comments, and TOML table headers are skipped.
+func readConfig(name string) (settings []setting, err error) {
+	content, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, errors.New("Cannot read config file " + name + "\n" + err.Error())
+	}
+	sep := "="
+	if ext := filepath.Ext(name); ext == ".yaml" || ext == ".yml" {
+		sep = ":"
+	}
+	for i, line := range strings.Split(strings.Replace(string(content), "\r", "", -1), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "[") || line == "---" {
+			continue
+		}
+		kv := strings.SplitN(line, sep, 2)
+		if len(kv) != 2 {
+			return nil, errors.New("Cannot parse config file " + name + ", line " + strconv.Itoa(i+1) + ":\n" + line)
+		}
+		value, err := configValue(strings.TrimSpace(kv[1]))
+		if err != nil {
+			return nil, errors.New("Cannot parse config file " + name + ", line " + strconv.Itoa(i+1) + ":\n" + line + "\n" + err.Error())
+		}
+		settings = append(settings, setting{key: strings.Trim(strings.TrimSpace(kv[0]), `"'`), value: value, line: i + 1})
+	}
+	return settings, nil
+}
+
+// configValue returns the plain value of a config value, without quotes
+// and trailing comments.
+func configValue(v string) (string, error) {
+	switch {
+	case strings.HasPrefix(v, `"`):
+		end := strings.LastIndex(v, `"`)
+		if end == 0 {
+			return "", errors.New("Unterminated string")
+		}
+		return strconv.Unquote(v[:end+1])
+	case strings.HasPrefix(v, "'"):
+		end := strings.LastIndex(v, "'")
+		if end == 0 {
+			return "", errors.New("Unterminated string")
+		}
+		return v[1:end], nil
+	}
+	if i := strings.Index(v, " #"); i >= 0 {
+		v = v[:i]
+	}
+	return strings.TrimSpace(v), nil
+}
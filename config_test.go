@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name, file, content string
+	}{
+		{
+			name:    "TOML",
+			file:    ".gotomarkdown.toml",
+			content: "# Defaults\n[flags]\noutdir = \"docs\" # the output\nnocopy = true\nheading-offset = 1\n",
+		},
+		{
+			name:    "YAML",
+			file:    ".gotomarkdown.yaml",
+			content: "---\noutdir: 'docs'\nnocopy: true\nheading-offset: 1\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			if err := os.WriteFile(tt.file, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range []string{"", tt.file} {
+				var opts Options
+				flags := flag.NewFlagSet("gotomarkdown", flag.ContinueOnError)
+				flags.StringVar(&opts.OutDir, "outdir", "out", "")
+				flags.BoolVar(&opts.NoCopy, "nocopy", false, "")
+				flags.IntVar(&opts.HeadingOffset, "heading-offset", 0, "")
+				flags.String("config", "", "")
+				// A flag on the command line overrides the config file,
+				// which overrides the defaults.
+				if err := flags.Parse([]string{"-heading-offset", "2"}); err != nil {
+					t.Fatal(err)
+				}
+				if err := loadConfig(flags, name); err != nil {
+					t.Fatal(err)
+				}
+				if opts.OutDir != "docs" || !opts.NoCopy || opts.HeadingOffset != 2 {
+					t.Errorf("config %q: got outdir %q, nocopy %v, heading-offset %d, want docs, true, 2", name, opts.OutDir, opts.NoCopy, opts.HeadingOffset)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"unknown.toml": "verbose = true\n",
+		"config.toml":  "config = \"other.toml\"\n",
+		"invalid.toml": "nocopy = maybe\n",
+		"syntax.toml":  "nocopy\n",
+		"quote.yaml":   "outdir: \"docs\n",
+	} {
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		flags := flag.NewFlagSet("gotomarkdown", flag.ContinueOnError)
+		flags.String("outdir", "out", "")
+		flags.Bool("nocopy", false, "")
+		flags.String("config", "", "")
+		if err := loadConfig(flags, file); err == nil {
+			t.Errorf("%s: got no error", name)
+		}
+	}
+}
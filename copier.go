@@ -0,0 +1,85 @@
+// ## Copying media files
+//
+// `gotomarkdown` leaves the copying to the tools of the operating system,
+// which know best how to copy whole directories (like Hype's
+// `hyperesources`) with all their attributes. These tools differ between
+// platforms, so a `copier` hides them behind a common interface.
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+)
+
+// copier copies a file or a directory tree from `src` to `dest`, and
+// creates the missing directories above `dest`.
+type copier interface {
+	copy(src, dest string) error
+}
+
+// mediaCopier is the copier that `copyFiles` uses.
+var mediaCopier = newCopier(runtime.GOOS)
+
+// newCopier returns the copier for the operating system `goos`.
+func newCopier(goos string) copier {
+	if goos == "windows" {
+		return windowsCopier{}
+	}
+	return cpCopier{}
+}
+
+// cpCopier copies with `cp -R`, which is available on macOS, Linux, and
+// the other Unix systems.
+type cpCopier struct{}
+
+func (cpCopier) copy(src, dest string) error {
+	err := createPath(filepath.Dir(dest))
+	if err != nil {
+		return err
+	}
+	return run(exec.Command("cp", "-R", src, dest))
+}
+
+// windowsCopier copies directories with `robocopy` and single files with
+// `copy`, which is a built-in command of `cmd`.
+type windowsCopier struct{}
+
+func (windowsCopier) copy(src, dest string) error {
+	src, dest = filepath.FromSlash(src), filepath.FromSlash(dest)
+	err := createPath(filepath.Dir(dest))
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return run(exec.Command("cmd", "/C", "copy", "/Y", src, dest))
+	}
+	result, err := exec.Command("robocopy", src, dest, "/E", "/NFL", "/NDL", "/NJH", "/NJS").CombinedOutput()
+	// robocopy reports success with exit codes below 8.
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if exitErr.ExitCode() < 8 {
+			return nil
+		}
+		return errors.New(string(result) + "\n" + err.Error())
+	}
+	return err
+}
+
+// run runs `cmd` and adds its output to the error, if any.
+func run(cmd *exec.Cmd) error {
+	result, err := cmd.CombinedOutput()
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			return errors.New(string(result) + "\n" + err.Error())
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewCopier(t *testing.T) {
+	for goos, want := range map[string]copier{
+		"windows": windowsCopier{},
+		"linux":   cpCopier{},
+		"darwin":  cpCopier{},
+	} {
+		if got := newCopier(goos); reflect.TypeOf(got) != reflect.TypeOf(want) {
+			t.Errorf("%s: got %T, want %T", goos, got, want)
+		}
+	}
+}
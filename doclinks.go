@@ -0,0 +1,61 @@
+// ## Doc links
+//
+// Since Go 1.19, doc comments can link to declarations by their name in
+// brackets, like `[Reader]` or `[Reader.Read]`. Markdown has no idea what
+// these are, so they end up as plain text in brackets. With
+// `-doclinks https://pkg.go.dev/example.com/mypkg`, `gotomarkdown` turns
+// them into links to the documentation:
+//
+//     [Reader](https://pkg.go.dev/example.com/mypkg#Reader)
+//
+// Only names that start with an upper case letter count as doc links, as
+// only exported names have documentation. Names qualified by a package,
+// like `[io.Reader]`, are left as they are. So are brackets within code
+// spans and code fences, and brackets that are part of a Markdown link,
+// like `[text](url)`, `[text][id]`, or a reference definition `[id]: url`.
+
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// docLink matches a doc link; submatch 1 is the name, without a leading "*".
+var docLink = regexp.MustCompile(`\[\*?([A-Z]\w*(?:\.[A-Za-z_]\w*)?)\]`)
+
+// replaceDocLinks turns the doc links in the prose line `text` into links
+// to `base`.
+func replaceDocLinks(text, base string) string {
+	// Code spans are left alone. The parts of the line at odd indexes are
+	// within code spans, or follow an unmatched backtick.
+	parts := strings.Split(text, "`")
+	for i := 0; i < len(parts); i += 2 {
+		parts[i] = replaceDocLinksIn(parts[i], base)
+	}
+	return strings.Join(parts, "`")
+}
+
+// replaceDocLinksIn turns the doc links in `s`, which contains no code
+// spans, into links to `base`.
+func replaceDocLinksIn(s, base string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range docLink.FindAllStringSubmatchIndex(s, -1) {
+		start, end := m[0], m[1]
+		// Skip images, the second part of `[text][id]`, and brackets
+		// followed by a link target, a reference, or a colon.
+		if start > 0 && (s[start-1] == '!' || s[start-1] == ']') {
+			continue
+		}
+		if end < len(s) && strings.ContainsRune("([:", rune(s[end])) {
+			continue
+		}
+		name := s[m[2]:m[3]]
+		b.WriteString(s[last:start])
+		b.WriteString(s[start:end] + "(" + strings.TrimSuffix(base, "/") + "#" + name + ")")
+		last = end
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestReplaceDocLinks(t *testing.T) {
+	const base = "https://pkg.go.dev/example.com/p/"
+	tests := []struct {
+		text, want string
+	}{
+		{"See [Reader].", "See [Reader](https://pkg.go.dev/example.com/p#Reader)."},
+		{"Call [NewReader] or [Reader.Read].", "Call [NewReader](https://pkg.go.dev/example.com/p#NewReader) or [Reader.Read](https://pkg.go.dev/example.com/p#Reader.Read)."},
+		{"A pointer: [*Reader]", "A pointer: [*Reader](https://pkg.go.dev/example.com/p#Reader)"},
+		{"Write `m[Key]` in code.", "Write `m[Key]` in code."},
+		{"Not [io.Reader], [lower], or [Text](url).", "Not [io.Reader], [lower], or [Text](url)."},
+		{"Not ![Logo][Id] or [Id]: url", "Not ![Logo][Id] or [Id]: url"},
+	}
+	for _, tt := range tests {
+		if got := replaceDocLinks(tt.text, base); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestDocLinksInCode(t *testing.T) {
+	const in = "// See [Reader].\n//\n//     r := rs[Reader]\n//\n// ```\n// m[Key]\n// ```\nvar m = map[string]int{}\nvar _ = m[Key]\n"
+	const want = "See [Reader](https://pkg.go.dev/p#Reader).\n\n    r := rs[Reader]\n\n```\nm[Key]\n```\n\n```go\nvar m = map[string]int{}\nvar _ = m[Key]\n```\n"
+	got, _, err := convert(in, Options{DocLinks: "https://pkg.go.dev/p"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("got\n%q\nwant\n%q", got, want)
+	}
+}
@@ -0,0 +1,62 @@
+// ## Embedding images
+//
+// A document that should be a single, self-contained file cannot refer to
+// image files next to it. With `-embed-images`, `gotomarkdown` reads each
+// local image and puts it right into the image tag, as a `data:` URI:
+//
+//     ![Logo](data:image/png;base64,iVBORw0KGgo...)
+//
+// The images are then not copied. Remote images stay as they are.
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/fs"
+	"mime"
+	"path/filepath"
+	"strings"
+)
+
+// dataURI returns the content of the file `name` in `fsys` as a `data:`
+// URI. The MIME type comes from the file extension, or else from the content.
+func dataURI(fsys fs.FS, name string) (string, error) {
+	content, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return "", err
+	}
+	typ := mime.TypeByExtension(strings.ToLower(filepath.Ext(name)))
+	if typ == "" {
+		typ = sniffImage(content)
+	}
+	// Parameters like "; charset=utf-8" are of no use here.
+	if i := strings.Index(typ, ";"); i >= 0 {
+		typ = strings.TrimSpace(typ[:i])
+	}
+	return "data:" + typ + ";base64," + base64.StdEncoding.EncodeToString(content), nil
+}
+
+// imageMagic maps the first bytes of the common image formats to their
+// MIME types. Images of other formats have a file extension that
+// `mime.TypeByExtension` knows.
+var imageMagic = []struct {
+	magic, typ string
+}{
+	{"\x89PNG\r\n\x1a\n", "image/png"},
+	{"\xff\xd8\xff", "image/jpeg"},
+	{"GIF87a", "image/gif"},
+	{"GIF89a", "image/gif"},
+	{"<svg", "image/svg+xml"},
+}
+
+// sniffImage returns the MIME type of the image `content` of a file
+// without a known extension.
+func sniffImage(content []byte) string {
+	for _, m := range imageMagic {
+		if bytes.HasPrefix(content, []byte(m.magic)) {
+			return m.typ
+		}
+	}
+	return "application/octet-stream"
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestDataURI(t *testing.T) {
+	const png = "\x89PNG\r\n\x1a\n\x00\x00"
+	fsys := fstest.MapFS{
+		"logo.png":  {Data: []byte(png)},
+		"logo.PNG":  {Data: []byte(png)},
+		"logo":      {Data: []byte(png)},
+		"photo":     {Data: []byte("\xff\xd8\xff\xe0")},
+		"icon.svg":  {Data: []byte("<svg/>")},
+		"data.blob": {Data: []byte("\x00\x01")},
+	}
+	tests := []struct {
+		name, want string
+	}{
+		{"logo.png", "data:image/png;base64,"},
+		{"logo.PNG", "data:image/png;base64,"},
+		{"logo", "data:image/png;base64,"},
+		{"photo", "data:image/jpeg;base64,"},
+		{"icon.svg", "data:image/svg+xml;base64,"},
+		{"data.blob", "data:application/octet-stream;base64,"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := dataURI(fsys, tt.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(got, tt.want) {
+				t.Errorf("got %q, want prefix %q", got, tt.want)
+			}
+		})
+	}
+	if _, err := dataURI(fsys, "missing.png"); err == nil {
+		t.Error("missing.png: got no error")
+	}
+}
+
+func TestEmbedImages(t *testing.T) {
+	src := fstest.MapFS{
+		"doc.go":   {Data: []byte("// ![logo](logo.png) ![remote](https://example.com/r.png)\npackage doc\n")},
+		"logo.png": {Data: []byte("\x89PNG\r\n\x1a\n")},
+	}
+	out := MemOutput{}
+	fc := NewFileConverter(src, out, Options{OutDir: "out", EmbedImages: true})
+	media, err := fc.Convert("doc.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(media) > 0 {
+		t.Errorf("got media %v", media)
+	}
+	const want = "![logo](data:image/png;base64,iVBORw0KGgo=) ![remote](https://example.com/r.png)\n\n```go\npackage doc\n```\n"
+	if got := string(out["out/doc.md"]); got != want {
+		t.Errorf("got\n%q\nwant\n%q", got, want)
+	}
+}
@@ -0,0 +1,155 @@
+// ## Output formats
+//
+// The converter finds out which lines are prose and which are code. How
+// these end up in the output is up to an emitter. The default emitter
+// writes Markdown. With `-format asciidoc`, the output is AsciiDoc instead:
+//
+// * Headings like `## Title` become `== Title`.
+// * Code blocks get delimited by `[source,go]` and `----`.
+// * Images like `![alt](path.png)` become `image::path.png[alt]`, or
+//   `image:path.png[alt]` within a line of text, and links like
+//   `[text](url)` become `link:url[text]`.
+//
+// Comments are still written in Markdown, so the AsciiDoc emitter converts
+// only the few constructs above. Fenced code blocks within comments pass
+// through, as Asciidoctor understands them, too.
+
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// emitter turns the lines that the converter found into an output format.
+type emitter interface {
+	// openCode returns the opening of a code block with the info string
+	// `info`, or with the default info string if `info` is empty.
+	// "```" stands for the fence that `fence` returns.
+	openCode(info string) string
+	// closeCode returns the closing line of a code block, with "```" for
+	// the fence.
+	closeCode() string
+	// fence returns a fence that does not occur within the code `lines`.
+	fence(lines []string) string
+	// prose converts a line of Markdown prose.
+	prose(text string) string
+}
+
+// newEmitter returns the emitter for the output format in `opts`.
+func newEmitter(opts Options) emitter {
+	lang := opts.lang.fence
+	if lang == "" {
+		lang = "go"
+	}
+	if opts.Format == "asciidoc" {
+		return asciidocEmitter{lang: lang, lineNumbers: opts.LineNumbers}
+	}
+	return markdownEmitter{lang: lang, indented: opts.CodeStyle == "indented", lineNumbers: opts.LineNumbers}
+}
+
+// ### Markdown
+
+// markdownEmitter writes fenced code blocks, or with `indented`, code
+// blocks that are indented by the converter. Prose passes through as it is.
+type markdownEmitter struct {
+	lang        string // the fence language, like "go"
+	indented    bool
+	lineNumbers bool
+}
+
+func (e markdownEmitter) openCode(info string) string {
+	switch {
+	case e.indented:
+		return ""
+	case info != "":
+		return "```" + info + "\n"
+	case e.lineNumbers:
+		// Hugo and others understand `{linenos=true}`.
+		return "```" + e.lang + " {linenos=true}\n"
+	}
+	return "```" + e.lang + "\n"
+}
+
+func (e markdownEmitter) closeCode() string {
+	if e.indented {
+		return ""
+	}
+	return "```\n"
+}
+
+func (e markdownEmitter) fence(lines []string) string {
+	return codeFence(lines)
+}
+
+func (e markdownEmitter) prose(text string) string {
+	return text
+}
+
+// ### AsciiDoc
+
+var (
+	adocImage = regexp.MustCompile(`!\[([^\]]*)\]\( *([^"\) ]+) *(?:"[^"]*")? *\)`) // Markdown image; submatches are alt and path
+	adocLink  = regexp.MustCompile(`\[([^\]]+)\]\( *([^"\) ]+) *(?:"[^"]*")? *\)`)  // Markdown link; submatches are text and target
+)
+
+// asciidocEmitter writes AsciiDoc.
+type asciidocEmitter struct {
+	lang        string // the source language, like "go"
+	lineNumbers bool
+}
+
+func (e asciidocEmitter) openCode(info string) string {
+	lang := e.lang
+	if f := strings.Fields(info); len(f) > 0 {
+		lang = f[0]
+	}
+	if e.lineNumbers {
+		lang += ",linenums"
+	}
+	return "[source," + lang + "]\n```\n"
+}
+
+func (e asciidocEmitter) closeCode() string {
+	return "```\n"
+}
+
+// fence returns a listing delimiter of at least four dashes, and longer
+// than any line of dashes in `lines`.
+func (e asciidocEmitter) fence(lines []string) string {
+	n := 4
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line != "" && strings.Trim(line, "-") == "" && len(line) >= n {
+			n = len(line) + 1
+		}
+	}
+	return strings.Repeat("-", n)
+}
+
+// prose converts headings, images, and links. An explicit id from
+// `-anchors`, like `{#id}`, goes into a block anchor above the title.
+func (e asciidocEmitter) prose(text string) string {
+	if m := heading.FindStringSubmatchIndex(text); m != nil {
+		level := m[5] - m[4]
+		title := strings.TrimSpace(strings.TrimRight(strings.TrimSpace(text[m[1]:]), "#"))
+		anchor := ""
+		if id := headingID.FindStringSubmatch(title); id != nil {
+			title = headingID.ReplaceAllString(title, "")
+			anchor = "[#" + id[1] + "]\n"
+		}
+		return anchor + strings.Repeat("=", level) + " " + e.inline(title)
+	}
+	// An image on a line of its own is a block image.
+	trimmed := strings.TrimSpace(text)
+	if m := adocImage.FindStringSubmatch(trimmed); m != nil && m[0] == trimmed {
+		return "image::" + m[2] + "[" + m[1] + "]"
+	}
+	return e.inline(text)
+}
+
+// inline converts the images and links within `text`.
+func (e asciidocEmitter) inline(text string) string {
+	text = adocImage.ReplaceAllString(text, "image:$2[$1]")
+	return adocLink.ReplaceAllString(text, "link:$2[$1]")
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAsciidoc(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+		opts           Options
+		media          map[string]string
+	}{
+		{
+			name: "headings",
+			in:   "// # Title\n//\n// ### Sub\n//\n// Text.\npackage p\n",
+			want: "= Title\n\n=== Sub\n\nText.\n\n[source,go]\n----\npackage p\n----\n",
+		},
+		{
+			name: "heading with an anchor",
+			in:   "// ## Getting Started\npackage p\n",
+			opts: Options{Anchors: true},
+			want: "[#getting-started]\n== Getting Started\n\n[source,go]\n----\npackage p\n----\n",
+		},
+		{
+			name: "code",
+			in:   "package p\n\nvar s = `\n----\n`\n",
+			want: "[source,go]\n-----\npackage p\n\nvar s = `\n----\n`\n-----\n",
+		},
+		{
+			name: "code with line numbers",
+			in:   "package p\n",
+			opts: Options{LineNumbers: true},
+			want: "[source,go,linenums]\n----\npackage p\n----\n",
+		},
+		{
+			name:  "images and links",
+			in:    "// Text with ![logo](img/logo.png) and [a link](https://x.org).\n//\n// ![diagram](d.png)\npackage p\n",
+			want:  "Text with image:img/logo.png[logo] and link:https://x.org[a link].\n\nimage::d.png[diagram]\n\n[source,go]\n----\npackage p\n----\n",
+			media: map[string]string{"img/logo.png": "img/logo.png", "d.png": "d.png"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.Format = "asciidoc"
+			got, media, err := convert(tt.in, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got\n%q\nwant\n%q", got, tt.want)
+			}
+			if len(media)+len(tt.media) > 0 && !reflect.DeepEqual(media, tt.media) {
+				t.Errorf("got media %v, want %v", media, tt.media)
+			}
+		})
+	}
+}
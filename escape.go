@@ -0,0 +1,137 @@
+// ## Escaping prose
+//
+// Comments are written for Go readers first. A comment like
+// "returns a List<T> of *all* the a_b_c items" means no HTML tag and no
+// emphasis, but a Markdown renderer does not know that. With
+// `-strict-markdown`, `gotomarkdown` escapes the characters in prose that
+// would be taken as markup by accident:
+//
+// * `<` and `>` become `&lt;` and `&gt;`, except in autolinks like
+//   `<https://go.dev>`, in HTML comments, and in tags of common HTML
+//   elements like `<details>` or `<br>`.
+// * `*` and `_` get a backslash, unless they come in pairs that look like
+//   emphasis, as in `*all*` or `__bold__`, or start a list item.
+//
+// Code spans, link targets, URLs, heading ids like `{#a_b}`, and reference
+// definitions are left alone, and so are characters that are escaped already.
+
+package main
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var (
+	// proseMarkers matches the blockquote and list markers at the start of a line.
+	proseMarkers = regexp.MustCompile(`^\s*(?:>\s*)*(?:[*+-]\s+|\d+[.)]\s+)?`)
+	// proseProtected matches the parts of a line that are no plain prose.
+	proseProtected = regexp.MustCompile("(`+).*?(?:`+|$)" + // code span
+		`|\]\([^)]*\)` + // link target
+		`|<[a-zA-Z][a-zA-Z0-9+.-]*:[^>\s]*>` + // autolink
+		`|<!--.*?-->` + // HTML comment
+		`|</?(?:a|abbr|b|br|code|dd|del|details|div|dl|dt|em|hr|i|img|ins|kbd|li|ol|p|pre|s|small|span|strong|sub|summary|sup|table|tbody|td|th|thead|tr|u|ul)(?:\s[^>]*)?/?>` + // HTML tag
+		`|https?://\S+` + // URL
+		`|\{#[^}\s]+\}\s*$` + // heading id, as from -anchors
+		`|^ {0,3}\[[^\]]+\]:.*$`) // reference definition
+)
+
+// escapeProse escapes the accidental markup in the prose line `text`.
+func escapeProse(text string) string {
+	prefix := proseMarkers.FindString(text)
+	body := text[len(prefix):]
+	protected := make([]bool, len(body))
+	for _, loc := range proseProtected.FindAllStringIndex(body, -1) {
+		for i := loc[0]; i < loc[1]; i++ {
+			protected[i] = true
+		}
+	}
+
+	// Find the runs of `*` and `_`, and pair the ones that look like
+	// emphasis. Only unpaired runs get escaped.
+	type run struct {
+		start, end  int
+		open, close bool
+	}
+	var runs []run
+	for i := 0; i < len(body); i++ {
+		ch := body[i]
+		if protected[i] || (ch != '*' && ch != '_') {
+			if ch == '\\' {
+				i++ // skip the escaped character
+			}
+			continue
+		}
+		j := i
+		for j < len(body) && body[j] == ch && !protected[j] {
+			j++
+		}
+		before, after := rune(' '), rune(' ')
+		if i > 0 {
+			before = rune(body[i-1])
+		}
+		if j < len(body) {
+			after = rune(body[j])
+		}
+		word := func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }
+		intraword := word(before) && word(after)
+		runs = append(runs, run{
+			start: i,
+			end:   j,
+			open:  !unicode.IsSpace(after) && !intraword,
+			close: !unicode.IsSpace(before) && !intraword,
+		})
+		i = j - 1
+	}
+	paired := make([]bool, len(runs))
+	var openers []int
+	for k, r := range runs {
+		if r.close {
+			for o := len(openers) - 1; o >= 0; o-- {
+				op := runs[openers[o]]
+				if body[op.start] == body[r.start] && op.end-op.start == r.end-r.start {
+					paired[openers[o]], paired[k] = true, true
+					openers = openers[:o]
+					break
+				}
+			}
+			if paired[k] {
+				continue
+			}
+		}
+		if r.open {
+			openers = append(openers, k)
+		}
+	}
+	escape := make([]bool, len(body))
+	for k, r := range runs {
+		if !paired[k] {
+			for i := r.start; i < r.end; i++ {
+				escape[i] = true
+			}
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(prefix)
+	for i := 0; i < len(body); i++ {
+		ch := body[i]
+		switch {
+		case protected[i]:
+			b.WriteByte(ch)
+		case ch == '\\' && i+1 < len(body):
+			b.WriteString(body[i : i+2])
+			i++
+		case ch == '<':
+			b.WriteString("&lt;")
+		case ch == '>':
+			b.WriteString("&gt;")
+		case escape[i]:
+			b.WriteString("\\" + string(ch))
+		default:
+			b.WriteByte(ch)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestEscapeProse(t *testing.T) {
+	tests := []struct {
+		text, want string
+	}{
+		{"returns a List<T> of *all* the a_b_c items", `returns a List&lt;T&gt; of *all* the a\_b\_c items`},
+		{"* a list item with 2 * 3", `* a list item with 2 \* 3`},
+		{"__bold__ text", "__bold__ text"},
+		{`already \_escaped`, `already \_escaped`},
+		{"see `a_b` and [x](a_b.md) and https://x.org/a_b", "see `a_b` and [x](a_b.md) and https://x.org/a_b"},
+		{"<details> and <https://go.dev> and <!-- a_b -->", "<details> and <https://go.dev> and <!-- a_b -->"},
+		{"[a_b]: a_b.png", "[a_b]: a_b.png"},
+		{"## Converting a_file {#converting-a_file}", `## Converting a\_file {#converting-a_file}`},
+	}
+	for _, tt := range tests {
+		if got := escapeProse(tt.text); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
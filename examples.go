@@ -0,0 +1,161 @@
+// ## Example functions
+//
+// Go documents code with `Example...` functions in `_test.go` files. Their
+// `// Output:` comment tells what the example prints. With `-examples`,
+// `gotomarkdown` turns each example function in a `_test.go` file into the
+// code of its body, followed by the output in an `output` code block, so
+// that the document shows the example as one would write it in `main`.
+//
+// The function boundaries come from `go/parser`. The Go source is then
+// rewritten before the conversion: the example function becomes its
+// unindented body, plus a comment with the output.
+//
+// Output comments also appear outside of `_test.go` files, in example code
+// that a tutorial shows. Wherever an `// Output:` comment directly follows
+// a line of code, the lines of the comment that come after it become a
+// `text` code block below an "Output:" line, instead of prose.
+
+package main
+
+import (
+	"errors"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// outputPrefixes are the prefixes of an example's output comment.
+var outputPrefixes = []string{"Output:", "Unordered output:"}
+
+// outputComment matches the first line of an output comment within code.
+// Submatch 3 is the first line of the output, as in `// Output: 42`.
+var outputComment = regexp.MustCompile(`^(\s*)// ?((?:Unordered o|O)utput:)\s*(.*?)\s*$`)
+
+// closingLine matches a line that only closes blocks, like the `}` at the
+// end of a function.
+var closingLine = regexp.MustCompile(`^\s*[})\]]+[,;]?\s*$`)
+
+// rewriteExamples rewrites the example functions in `src`, the source of
+// the file `filename`.
+func rewriteExamples(src, filename string) (string, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, filename, src, parser.ParseComments)
+	if err != nil {
+		return "", errors.New("Cannot parse the examples in " + filename + "\n" + err.Error())
+	}
+	file := fset.File(f.Pos())
+	type replacement struct {
+		start, end int
+		text       string
+	}
+	var repl []replacement
+	for _, decl := range f.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Recv != nil || fn.Body == nil || !strings.HasPrefix(fn.Name.Name, "Example") || fn.Type.Params.NumFields() > 0 {
+			continue
+		}
+		bodyEnd := fn.Body.Rbrace
+		output, found := "", false
+		for _, cg := range f.Comments {
+			if cg.Pos() < fn.Body.Lbrace || cg.End() > fn.Body.Rbrace {
+				continue
+			}
+			if text, ok := exampleOutput(cg); ok {
+				bodyEnd, output, found = cg.Pos(), text, true
+			}
+		}
+		code := src[file.Offset(fn.Body.Lbrace)+1 : file.Offset(bodyEnd)]
+		text := unindent(code)
+		if found {
+			text += "\n\n// Output:\n//\n// ```output\n"
+			for _, line := range strings.Split(strings.TrimRight(output, "\n"), "\n") {
+				text += strings.TrimRight("// "+line, " ") + "\n"
+			}
+			text += "// ```"
+		}
+		repl = append(repl, replacement{file.Offset(fn.Type.Func), file.Offset(fn.Body.Rbrace) + 1, text})
+	}
+	// Replace from the end, so that the offsets stay valid.
+	sort.Slice(repl, func(i, j int) bool { return repl[i].start > repl[j].start })
+	for _, r := range repl {
+		src = src[:r.start] + r.text + src[r.end:]
+	}
+	return src, nil
+}
+
+// exampleOutput returns the text of the output comment `cg`, and false if
+// `cg` is no output comment.
+func exampleOutput(cg *ast.CommentGroup) (string, bool) {
+	text := cg.Text()
+	for _, prefix := range outputPrefixes {
+		if strings.HasPrefix(text, prefix) {
+			return strings.TrimLeft(strings.TrimPrefix(text, prefix), " \n"), true
+		}
+	}
+	return "", false
+}
+
+// unindent removes the blank lines around `code` and one level of
+// indentation from each line.
+func unindent(code string) string {
+	lines := strings.Split(strings.Trim(code, "\n"), "\n")
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	for i, line := range lines {
+		lines[i] = strings.TrimPrefix(line, "\t")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// rewriteOutputs turns the output comments that directly follow code in
+// `src` into comments with a `text` code block. Lines right after the
+// output that only close blocks, like the `}` of the function, go before
+// it, so that they stay with the code instead of making a code block of
+// their own. The lineMap tells the original line of each line of the
+// result.
+func rewriteOutputs(src string) (string, lineMap) {
+	lines := strings.Split(src, "\n")
+	out := make([]string, 0, len(lines))
+	var origin lineMap
+	add := func(line string, i int) {
+		out = append(out, line)
+		origin = append(origin, i+1)
+	}
+	for i := 0; i < len(lines); i++ {
+		m := outputComment.FindStringSubmatch(lines[i])
+		if m == nil || i == 0 || strings.TrimSpace(lines[i-1]) == "" || comment.MatchString(lines[i-1]) || commentStart.MatchString(lines[i-1]) {
+			add(lines[i], i)
+			continue
+		}
+		j := i + 1
+		for j < len(lines) && comment.MatchString(lines[j]) && !gtmDirective.MatchString(lines[j]) {
+			j++
+		}
+		if j == i+1 && m[3] == "" {
+			add(lines[i], i)
+			continue
+		}
+		end := j
+		for end < len(lines) && closingLine.MatchString(lines[end]) {
+			add(lines[end], end)
+			end++
+		}
+		indent := m[1]
+		add(indent+"// "+m[2], i)
+		add(indent+"//", i)
+		add(indent+"// ```text", i)
+		if m[3] != "" {
+			add(indent+"// "+m[3], i)
+		}
+		for k := i + 1; k < j; k++ {
+			add(indent+"// "+stripOnce(comment, lines[k]), k)
+		}
+		add(indent+"// ```", j-1)
+		i = end - 1
+	}
+	return strings.Join(out, "\n"), origin
+}
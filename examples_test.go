@@ -0,0 +1,111 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRewriteExamples(t *testing.T) {
+	tests := []struct {
+		name, src, want string
+	}{
+		{
+			name: "output",
+			src:  "package p\n\nfunc ExampleF() {\n\tfmt.Println(42)\n\t// Output: 42\n}\n",
+			want: "package p\n\nfmt.Println(42)\n\n// Output:\n//\n// ```output\n// 42\n// ```\n",
+		},
+		{
+			name: "unordered output",
+			src:  "package p\n\nfunc ExampleF() {\n\tfmt.Println(1)\n\tfmt.Println(2)\n\t// Unordered output:\n\t// 2\n\t// 1\n}\n",
+			want: "package p\n\nfmt.Println(1)\nfmt.Println(2)\n\n// Output:\n//\n// ```output\n// 2\n// 1\n// ```\n",
+		},
+		{
+			name: "no output",
+			src:  "package p\n\nfunc ExampleG() {\n\tg()\n}\n",
+			want: "package p\n\ng()\n",
+		},
+		{
+			name: "no example functions",
+			src:  "package p\n\nfunc Examplex(t int) {\n\tg()\n}\n\nfunc helper() {\n\th()\n}\n",
+			want: "package p\n\nfunc Examplex(t int) {\n\tg()\n}\n\nfunc helper() {\n\th()\n}\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := rewriteExamples(tt.src, "x_test.go")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRewriteExamplesSyntaxError(t *testing.T) {
+	if _, err := rewriteExamples("package p\n\nfunc ExampleF() {\n", "x_test.go"); err == nil {
+		t.Error("got no error")
+	}
+}
+
+func TestRewriteOutputs(t *testing.T) {
+	tests := []struct {
+		name, src, want string
+		lines           lineMap
+	}{
+		{
+			name:  "inline output",
+			src:   "fmt.Println(42)\n// Output: 42\n",
+			want:  "fmt.Println(42)\n// Output:\n//\n// ```text\n// 42\n// ```\n",
+			lines: lineMap{1, 2, 2, 2, 2, 2, 3},
+		},
+		{
+			name:  "output lines",
+			src:   "\tfmt.Println(1)\n\t// Output:\n\t// 1\n\t// 2\nx()\n",
+			want:  "\tfmt.Println(1)\n\t// Output:\n\t//\n\t// ```text\n\t// 1\n\t// 2\n\t// ```\nx()\n",
+			lines: lineMap{1, 2, 2, 2, 3, 4, 4, 5, 6},
+		},
+		{
+			name:  "before the end of a function",
+			src:   "func f() {\n\tg()\n\t// Output: 1\n}\n\nfunc h() {}\n",
+			want:  "func f() {\n\tg()\n}\n\t// Output:\n\t//\n\t// ```text\n\t// 1\n\t// ```\n\nfunc h() {}\n",
+			lines: lineMap{1, 2, 4, 3, 3, 3, 3, 3, 5, 6, 7},
+		},
+		{
+			name:  "ends before a directive",
+			src:   "f()\n// Output:\n// a\n//gtm:skip\n",
+			want:  "f()\n// Output:\n//\n// ```text\n// a\n// ```\n//gtm:skip\n",
+			lines: lineMap{1, 2, 2, 2, 3, 3, 4, 5},
+		},
+		{
+			name:  "after prose",
+			src:   "// Prose.\n// Output: no\n",
+			want:  "// Prose.\n// Output: no\n",
+			lines: lineMap{1, 2, 3},
+		},
+		{
+			name:  "after a blank line",
+			src:   "\n// Output:\n// x\n",
+			want:  "\n// Output:\n// x\n",
+			lines: lineMap{1, 2, 3, 4},
+		},
+		{
+			name:  "no output",
+			src:   "f()\n// Output:\n",
+			want:  "f()\n// Output:\n",
+			lines: lineMap{1, 2, 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, lines := rewriteOutputs(tt.src)
+			if got != tt.want {
+				t.Errorf("got\n%q\nwant\n%q", got, tt.want)
+			}
+			if !reflect.DeepEqual(lines, tt.lines) {
+				t.Errorf("got lines %v, want %v", lines, tt.lines)
+			}
+		})
+	}
+}
@@ -0,0 +1,307 @@
+// ## Documenting the API only
+//
+// A literate Go file explains everything, down to the last helper. For
+// the API documentation of a package, only the exported declarations
+// matter. With `-only-exported`, `gotomarkdown` keeps the package comment,
+// the package clause, and the exported functions, methods, types,
+// constants, and variables with their doc comments, in the order of the
+// file. Functions and methods show their signature without the body.
+// Everything else goes, including imports, unexported helpers, unexported
+// struct fields, and the comments of unexported declarations. Comments
+// that belong to no declaration, like an introduction at the top of the
+// file, stay where they are.
+//
+// `go/doc` decides what counts as exported, just like for `go doc`. For
+// example, an exported method of an unexported type is not part of the API.
+// It also filters the declarations, which `go/printer` then prints.
+// `go/printer` keeps the lines of the filtered fields and specs as blank
+// lines, though, so these lines get removed from the source first.
+
+package main
+
+import (
+	"errors"
+	"go/ast"
+	"go/doc"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"strings"
+)
+
+// rewriteExported returns the package comment, the package clause, the
+// exported declarations, and the free comments of `src`, the source of
+// the file `filename`.
+func rewriteExported(src, filename string) (string, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, filename, src, parser.ParseComments)
+	if err != nil {
+		return "", errors.New("Cannot parse " + filename + " for -only-exported\n" + err.Error())
+	}
+	file := fset.File(f.Pos())
+	text := func(cg *ast.CommentGroup) string {
+		if cg == nil {
+			return ""
+		}
+		return src[file.Offset(cg.Pos()):file.Offset(cg.End())] + "\n"
+	}
+	// go/doc takes the comments out of the syntax tree, so keep the doc
+	// comments and the free comments as they are in the source.
+	pkgDoc := text(f.Doc)
+	free := freeComments(f, file)
+	docs := map[ast.Decl]string{}
+	for _, decl := range f.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			docs[decl] = text(d.Doc)
+		case *ast.GenDecl:
+			docs[decl] = text(d.Doc)
+		}
+	}
+	// Keep the lines of the fields and specs, to find out what go/doc
+	// drops. go/doc also changes the names, and thus the positions.
+	fields := map[*ast.FieldList][]nodeLines{}
+	specs := map[*ast.GenDecl][]nodeLines{}
+	names := map[*ast.ValueSpec][]*ast.Ident{}
+	for _, decl := range f.Decls {
+		if d, ok := decl.(*ast.GenDecl); ok {
+			for _, s := range d.Specs {
+				specs[d] = append(specs[d], linesOf(file, s))
+				if v, ok := s.(*ast.ValueSpec); ok {
+					names[v] = append([]*ast.Ident{}, v.Names...)
+				}
+			}
+		}
+		ast.Inspect(decl, func(n ast.Node) bool {
+			var list *ast.FieldList
+			switch n := n.(type) {
+			case *ast.StructType:
+				list = n.Fields
+			case *ast.InterfaceType:
+				list = n.Methods
+			default:
+				return true
+			}
+			for _, fld := range list.List {
+				fields[list] = append(fields[list], linesOf(file, fld))
+			}
+			return true
+		})
+	}
+	pkg, err := doc.NewFromFiles(fset, []*ast.File{f}, f.Name.Name)
+	if err != nil {
+		return "", errors.New("Cannot read the documentation of " + filename + "\n" + err.Error())
+	}
+	exported := map[ast.Decl]bool{}
+	addValues := func(values []*doc.Value) {
+		for _, v := range values {
+			exported[v.Decl] = true
+		}
+	}
+	addFuncs := func(funcs []*doc.Func) {
+		for _, fn := range funcs {
+			exported[fn.Decl] = true
+		}
+	}
+	addValues(pkg.Consts)
+	addValues(pkg.Vars)
+	addFuncs(pkg.Funcs)
+	for _, t := range pkg.Types {
+		exported[t.Decl] = true
+		addValues(t.Consts)
+		addValues(t.Vars)
+		addFuncs(t.Funcs)
+		addFuncs(t.Methods)
+	}
+
+	// Remove the lines of the fields and specs that go/doc dropped from the
+	// exported declarations, and start over.
+	drop := map[int]bool{}
+	for list, all := range fields {
+		var kept []ast.Node
+		for _, fld := range list.List {
+			kept = append(kept, fld)
+		}
+		dropLines(drop, all, kept)
+	}
+	for d, all := range specs {
+		if !exported[d] {
+			continue
+		}
+		var kept []ast.Node
+		for _, s := range d.Specs {
+			// A const spec like `a = 2` in a group of exported constants
+			// remains without a name.
+			if v, ok := s.(*ast.ValueSpec); ok && len(v.Names) == 0 {
+				continue
+			}
+			kept = append(kept, s)
+		}
+		dropLines(drop, all, kept)
+	}
+	// Names that go/doc takes out of a spec like `var V, w = f()` become
+	// `_`, so that the names still match the values.
+	for v, all := range names {
+		if len(v.Names) == 0 || len(v.Names) == len(all) || len(v.Values) == 0 {
+			continue
+		}
+		v.Names = nil
+		for _, name := range all {
+			if !name.IsExported() {
+				name = &ast.Ident{NamePos: name.NamePos, Name: "_"}
+			}
+			v.Names = append(v.Names, name)
+		}
+	}
+	if len(drop) > 0 {
+		lines := strings.Split(src, "\n")
+		var rest []string
+		for i, line := range lines {
+			if !drop[i+1] {
+				rest = append(rest, line)
+			}
+		}
+		return rewriteExported(strings.Join(rest, "\n"), filename)
+	}
+
+	var b strings.Builder
+	for len(free) > 0 && free[0].Pos() < f.Package {
+		b.WriteString(text(free[0]) + "\n")
+		free = free[1:]
+	}
+	b.WriteString(pkgDoc + "package " + f.Name.Name + "\n")
+	for _, decl := range f.Decls {
+		for len(free) > 0 && free[0].Pos() < decl.Pos() {
+			b.WriteString("\n" + text(free[0]))
+			free = free[1:]
+		}
+		if !exported[decl] {
+			continue
+		}
+		b.WriteString("\n" + docs[decl])
+		// The comments of the specs and fields that survived the
+		// filtering stay in the code. Filtered fields leave no note
+		// behind.
+		var comments []*ast.CommentGroup
+		ast.Inspect(decl, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.StructType:
+				n.Incomplete = false
+			case *ast.InterfaceType:
+				n.Incomplete = false
+			case *ast.Field:
+				comments = appendComments(comments, n.Doc, n.Comment)
+			case *ast.ValueSpec:
+				comments = appendComments(comments, n.Doc, n.Comment)
+			case *ast.TypeSpec:
+				comments = appendComments(comments, n.Doc, n.Comment)
+			}
+			return true
+		})
+		err := exportedPrinter.Fprint(&b, fset, &printer.CommentedNode{Node: decl, Comments: comments})
+		if err != nil {
+			return "", errors.New("Cannot print the exported declarations of " + filename + "\n" + err.Error())
+		}
+		b.WriteString("\n")
+	}
+	for _, cg := range free {
+		b.WriteString("\n" + text(cg))
+	}
+	return b.String(), nil
+}
+
+// freeComments returns the comment groups of `f` that belong to no
+// declaration: neither the package comment, nor a doc comment, nor a
+// comment within or right behind a declaration.
+func freeComments(f *ast.File, file *token.File) []*ast.CommentGroup {
+	var free []*ast.CommentGroup
+	decls := f.Decls
+	for _, cg := range f.Comments {
+		for len(decls) > 0 && decls[0].End() <= cg.Pos() && file.Line(decls[0].End()) < file.Line(cg.Pos()) {
+			decls = decls[1:]
+		}
+		switch {
+		case cg == f.Doc:
+		case len(decls) > 0 && cg.End() > declStart(decls[0]):
+		default:
+			free = append(free, cg)
+		}
+	}
+	return free
+}
+
+// declStart returns the position of `decl`, or of its doc comment.
+func declStart(decl ast.Decl) token.Pos {
+	var docs *ast.CommentGroup
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		docs = d.Doc
+	case *ast.GenDecl:
+		docs = d.Doc
+	}
+	if docs != nil {
+		return docs.Pos()
+	}
+	return decl.Pos()
+}
+
+// nodeLines are the lines of a field or spec, including its comments.
+type nodeLines struct {
+	node       ast.Node
+	start, end int
+}
+
+// dropLines adds the lines of the `nodes` that are not `kept` to `drop`,
+// if they have these lines to themselves.
+func dropLines(drop map[int]bool, nodes []nodeLines, kept []ast.Node) {
+	isKept := map[ast.Node]bool{}
+	for _, n := range kept {
+		isKept[n] = true
+	}
+	for i, n := range nodes {
+		switch {
+		case isKept[n.node]:
+		case i > 0 && nodes[i-1].end >= n.start:
+		case i+1 < len(nodes) && nodes[i+1].start <= n.end:
+		default:
+			for l := n.start; l <= n.end; l++ {
+				drop[l] = true
+			}
+		}
+	}
+}
+
+// linesOf returns the lines of the field or spec `n`, including its doc
+// comment and its line comment.
+func linesOf(file *token.File, n ast.Node) nodeLines {
+	pos, last := n.Pos(), n.End()
+	var docs, comment *ast.CommentGroup
+	switch n := n.(type) {
+	case *ast.Field:
+		docs, comment = n.Doc, n.Comment
+	case *ast.ValueSpec:
+		docs, comment = n.Doc, n.Comment
+	case *ast.TypeSpec:
+		docs, comment = n.Doc, n.Comment
+	}
+	if docs != nil {
+		pos = docs.Pos()
+	}
+	if comment != nil {
+		last = comment.End()
+	}
+	return nodeLines{n, file.Line(pos), file.Line(last - 1)}
+}
+
+// exportedPrinter prints declarations like gofmt does.
+var exportedPrinter = printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: 8}
+
+// appendComments appends the comment groups `cgs` that are not nil.
+func appendComments(comments []*ast.CommentGroup, cgs ...*ast.CommentGroup) []*ast.CommentGroup {
+	for _, cg := range cgs {
+		if cg != nil {
+			comments = append(comments, cg)
+		}
+	}
+	return comments
+}
@@ -0,0 +1,56 @@
+package main
+
+import "testing"
+
+func TestRewriteExported(t *testing.T) {
+	tests := []struct {
+		name, src, want string
+	}{
+		{
+			name: "functions",
+			src:  "// Package p.\npackage p\n\nimport \"fmt\"\n\n// F prints.\nfunc F() {\n\tfmt.Println()\n}\n\nfunc g() {}\n\ntype t struct{}\n\n// M is hidden.\nfunc (t) M() {}\n",
+			want: "// Package p.\npackage p\n\n// F prints.\nfunc F()\n",
+		},
+		{
+			name: "struct fields",
+			src:  "package p\n\n// S is a struct.\ntype S struct {\n\tA int // a\n\tb int\n\t// C is c.\n\tC string\n\td, E int\n}\n",
+			want: "package p\n\n// S is a struct.\ntype S struct {\n\tA int // a\n\t// C is c.\n\tC string\n\tE int\n}\n",
+		},
+		{
+			name: "fields on several lines",
+			src:  "package p\n\ntype T struct {\n\t// hidden is hidden.\n\thidden int\n\n\tP int\n\tr,\n\ts int\n\tN int\n}\n",
+			want: "package p\n\ntype T struct {\n\tP int\n\tN int\n}\n",
+		},
+		{
+			name: "interface methods",
+			src:  "package p\n\n// I is an interface.\ntype I interface {\n\tM()\n\tm()\n}\n",
+			want: "package p\n\n// I is an interface.\ntype I interface {\n\tM()\n}\n",
+		},
+		{
+			name: "specs",
+			src:  "package p\n\nvar (\n\t// Y is y.\n\tY = 1\n\tz = 2\n)\n\nconst (\n\tA1 = iota\n\ta2\n\tA3\n)\n",
+			want: "package p\n\nvar (\n\t// Y is y.\n\tY = 1\n)\n\nconst (\n\tA1 = iota\n\tA3\n)\n",
+		},
+		{
+			name: "specs with several names",
+			src:  "package p\n\n// V is exported.\nvar V, _ = f()\n\nvar w, X = 1, 2\n\nvar q, Q = f()\n\nfunc f() (int, error) { return 0, nil }\n",
+			want: "package p\n\n// V is exported.\nvar V, _ = f()\n\nvar _, X = 1, 2\n\nvar _, Q = f()\n",
+		},
+		{
+			name: "free comments",
+			src:  "// # Intro\n//\n// Read me first.\n\n// Package p.\npackage p\n\n// ## Helpers\n\n// g is hidden.\nfunc g() {\n\t// Inside g.\n} // after g\n\n// F is exported.\nfunc F() {\n\t// Inside F.\n}\n\n// The end.\n",
+			want: "// # Intro\n//\n// Read me first.\n\n// Package p.\npackage p\n\n// ## Helpers\n\n// F is exported.\nfunc F()\n\n// The end.\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := rewriteExported(tt.src, "x.go")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+// ## Converting files from any file system
+//
+// The command line tool reads from and writes to the disk. Programs that
+// embed the conversion may have their Go files somewhere else, like in an
+// `embed.FS` or in memory, and tests should not touch the disk at all. A
+// `FileConverter` reads the Go files and their media files from an
+// `fs.FS`, and writes the documents and the copied media files to an
+// `OutputFS`:
+//
+//     src := fstest.MapFS{
+//         "doc.go":   {Data: []byte("// # Doc\n//\n// ![logo](logo.png)\npackage doc\n")},
+//         "logo.png": {Data: png},
+//     }
+//     out := MemOutput{}
+//     fc := NewFileConverter(src, out, Options{OutDir: "out"})
+//     media, err := fc.Convert("doc.go")
+//     ...
+//     err = fc.CopyMedia("doc.go", media)
+//
+// Now `out["out/doc.md"]` holds the document and `out["out/logo.png"]`
+// the image. The paths within an `fs.FS` use slashes and are relative;
+// the command line tool uses the OS file system, where any path goes.
+//
+// A few features use the OS anyway: `-date-source git` asks git, and
+// `-archive` extracts to a temporary directory.
+
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// OutputFS receives the files that a conversion writes.
+type OutputFS interface {
+	// WriteFile writes `data` to the file `name`, creating any missing
+	// directories.
+	WriteFile(name string, data []byte) error
+	// Exists returns true if the file `name` exists, for `-no-clobber`.
+	Exists(name string) bool
+}
+
+// FileConverter converts files from a file system.
+type FileConverter struct {
+	opts Options
+}
+
+// NewFileConverter returns a FileConverter that reads from `fsys` and
+// writes to `out`, with the options `opts`. The files that it converts
+// belong to one run: names from `-name-from-heading` and `-assets-dir`
+// get their numeric suffixes among them only.
+func NewFileConverter(fsys fs.FS, out OutputFS, opts Options) *FileConverter {
+	opts.fsys, opts.out = fsys, out
+	return &FileConverter{opts: opts.withRun()}
+}
+
+// Convert converts the Go file `filename` and writes the document. It
+// returns the media files that the document refers to, mapped to their
+// target paths, for `CopyMedia`.
+func (fc *FileConverter) Convert(filename string) (media map[string]string, err error) {
+	return convertFile(filename, fc.opts)
+}
+
+// CopyMedia copies the media files `media` of the Go file `filename`, as
+// returned by `Convert`, to the output.
+func (fc *FileConverter) CopyMedia(filename string, media map[string]string) error {
+	if !copiesMedia(fc.opts) {
+		return nil
+	}
+	return copyFiles(mediaDir(filename, fc.opts), media, fc.opts.copier(fsCopier{fc.opts.files(), fc.opts.output()}), fc.opts.run.summary)
+}
+
+// files returns the file system to read from: the OS file system, unless
+// the options come from a FileConverter.
+func (o Options) files() fs.FS {
+	if _, ok := o.fsys.(osFS); ok || o.fsys == nil {
+		return osFS{}
+	}
+	return slashFS{o.fsys}
+}
+
+// output returns the file system to write to.
+func (o Options) output() OutputFS {
+	if o.out == nil {
+		return osOutput{}
+	}
+	return o.out
+}
+
+// osFS is the file system of the OS. Unlike `os.DirFS`, it accepts any
+// path, including absolute ones and ones that start with `..`.
+type osFS struct{}
+
+func (osFS) Open(name string) (fs.File, error) {
+	return os.Open(name)
+}
+
+// slashFS turns the OS paths that the converter uses into the paths that
+// an `fs.FS` expects: `img\x.png` on Windows becomes `img/x.png`.
+type slashFS struct {
+	fs.FS
+}
+
+func (s slashFS) Open(name string) (fs.File, error) {
+	return s.FS.Open(path.Clean(filepath.ToSlash(name)))
+}
+
+// osOutput writes to the disk.
+type osOutput struct{}
+
+func (osOutput) WriteFile(name string, data []byte) error {
+	err := createPath(filepath.Dir(name))
+	if err != nil {
+		return err // The error message from createPath is chatty enough.
+	}
+	err = ioutil.WriteFile(name, data, 0644) // -rw-r--r--
+	if err != nil {
+		return errors.New("Cannot write file " + name + " \n" + err.Error())
+	}
+	return nil
+}
+
+func (osOutput) Exists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+// MemOutput keeps the output in memory, one entry per file. It is not
+// safe for concurrent use, so convert one file at a time.
+type MemOutput map[string][]byte
+
+func (m MemOutput) WriteFile(name string, data []byte) error {
+	m[filepath.ToSlash(name)] = append([]byte(nil), data...)
+	return nil
+}
+
+func (m MemOutput) Exists(name string) bool {
+	_, ok := m[filepath.ToSlash(name)]
+	return ok
+}
+
+// fsCopier copies from a file system to an output, including whole
+// directories like Hype's `hyperesources`. `dest` is the path of the copy.
+type fsCopier struct {
+	from fs.FS
+	to   OutputFS
+}
+
+func (c fsCopier) copy(src, dest string) error {
+	root := path.Clean(filepath.ToSlash(src))
+	return fs.WalkDir(c.from, root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(c.from, p)
+		if err != nil {
+			return err
+		}
+		// Like `cp -R`, the tree below `src` goes below `dest`.
+		return c.to.WriteFile(dest+p[len(root):], data)
+	})
+}
@@ -0,0 +1,458 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFileConverter(t *testing.T) {
+	src := fstest.MapFS{
+		"doc.go":              {Data: []byte("// # Doc\n//\n// ![logo](logo.png)\npackage doc\n")},
+		"logo.png":            {Data: []byte("png")},
+		"sub/sub.go":          {Data: []byte("// ![diagram](img/diagram.png)\npackage sub\n")},
+		"sub/img/diagram.png": {Data: []byte("diagram")},
+		"plain.go":            {Data: []byte("package plain\n")},
+	}
+	tests := []struct {
+		name     string
+		filename string
+		opts     Options
+		want     map[string]string // output file -> content
+	}{
+		{
+			name:     "document and image",
+			filename: "doc.go",
+			opts:     Options{OutDir: "out"},
+			want: map[string]string{
+				"out/doc.md":   "# Doc\n\n![logo](logo.png)\n\n```go\npackage doc\n```\n",
+				"out/logo.png": "png",
+			},
+		},
+		{
+			name:     "file in a directory",
+			filename: "sub/sub.go",
+			opts:     Options{OutDir: "out"},
+			want: map[string]string{
+				"out/sub.md":          "![diagram](img/diagram.png)\n\n```go\npackage sub\n```\n",
+				"out/img/diagram.png": "diagram",
+			},
+		},
+		{
+			name:     "preserve paths",
+			filename: "sub/sub.go",
+			opts:     Options{OutDir: "out", PreservePaths: true},
+			want: map[string]string{
+				"out/sub/sub.md":          "![diagram](img/diagram.png)\n\n```go\npackage sub\n```\n",
+				"out/sub/img/diagram.png": "diagram",
+			},
+		},
+		{
+			// The image tag stays as it is.
+			name:     "media in a subdirectory",
+			filename: "doc.go",
+			opts:     Options{OutDir: "out", SubDir: true},
+			want: map[string]string{
+				"out/doc.md":       "# Doc\n\n![logo](logo.png)\n\n```go\npackage doc\n```\n",
+				"out/doc/logo.png": "png",
+			},
+		},
+		{
+			name:     "media of a file in a directory in a subdirectory",
+			filename: "sub/sub.go",
+			opts:     Options{OutDir: "out", SubDir: true, PreservePaths: true},
+			want: map[string]string{
+				"out/sub/sub.md":              "![diagram](img/diagram.png)\n\n```go\npackage sub\n```\n",
+				"out/sub/sub/img/diagram.png": "diagram",
+			},
+		},
+		{
+			name:     "no copy",
+			filename: "doc.go",
+			opts:     Options{OutDir: "out", NoCopy: true},
+			want: map[string]string{
+				"out/doc.md": "# Doc\n\n![logo](logo.png)\n\n```go\npackage doc\n```\n",
+			},
+		},
+		{
+			name:     "no media",
+			filename: "plain.go",
+			opts:     Options{OutDir: "out"},
+			want: map[string]string{
+				"out/plain.md": "```go\npackage plain\n```\n",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := MemOutput{}
+			fc := NewFileConverter(src, out, tt.opts)
+			media, err := fc.Convert(tt.filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = fc.CopyMedia(tt.filename, media)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := map[string]string{}
+			for name, data := range out {
+				got[name] = string(data)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileConverterErrors(t *testing.T) {
+	src := fstest.MapFS{
+		"doc.go": {Data: []byte("// ![logo](missing.png)\npackage doc\n")},
+	}
+	out := MemOutput{}
+	fc := NewFileConverter(src, out, Options{OutDir: "out"})
+	if _, err := fc.Convert("nonexistent.go"); err == nil {
+		t.Error("nonexistent.go: got no error")
+	}
+	media, err := fc.Convert("doc.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A missing image gets reported, but does not stop the copying.
+	if err := fc.CopyMedia("doc.go", media); err != nil {
+		t.Errorf("missing.png: got error %v", err)
+	}
+	var names []string
+	for name := range out {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if want := []string{"out/doc.md"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got %v, want %v", names, want)
+	}
+}
+
+func TestFileConverterSameNames(t *testing.T) {
+	src := fstest.MapFS{
+		"a/foo.go": {Data: []byte("// A\npackage a\n")},
+		"b/foo.go": {Data: []byte("// B\npackage b\n")},
+	}
+	for _, tt := range []struct {
+		preserve bool
+		want     map[string]string
+	}{
+		{false, map[string]string{"out/foo.md": "B\n\n```go\npackage b\n```\n"}},
+		{true, map[string]string{
+			"out/a/foo.md": "A\n\n```go\npackage a\n```\n",
+			"out/b/foo.md": "B\n\n```go\npackage b\n```\n",
+		}},
+	} {
+		out := MemOutput{}
+		fc := NewFileConverter(src, out, Options{OutDir: "out", PreservePaths: tt.preserve})
+		for _, filename := range []string{"a/foo.go", "b/foo.go"} {
+			if _, err := fc.Convert(filename); err != nil {
+				t.Fatal(err)
+			}
+		}
+		got := map[string]string{}
+		for name, data := range out {
+			got[name] = string(data)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("preserve paths %v: got %q, want %q", tt.preserve, got, tt.want)
+		}
+	}
+}
+
+func TestFileConverterRuns(t *testing.T) {
+	src := fstest.MapFS{
+		"a/doc.go":   {Data: []byte("// # Getting Started\n//\n// ![logo](logo.png)\npackage a\n")},
+		"a/logo.png": {Data: []byte("png")},
+		"b/doc.go":   {Data: []byte("// # Getting Started\n//\n// ![logo](logo.png)\npackage b\n")},
+		"b/logo.png": {Data: []byte("png")},
+	}
+	// Each FileConverter is a run of its own, so the second one gets the
+	// same names as the first, without numeric suffixes.
+	for _, tt := range []struct {
+		filename string
+		opts     Options
+	}{
+		{"a/doc.go", Options{OutDir: "o", HeadingName: true, AssetsDir: "assets"}},
+		{"b/doc.go", Options{OutDir: "o", HeadingName: true, AssetsDir: "assets", Anchors: true}},
+	} {
+		out := MemOutput{}
+		fc := NewFileConverter(src, out, tt.opts)
+		media, err := fc.Convert(tt.filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := fc.CopyMedia(tt.filename, media); err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for name := range out {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if want := []string{"o/assets/logo.png", "o/getting-started.md"}; !reflect.DeepEqual(names, want) {
+			t.Errorf("%s: got %v, want %v", tt.filename, names, want)
+		}
+	}
+}
+
+func TestFileConverterNoClobber(t *testing.T) {
+	src := fstest.MapFS{
+		"doc.go":   {Data: []byte("// ![logo](logo.png)\npackage doc\n")},
+		"logo.png": {Data: []byte("png")},
+	}
+	const converted = "![logo](logo.png)\n\n```go\npackage doc\n```\n"
+	tests := []struct {
+		name  string
+		opts  Options
+		want  string
+		media bool
+	}{
+		{"overwrite", Options{OutDir: "out"}, converted, true},
+		{"no clobber", Options{OutDir: "out", NoClobber: true}, "edited", false},
+		{"force", Options{OutDir: "out", NoClobber: true, Force: true}, converted, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := MemOutput{"out/doc.md": []byte("edited")}
+			if !out.Exists("out/doc.md") {
+				t.Fatal("out/doc.md does not exist")
+			}
+			fc := NewFileConverter(src, out, tt.opts)
+			media, err := fc.Convert("doc.go")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := string(out["out/doc.md"]); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			// The media files of a document that is left alone are not
+			// copied either.
+			if got := len(media) > 0; got != tt.media {
+				t.Errorf("got media %v", media)
+			}
+		})
+	}
+}
+
+func TestFileConverterAssetsDir(t *testing.T) {
+	src := fstest.MapFS{
+		"doc.go":          {Data: []byte("// ![a](img/logo.png)\n// ![b](shared/logo.png \"Shared\")\n// <img src=\"img/logo.png\">\npackage doc\n")},
+		"img/logo.png":    {Data: []byte("a")},
+		"shared/logo.png": {Data: []byte("b")},
+	}
+	out := MemOutput{}
+	fc := NewFileConverter(src, out, Options{OutDir: "out", AssetsDir: "assets"})
+	media, err := fc.Convert("doc.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fc.CopyMedia("doc.go", media); err != nil {
+		t.Fatal(err)
+	}
+	// The second logo with the same name gets a suffix, and a second tag
+	// of the first logo gets the same name as the first tag.
+	want := map[string]string{
+		"out/doc.md":            "![a](assets/logo.png)\n![b](assets/logo-1.png \"Shared\")\n<img src=\"assets/logo.png\">\n\n```go\npackage doc\n```\n",
+		"out/assets/logo.png":   "a",
+		"out/assets/logo-1.png": "b",
+	}
+	got := map[string]string{}
+	for name, data := range out {
+		got[name] = string(data)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestFileConverterMissingMedia(t *testing.T) {
+	src := fstest.MapFS{
+		"doc.go":   {Data: []byte("// Text.\n//\n// ![gone](missing.png)\n// ![logo](logo.png)\npackage doc\n")},
+		"logo.png": {Data: []byte("png")},
+	}
+	const msg = "Referenced media not found: missing.png at doc.go:3"
+
+	logged := captureLog(t)
+	out := MemOutput{}
+	fc := NewFileConverter(src, out, Options{OutDir: "out"})
+	media, err := fc.Convert("doc.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"logo.png": "logo.png"}; !reflect.DeepEqual(media, want) {
+		t.Errorf("lenient: got media %v, want %v", media, want)
+	}
+	if !strings.Contains(logged.String(), "[Warning] "+msg) {
+		t.Errorf("lenient: got log %q, want %q", logged, msg)
+	}
+	if !out.Exists("out/doc.md") {
+		t.Error("lenient: out/doc.md does not exist")
+	}
+
+	out = MemOutput{}
+	fc = NewFileConverter(src, out, Options{OutDir: "out", Strict: true})
+	if _, err := fc.Convert("doc.go"); err == nil || !strings.Contains(err.Error(), msg) {
+		t.Errorf("strict: got error %v, want %q", err, msg)
+	}
+	if out.Exists("out/doc.md") {
+		t.Error("strict: out/doc.md exists")
+	}
+}
+
+func TestFileConverterHeadingName(t *testing.T) {
+	src := fstest.MapFS{
+		"main_impl_v2.go": {Data: []byte("// # Getting Started!\npackage main\n")},
+		"later.go":        {Data: []byte("// Intro.\n//\n// ## Next Steps\npackage main\n")},
+		"plain_v2.go":     {Data: []byte("// No heading.\npackage main\n")},
+		"code.go":         {Data: []byte("package main\n\n// # Config\nvar s = 1\n")},
+	}
+	tests := []struct {
+		filename, want string
+	}{
+		{"main_impl_v2.go", "out/getting-started.md"},
+		{"later.go", "out/next-steps.md"},
+		{"plain_v2.go", "out/plain_v2.md"},
+		{"code.go", "out/config.md"},
+	}
+	for _, tt := range tests {
+		out := MemOutput{}
+		fc := NewFileConverter(src, out, Options{OutDir: "out", HeadingName: true})
+		if _, err := fc.Convert(tt.filename); err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for name := range out {
+			names = append(names, name)
+		}
+		if want := []string{tt.want}; !reflect.DeepEqual(names, want) {
+			t.Errorf("%s: got %v, want %v", tt.filename, names, want)
+		}
+	}
+}
+
+func TestFileConverterSidecar(t *testing.T) {
+	src := fstest.MapFS{
+		"foo.go":                  {Data: []byte("// # Foo\npackage foo\n")},
+		"foo.go.frontmatter.yaml": {Data: []byte("title: Sidecar\nmenu: main\n")},
+		"bar.go":                  {Data: []byte("// ---\n// title: Mine\n// ---\n//\n// # Bar\npackage bar\n")},
+		"bar.go.frontmatter.toml": {Data: []byte("+++\ntitle = \"Sidecar\"\n+++\n")},
+		"baz.go":                  {Data: []byte("// # Baz\npackage baz\n")},
+	}
+	// The sidecar files win over the flags and over the front matter of
+	// the Go file.
+	opts := Options{OutDir: "out", FrontMatter: "hugo-yaml", Title: "Flag", Date: "2020-01-02"}
+	tests := []struct {
+		filename, output, want string
+	}{
+		{"foo.go", "out/foo.md", "---\ntitle: Sidecar\nmenu: main\n---\n\n# Foo\n\n```go\npackage foo\n```\n"},
+		{"bar.go", "out/bar.md", "+++\ntitle = \"Sidecar\"\n+++\n\n# Bar\n\n```go\npackage bar\n```\n"},
+		{"baz.go", "out/baz.md", "---\ntitle: \"Flag\"\ndate: \"2020-01-02\"\n---\n\n# Baz\n\n```go\npackage baz\n```\n"},
+	}
+	for _, tt := range tests {
+		out := MemOutput{}
+		fc := NewFileConverter(src, out, opts)
+		if _, err := fc.Convert(tt.filename); err != nil {
+			t.Fatal(err)
+		}
+		if got := string(out[tt.output]); got != tt.want {
+			t.Errorf("%s: got\n%q\nwant\n%q", tt.filename, got, tt.want)
+		}
+	}
+}
+
+func TestFileConverterPostProcess(t *testing.T) {
+	src := fstest.MapFS{
+		"doc.go": {Data: []byte("// # Doc\n//\n// Some text.\n//\n// ## More\npackage doc\n")},
+	}
+	upper := func(md string) (string, error) {
+		lines := strings.Split(md, "\n")
+		for i, line := range lines {
+			if strings.HasPrefix(line, "#") {
+				lines[i] = strings.ToUpper(line)
+			}
+		}
+		return strings.Join(lines, "\n"), nil
+	}
+	out := MemOutput{}
+	fc := NewFileConverter(src, out, Options{OutDir: "out", PostProcess: upper})
+	if _, err := fc.Convert("doc.go"); err != nil {
+		t.Fatal(err)
+	}
+	want := "# DOC\n\nSome text.\n\n## MORE\n\n```go\npackage doc\n```\n"
+	if got := string(out["out/doc.md"]); got != want {
+		t.Errorf("got\n%q\nwant\n%q", got, want)
+	}
+
+	// A failing hook stops the conversion, and nothing gets written.
+	fail := func(string) (string, error) { return "", errors.New("no shortcodes") }
+	out = MemOutput{}
+	fc = NewFileConverter(src, out, Options{OutDir: "out", PostProcess: fail})
+	if _, err := fc.Convert("doc.go"); err == nil || !strings.Contains(err.Error(), "no shortcodes") {
+		t.Errorf("got error %v, want one about the hook", err)
+	}
+	if len(out) != 0 {
+		t.Errorf("got output %v, want none", out)
+	}
+}
+
+func TestFileConverterSelfOverwrite(t *testing.T) {
+	src := fstest.MapFS{
+		"foo.go":     {Data: []byte("// # Foo\npackage foo\n")},
+		"sub/bar.go": {Data: []byte("// # Bar\npackage bar\n")},
+	}
+	out := MemOutput{}
+	fc := NewFileConverter(src, out, Options{OutDir: ".", OutputExt: ".go"})
+	_, err := fc.Convert("foo.go")
+	if err == nil || !strings.Contains(err.Error(), "Refusing to overwrite the input file foo.go") {
+		t.Errorf("foo.go: got error %v, want a refusal", err)
+	}
+	if _, ok := out["foo.go"]; ok {
+		t.Error("foo.go: got overwritten")
+	}
+	// Another file's output may still go next to the input.
+	if _, err := fc.Convert("sub/bar.go"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := out["bar.go"]; !ok {
+		t.Error("bar.go: got no output")
+	}
+}
+
+func TestFileConverterBaseURL(t *testing.T) {
+	src := fstest.MapFS{
+		"doc.go":       {Data: []byte("// ![logo](img/logo.png)\n// <img src=\"img/x.png\">\n// ![remote](https://example.com/a.png)\npackage doc\n")},
+		"img/logo.png": {Data: []byte("png")},
+		"img/x.png":    {Data: []byte("x")},
+	}
+	out := MemOutput{}
+	fc := NewFileConverter(src, out, Options{OutDir: "out", BaseURL: "/docs/assets/"})
+	media, err := fc.Convert("doc.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fc.CopyMedia("doc.go", media); err != nil {
+		t.Fatal(err)
+	}
+	want := "![logo](/docs/assets/img/logo.png)\n<img src=\"/docs/assets/img/x.png\">\n![remote](https://example.com/a.png)\n\n```go\npackage doc\n```\n"
+	if got := string(out["out/doc.md"]); got != want {
+		t.Errorf("got\n%q\nwant\n%q", got, want)
+	}
+	// The copies go where they would go without the base URL.
+	var names []string
+	for name := range out {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if want := []string{"out/doc.md", "out/img/logo.png", "out/img/x.png"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got %v, want %v", names, want)
+	}
+}
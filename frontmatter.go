@@ -0,0 +1,372 @@
+// ## Front matter
+//
+// Static site generators like Hugo read the page metadata from a front
+// matter block at the top of the Markdown file. A Go file can contain its
+// own front matter in the first comment (as this file does), but
+// `gotomarkdown` can also generate one with `-frontmatter-format`, in the
+// flavor that the site generator expects:
+//
+// * `hugo-toml` (or `toml`) generates `title = "..."` and `date = "..."`
+//   between `+++` lines.
+// * `hugo-yaml` (or `yaml`) generates `title: "..."` and `date: "..."`
+//   between `---` lines.
+// * `jekyll` generates `title: "..."` and a `date: ...` timestamp, as
+//   Jekyll writes it, between `---` lines.
+// * `none` (the default) generates nothing.
+//
+// The title is the one given by `-title`. Without `-title`, it is the text
+// of the first heading, or else the base name of the Go file. The date is
+// the one given by `-date`, or else it depends on `-date-source`:
+//
+// * `mtime` (the default) takes the modification time of the Go file.
+// * `git` takes the date of the last commit of the Go file, or else its
+//   modification time.
+// * `now` takes today's date.
+//
+// If there is no such file (as with standard input), the date is today.
+// With `-title` or `-date`, the value replaces the one in existing front
+// matter, too.
+// With `-source-field`, the front matter records the path of the Go file.
+// With `-auto-weight`, it records the position of the file among the files
+// of the run as `weight`, starting at 1, so that the menus of Hugo list
+// the documents in the order of the command line. With `-r`, the files of
+// a directory come in lexical order. A weight in the front matter of the
+// Go file stays.
+//
+// Instead of front matter, the first comment of the Go file may start
+// with `Key: value` lines, like
+//
+//     // Title: Converting files
+//     // Tags: go, markdown
+//     //
+//     // This package...
+//
+// With `-front-matter-from-doc`, these lines become fields of the front
+// matter, with the key in lower case, and they do not appear in the
+// document. Only the lines at the very top of the comment count; the first
+// line that is not a `Key: value` line ends them. The values of `tags`,
+// `categories`, and `keywords` are lists, separated by commas. A title
+// from the comment takes the place of the first heading, and with
+// `-frontmatter-format none`, the front matter is YAML.
+//
+// Front matter that is too complex for flags can go into a sidecar file
+// next to the Go file: `foo.go.frontmatter.yaml` or
+// `foo.go.frontmatter.toml`. If there is one, its content becomes the
+// front matter of `foo.md`, as it is. It replaces any front matter from
+// the Go file, and the flags above do not apply.
+
+package main
+
+import (
+	"io/fs"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// frontMatterFormat describes the front matter that a site generator
+// expects.
+type frontMatterFormat struct {
+	fence      string // the line above and below the front matter
+	sep        string // the separator between key and value
+	dateLayout string // the layout of the date, for time.Format
+	quoteDate  bool   // true if the date is a quoted string
+}
+
+// frontMatterFormats are the formats for `-frontmatter-format`.
+var frontMatterFormats = map[string]frontMatterFormat{
+	"hugo-toml": {"+++", " = ", "2006-01-02", true},
+	"hugo-yaml": {"---", ": ", "2006-01-02", true},
+	"jekyll":    {"---", ": ", "2006-01-02 15:04:05 -0700", false},
+	"toml":      {"+++", " = ", "2006-01-02", true},
+	"yaml":      {"---", ": ", "2006-01-02", true},
+}
+
+// findFrontMatter returns the line indexes of the opening and closing
+// fence of the front matter at the top of `lines`, or -1, -1 if there is
+// none. Blank lines before the front matter are allowed.
+func findFrontMatter(lines []string) (start, end int) {
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if line != "+++" && line != "---" {
+			return -1, -1
+		}
+		for j := i + 1; j < len(lines); j++ {
+			if strings.TrimSpace(lines[j]) == line {
+				return i, j
+			}
+		}
+		return -1, -1
+	}
+	return -1, -1
+}
+
+// firstHeading returns the text of the first ATX heading in `md`, outside
+// of code fences, or an empty string if there is none.
+func firstHeading(md string) string {
+	inFence := false
+	for _, line := range strings.Split(md, "\n") {
+		if fence.MatchString(line) {
+			inFence = !inFence
+			continue
+		}
+		if inFence {
+			continue
+		}
+		if loc := heading.FindStringIndex(line); loc != nil {
+			line = headingID.ReplaceAllString(line, "")
+			return strings.TrimSpace(strings.TrimRight(strings.TrimSpace(line[loc[1]:]), "#"))
+		}
+	}
+	return ""
+}
+
+// documentTitle returns the title for the document `md` converted from the
+// file `filename`: the title from `opts`, the first heading, or the base name
+// of the file, in this order.
+func documentTitle(md, filename string, opts Options) string {
+	if opts.Title != "" {
+		return opts.Title
+	}
+	if h := firstHeading(md); h != "" {
+		return h
+	}
+	return base(filepath.Base(filename))
+}
+
+// documentDate returns the date for the front matter of the file
+// `filename`: the date from `opts` as it is, or else the date from
+// `fileDate`, formatted for `format`.
+func documentDate(filename string, format frontMatterFormat, opts Options) string {
+	date := opts.Date
+	if date == "" {
+		date = fileDate(opts.files(), filename, opts.DateSource).Format(format.dateLayout)
+	}
+	if format.quoteDate {
+		return strconv.Quote(date)
+	}
+	return date
+}
+
+// fileDate returns the date of the file `filename` from the source
+// `source`, falling back from `git` to `mtime` to `now`.
+func fileDate(fsys fs.FS, filename, source string) time.Time {
+	if source == "git" {
+		out, err := exec.Command("git", "log", "-1", "--format=%cs", "--", filename).Output()
+		if err == nil {
+			if t, err := time.ParseInLocation("2006-01-02", strings.TrimSpace(string(out)), time.Local); err == nil {
+				return t
+			}
+		}
+		source = "mtime"
+	}
+	if source == "mtime" || source == "" {
+		if fi, err := fs.Stat(fsys, filename); err == nil && !fi.ModTime().IsZero() {
+			return fi.ModTime()
+		}
+	}
+	return time.Now()
+}
+
+// frontMatterField is a key-value pair for the front matter. The value is
+// formatted already, including any quotes.
+type frontMatterField struct {
+	key, value string
+	replace    bool // replace an existing value for the key
+}
+
+// setField sets a field in the front matter between the fence lines at
+// `start` and `end`. sep is the key-value separator. It returns the modified
+// lines and the new index of the closing fence.
+func setField(lines []string, start, end int, f frontMatterField, sep string) ([]string, int) {
+	field := f.key + sep + f.value
+	for i := start + 1; i < end; i++ {
+		key := strings.TrimSpace(strings.SplitN(strings.SplitN(lines[i], "=", 2)[0], ":", 2)[0])
+		if key == f.key {
+			if f.replace {
+				lines[i] = field
+			}
+			return lines, end
+		}
+	}
+	lines = append(lines[:end], append([]string{field}, lines[end:]...)...)
+	return lines, end + 1
+}
+
+// addFrontMatter adds the front matter to the document `md` converted from
+// the file `filename`. If `md` starts with front matter already, the title
+// and the date are added to it if missing, or replaced if set explicitly
+// with `-title` or `-date`.
+// With `-source-field`, the front matter also records the path of the Go
+// file as `source`. Without any front matter, the path goes into an HTML
+// comment at the top instead.
+func addFrontMatter(md, filename string, opts Options) string {
+	if sidecar := readSidecar(filename, opts); sidecar != "" {
+		lines := strings.Split(md, "\n")
+		if start, end := findFrontMatter(lines); start >= 0 {
+			md = strings.Join(lines[end+1:], "\n")
+		}
+		return sidecar + "\n" + strings.TrimLeft(md, "\n")
+	}
+	format, generate := frontMatterFormats[opts.FrontMatter]
+	fence := format.fence
+	if fence == "" && len(opts.docFields) > 0 {
+		fence = "---"
+	}
+	// The fields from the comment come first, so that the title and the
+	// date below do not replace theirs, unless set explicitly.
+	fields := append([]frontMatterField{}, opts.docFields...)
+	if generate || opts.Title != "" {
+		fields = append(fields, frontMatterField{"title", strconv.Quote(documentTitle(md, filename, opts)), opts.Title != ""})
+	}
+	if generate || opts.Date != "" {
+		if !generate {
+			// Without a format to go by, the date is a string.
+			format.quoteDate = true
+		}
+		fields = append(fields, frontMatterField{"date", documentDate(filename, format, opts), opts.Date != ""})
+	}
+	if w, ok := opts.weights[filename]; ok && opts.AutoWeight {
+		fields = append(fields, frontMatterField{"weight", strconv.Itoa(w), false})
+	}
+	if opts.SourceField {
+		fields = append(fields, frontMatterField{"source", strconv.Quote(filepath.ToSlash(filename)), true})
+	}
+	lines := strings.Split(md, "\n")
+	start, end := findFrontMatter(lines)
+	if start < 0 {
+		if fence == "" {
+			if opts.SourceField {
+				return "<!-- source: " + filepath.ToSlash(filename) + " -->\n" + md
+			}
+			return md
+		}
+		lines = append([]string{fence, fence, ""}, strings.Split(strings.TrimLeft(md, "\n"), "\n")...)
+		start, end = 0, 1
+	}
+	sep := " = "
+	if strings.TrimSpace(lines[start]) == "---" {
+		sep = ": "
+	}
+	for _, f := range fields {
+		lines, end = setField(lines, start, end, f, sep)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// docField matches a `Key: value` line for `-front-matter-from-doc`.
+var docField = regexp.MustCompile(`^([A-Za-z][\w-]*):\s+(\S.*?)\s*$`)
+
+// listFields are the fields of `-front-matter-from-doc` with a list of
+// values.
+var listFields = map[string]bool{"tags": true, "categories": true, "keywords": true}
+
+// rewriteDocFields removes the `Key: value` lines at the top of the first
+// comment of `src`, and returns them as front matter fields. The lines
+// become blank, which keeps the line numbers in messages right.
+func rewriteDocFields(src string) (string, []frontMatterField) {
+	lines := strings.Split(src, "\n")
+	i := 0
+	for i < len(lines) && (strings.TrimSpace(lines[i]) == "" || isDirective(strings.TrimSpace(lines[i]))) {
+		i++
+	}
+	if i == len(lines) {
+		return src, nil
+	}
+	var fields []frontMatterField
+	block := !comment.MatchString(lines[i])
+	if block && !commentStart.MatchString(lines[i]) {
+		return src, nil // code first
+	}
+	for j := i; j < len(lines); j++ {
+		var text string
+		switch {
+		case block && j == i:
+			text = stripOnce(commentStart, lines[j])
+		case block:
+			text = lines[j]
+		case comment.MatchString(lines[j]):
+			text = stripOnce(comment, lines[j])
+		}
+		if block && commentEnd.MatchString(text) || !block && !comment.MatchString(lines[j]) {
+			break
+		}
+		text = strings.TrimSpace(text)
+		if text == "" && len(fields) == 0 {
+			continue
+		}
+		m := docField.FindStringSubmatch(text)
+		if m == nil {
+			break
+		}
+		fields = append(fields, frontMatterField{strings.ToLower(m[1]), docValue(strings.ToLower(m[1]), m[2]), false})
+		if block && j == i {
+			lines[j] = "/*"
+		} else {
+			lines[j] = ""
+		}
+	}
+	return strings.Join(lines, "\n"), fields
+}
+
+// docValue returns the value `v` of the field `key` from the comment,
+// quoted for the front matter. TOML and YAML agree on the syntax for
+// quoted strings and lists of them.
+func docValue(key, v string) string {
+	if !listFields[key] {
+		return strconv.Quote(v)
+	}
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, strconv.Quote(item))
+		}
+	}
+	return "[" + strings.Join(items, ", ") + "]"
+}
+
+// weightsOf returns the positions of `filenames`, starting at 1, for
+// `-auto-weight`.
+func weightsOf(filenames []string) map[string]int {
+	weights := map[string]int{}
+	for i, f := range filenames {
+		if _, ok := weights[f]; !ok {
+			weights[f] = i + 1
+		}
+	}
+	return weights
+}
+
+// sidecarFormats maps the extensions of sidecar files to their fences.
+var sidecarFormats = []struct{ ext, fence string }{
+	{".frontmatter.yaml", "---"},
+	{".frontmatter.toml", "+++"},
+}
+
+// readSidecar returns the front matter from the sidecar file of the Go
+// file `filename`, including the fences, or an empty string if there is
+// none. The sidecar file may contain the fences or not.
+func readSidecar(filename string, opts Options) string {
+	name := filename
+	if opts.BaseDir != "" {
+		name = filepath.Join(opts.BaseDir, filepath.Base(filename))
+	}
+	for _, f := range sidecarFormats {
+		content, err := fs.ReadFile(opts.files(), name+f.ext)
+		if err != nil {
+			continue
+		}
+		text := strings.Trim(strings.Replace(string(content), "\r", "", -1), "\n")
+		if !strings.HasPrefix(text, f.fence+"\n") {
+			text = f.fence + "\n" + text + "\n" + f.fence
+		}
+		return text + "\n"
+	}
+	return ""
+}
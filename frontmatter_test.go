@@ -0,0 +1,307 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestRewriteDocFields(t *testing.T) {
+	tests := []struct {
+		name, src, want string
+		fields          []frontMatterField
+	}{
+		{
+			name: "line comment",
+			src:  "// Title: Hello\n// Tags: a, b ,\n//\n// Text.\npackage p\n",
+			want: "\n\n//\n// Text.\npackage p\n",
+			fields: []frontMatterField{
+				{key: "title", value: `"Hello"`},
+				{key: "tags", value: `["a", "b"]`},
+			},
+		},
+		{
+			name: "block comment",
+			src:  "/* Title: Hello\nDate: 2020-01-01\n\nText.\n*/\npackage p\n",
+			want: "/*\n\n\nText.\n*/\npackage p\n",
+			fields: []frontMatterField{
+				{key: "title", value: `"Hello"`},
+				{key: "date", value: `"2020-01-01"`},
+			},
+		},
+		{
+			name:   "after a build constraint",
+			src:    "//go:build x\n\n// Title: x\npackage p\n",
+			want:   "//go:build x\n\n\npackage p\n",
+			fields: []frontMatterField{{key: "title", value: `"x"`}},
+		},
+		{
+			name: "code first",
+			src:  "package p\n// Title: x\n",
+			want: "package p\n// Title: x\n",
+		},
+		{
+			name: "text first",
+			src:  "// Text first.\n// Title: x\n",
+			want: "// Text first.\n// Title: x\n",
+		},
+		{
+			name: "one-line block comment",
+			src:  "/* Title: x */\npackage p\n",
+			want: "/* Title: x */\npackage p\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, fields := rewriteDocFields(tt.src)
+			if got != tt.want {
+				t.Errorf("got\n%q\nwant\n%q", got, tt.want)
+			}
+			if !reflect.DeepEqual(fields, tt.fields) {
+				t.Errorf("got fields %v, want %v", fields, tt.fields)
+			}
+		})
+	}
+}
+
+func TestAutoWeight(t *testing.T) {
+	dir := t.TempDir()
+	var files []string
+	for _, name := range []string{"c.go", "a.go", "b.go"} {
+		f := filepath.Join(dir, name)
+		if err := os.WriteFile(f, []byte("// # "+name+"\npackage p\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, f)
+	}
+	out := MemOutput{}
+	_, err := convertFiles(files, Options{OutDir: "out", AutoWeight: true, FrontMatter: "hugo-yaml", Date: "2020-01-02", out: out})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The weights follow the order of the files, not their names.
+	for name, weight := range map[string]string{"out/c.md": "1", "out/a.md": "2", "out/b.md": "3"} {
+		want := "---\ntitle: \"" + name[4:5] + ".go\"\ndate: \"2020-01-02\"\nweight: " + weight + "\n---\n\n# " + name[4:5] + ".go\n\n```go\npackage p\n```\n"
+		if got := string(out[name]); got != want {
+			t.Errorf("%s: got\n%q\nwant\n%q", name, got, want)
+		}
+	}
+}
+
+func TestAutoWeightWatch(t *testing.T) {
+	t.Chdir(t.TempDir())
+	files := []string{"c.go", "a.go", "b.go"}
+	for _, name := range files {
+		if err := os.WriteFile(name, []byte("// # "+name+"\npackage p\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	opts := Options{OutDir: "out", AutoWeight: true, FrontMatter: "hugo-toml", Date: "2020-01-02"}
+	media, err := convertFiles(files, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := newWatcher(files, media, opts)
+	// The files that get converted again keep their weights.
+	now := time.Now()
+	for _, name := range []string{"b.go", "a.go"} {
+		if err := os.WriteFile(name, []byte("// # "+name+"\n//\n// Changed.\npackage p\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(name, now, now.Add(time.Second)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	captureLog(t) // the "Regenerated" messages
+	w.poll(now)
+	w.poll(now.Add(settleTime))
+	for _, tt := range []struct{ name, weight, text string }{
+		{"c", "1", ""},
+		{"a", "2", "Changed.\n\n"},
+		{"b", "3", "Changed.\n\n"},
+	} {
+		got, err := os.ReadFile(filepath.Join("out", tt.name+".md"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := "+++\ntitle = \"" + tt.name + ".go\"\ndate = \"2020-01-02\"\nweight = " + tt.weight + "\n+++\n\n# " + tt.name + ".go\n\n" + tt.text + "```go\npackage p\n```\n"
+		if string(got) != want {
+			t.Errorf("%s.md: got\n%q\nwant\n%q", tt.name, got, want)
+		}
+	}
+}
+
+func TestDocumentTitle(t *testing.T) {
+	tests := []struct {
+		name, md, title, want string
+	}{
+		{"explicit", "# Heading\n", "Explicit", "Explicit"},
+		{"first heading", "Text.\n\n```\n# not a heading\n```\n\n## First {#first}\n\n# Second\n", "", "First"},
+		{"closing hashes", "# Heading ##\n", "", "Heading"},
+		{"file name", "Text.\n", "", "doc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := documentTitle(tt.md, "dir/doc.go", Options{Title: tt.title}); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			// The title goes into the generated front matter.
+			md := addFrontMatter(tt.md, "dir/doc.go", Options{Title: tt.title, FrontMatter: "hugo-yaml", Date: "2020-01-02"})
+			if want := "---\ntitle: " + strconv.Quote(tt.want) + "\n"; !strings.HasPrefix(md, want) {
+				t.Errorf("got\n%q\nwant prefix\n%q", md, want)
+			}
+		})
+	}
+}
+
+func TestSourceField(t *testing.T) {
+	const md = "# Doc\n\nText.\n"
+	tests := []struct {
+		name, md string
+		opts     Options
+		want     string
+	}{
+		{
+			name: "generated front matter",
+			md:   md,
+			opts: Options{FrontMatter: "hugo-toml", Date: "2020-01-02", SourceField: true},
+			want: "+++\ntitle = \"Doc\"\ndate = \"2020-01-02\"\nsource = \"dir/doc.go\"\n+++\n\n# Doc\n\nText.\n",
+		},
+		{
+			name: "front matter from the Go file",
+			md:   "---\ntitle: Mine\n---\n\n" + md,
+			opts: Options{SourceField: true},
+			want: "---\ntitle: Mine\nsource: \"dir/doc.go\"\n---\n\n# Doc\n\nText.\n",
+		},
+		{
+			name: "no front matter",
+			md:   md,
+			opts: Options{SourceField: true},
+			want: "<!-- source: dir/doc.go -->\n# Doc\n\nText.\n",
+		},
+		{
+			name: "no source field",
+			md:   md,
+			want: md,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := addFrontMatter(tt.md, filepath.Join("dir", "doc.go"), tt.opts); got != tt.want {
+				t.Errorf("got\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFrontMatterFormats(t *testing.T) {
+	const md = "# Doc\n"
+	tests := []struct {
+		format, want string
+	}{
+		{"hugo-toml", "+++\ntitle = \"Doc\"\ndate = \"2020-01-02\"\n+++\n\n# Doc\n"},
+		{"toml", "+++\ntitle = \"Doc\"\ndate = \"2020-01-02\"\n+++\n\n# Doc\n"},
+		{"hugo-yaml", "---\ntitle: \"Doc\"\ndate: \"2020-01-02\"\n---\n\n# Doc\n"},
+		{"yaml", "---\ntitle: \"Doc\"\ndate: \"2020-01-02\"\n---\n\n# Doc\n"},
+		{"jekyll", "---\ntitle: \"Doc\"\ndate: 2020-01-02\n---\n\n# Doc\n"},
+		{"none", md},
+		{"", md},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			if got := addFrontMatter(md, "doc.go", Options{FrontMatter: tt.format, Date: "2020-01-02"}); got != tt.want {
+				t.Errorf("got\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+	// Existing front matter keeps its delimiters and its title, whatever
+	// the format.
+	for _, fm := range []struct{ md, want string }{
+		{"+++\ntitle = \"Mine\"\n+++\n\n" + md, "+++\ntitle = \"Mine\"\ndate = 2020-01-02\n+++\n\n# Doc\n"},
+		{"---\ntitle: Mine\n---\n\n" + md, "---\ntitle: Mine\ndate: 2020-01-02\n---\n\n# Doc\n"},
+	} {
+		if got := addFrontMatter(fm.md, "doc.go", Options{FrontMatter: "jekyll", Date: "2020-01-02"}); got != fm.want {
+			t.Errorf("got\n%q\nwant\n%q", got, fm.want)
+		}
+	}
+}
+
+func TestFileDate(t *testing.T) {
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	fsys := fstest.MapFS{"doc.go": {Data: []byte("package p\n"), ModTime: mtime}}
+	tests := []struct {
+		name, filename, source string
+		want                   time.Time // the zero time for now
+	}{
+		{"mtime", "doc.go", "mtime", mtime},
+		{"default", "doc.go", "", mtime},
+		{"git, without a commit", "doc.go", "git", mtime},
+		{"no file", "missing.go", "mtime", time.Time{}},
+		{"now", "doc.go", "now", time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := time.Now()
+			got := fileDate(fsys, tt.filename, tt.source)
+			if !tt.want.IsZero() {
+				if !got.Equal(tt.want) {
+					t.Errorf("got %v, want %v", got, tt.want)
+				}
+				return
+			}
+			if got.Before(before) || got.After(time.Now()) {
+				t.Errorf("got %v, want now", got)
+			}
+		})
+	}
+	// The date goes into the front matter in the layout of the format.
+	opts := Options{FrontMatter: "jekyll", DateSource: "mtime", fsys: fsys}
+	want := "---\ntitle: \"doc\"\ndate: " + mtime.Local().Format("2006-01-02 15:04:05 -0700") + "\n---\n\nText.\n"
+	if got := addFrontMatter("Text.\n", "doc.go", opts); got != want {
+		t.Errorf("got\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestFrontMatterPassthrough(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{
+			name: "YAML in a block comment",
+			in:   "/*\n---\ntitle: \"Doc\"\ntags: [a, b]\n---\n\n# Doc\n*/\npackage p\n",
+			want: "---\ntitle: \"Doc\"\ntags: [a, b]\n---\n\n# Doc\n\n\n```go\npackage p\n```\n",
+		},
+		{
+			name: "TOML in line comments",
+			in:   "// +++\n// title = \"Doc\"\n// +++\n//\n// # Doc\npackage p\n",
+			want: "+++\ntitle = \"Doc\"\n+++\n\n# Doc\n\n```go\npackage p\n```\n",
+		},
+		{
+			// Comment delimiters within the front matter stay.
+			name: "TOML in a block comment",
+			in:   "/*\n+++\ntitle = \"Doc\"\n// not a comment\n+++\n*/\npackage p\n",
+			want: "+++\ntitle = \"Doc\"\n// not a comment\n+++\n\n\n```go\npackage p\n```\n",
+		},
+		{
+			name: "not at the start",
+			in:   "// # Doc\n//\n// ---\n// title: Doc\n// ---\npackage p\n",
+			want: "# Doc\n\n---\ntitle: Doc\n---\n\n```go\npackage p\n```\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := convert(tt.in, Options{RawFrontMatter: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+// ## Links between documents
+//
+// Literate Go files that belong together refer to each other, like
+// `see [the parser](parser.go)`. On GitHub, such a link opens the source
+// file, but in the converted documents, the reader wants the converted
+// document instead. With `-rewrite-go-links`, links to a `.go` file that
+// gets converted in the same run point to its output file, relative to the
+// output file of the document that contains the link:
+//
+//     [the parser](parser.md)
+//
+// The link target is relative to the directory of the Go file, as on
+// GitHub. A fragment like `#parsing` stays with the link. Links to Go
+// files that are not part of the run, and links within code spans, stay
+// as they are.
+//
+// The output names of `-name-from-heading` are known only after the
+// conversion, so the two options do not go together.
+
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// goLink matches the target of an inline link to a `.go` file; submatch 1
+// is the path, submatch 2 an optional fragment.
+var goLink = regexp.MustCompile(`\]\( *([^\s()"#]+\.go)(#[^\s()"]*)? *(?:"[^"]*" *)?\)`)
+
+// rewriteGoLinks makes the links in the prose line `text` that point to Go
+// files of this run point to their output files.
+func (c *converter) rewriteGoLinks(text string) string {
+	// As with doc links, code spans are left alone.
+	parts := strings.Split(text, "`")
+	for i := 0; i < len(parts); i += 2 {
+		parts[i] = goLink.ReplaceAllStringFunc(parts[i], func(link string) string {
+			m := goLink.FindStringSubmatch(link)
+			if strings.Contains(m[1], ":") { // a URL, like https://...
+				return link
+			}
+			target, ok := c.runFile(m[1])
+			if !ok {
+				return link
+			}
+			rel, err := filepath.Rel(outDir(c.opts.Name, c.opts), outputName(target, c.opts))
+			if err != nil {
+				return link
+			}
+			return strings.Replace(link, m[1], filepath.ToSlash(rel), 1)
+		})
+	}
+	return strings.Join(parts, "`")
+}
+
+// runFile returns the path of the Go file that the link target `p` refers
+// to, and true if that file is part of this run.
+func (c *converter) runFile(p string) (string, bool) {
+	target := filepath.Join(filepath.Dir(c.opts.Name), filepath.FromSlash(p))
+	abs, err := filepath.Abs(target)
+	return target, err == nil && c.opts.runFiles[abs]
+}
+
+// runFileSet returns the absolute paths of `filenames`, the files of a run.
+func runFileSet(filenames []string) map[string]bool {
+	set := map[string]bool{}
+	for _, f := range filenames {
+		if abs, err := filepath.Abs(f); err == nil {
+			set[abs] = true
+		}
+	}
+	return set
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeLinkedFiles writes two Go files in the current directory that link
+// to each other.
+func writeLinkedFiles(t *testing.T) []string {
+	t.Helper()
+	if err := os.Mkdir("pkg", 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"intro.go":      "// # Intro\n//\n// See [the parser](pkg/parser.go#parsing \"Parser\"), [missing](other.go), `[code](pkg/parser.go)`, and [web](https://example.com/x.go).\npackage p\n",
+		"pkg/parser.go": "// # Parser\n//\n// Back to [the intro](../intro.go).\npackage pkg\n",
+	}
+	for name, src := range files {
+		if err := os.WriteFile(name, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return []string{"intro.go", filepath.Join("pkg", "parser.go")}
+}
+
+func TestRewriteGoLinks(t *testing.T) {
+	t.Chdir(t.TempDir())
+	files := writeLinkedFiles(t)
+	// Only the links to the files of the run change, and code spans and
+	// URLs stay as they are.
+	tests := []struct {
+		preserve bool
+		want     map[string]string
+	}{
+		{false, map[string]string{
+			"out/intro.md":  "# Intro\n\nSee [the parser](parser.md#parsing \"Parser\"), [missing](other.go), `[code](pkg/parser.go)`, and [web](https://example.com/x.go).\n\n```go\npackage p\n```\n",
+			"out/parser.md": "# Parser\n\nBack to [the intro](intro.md).\n\n```go\npackage pkg\n```\n",
+		}},
+		{true, map[string]string{
+			"out/intro.md":      "# Intro\n\nSee [the parser](pkg/parser.md#parsing \"Parser\"), [missing](other.go), `[code](pkg/parser.go)`, and [web](https://example.com/x.go).\n\n```go\npackage p\n```\n",
+			"out/pkg/parser.md": "# Parser\n\nBack to [the intro](../intro.md).\n\n```go\npackage pkg\n```\n",
+		}},
+	}
+	for _, tt := range tests {
+		if _, err := convertFiles(files, Options{OutDir: "out", GoLinks: true, PreservePaths: tt.preserve}); err != nil {
+			t.Fatal(err)
+		}
+		for name, want := range tt.want {
+			got, err := os.ReadFile(filepath.FromSlash(name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != want {
+				t.Errorf("preserve paths %v, %s: got\n%q\nwant\n%q", tt.preserve, name, got, want)
+			}
+		}
+		if err := os.RemoveAll("out"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRewriteGoLinksWatch(t *testing.T) {
+	t.Chdir(t.TempDir())
+	files := writeLinkedFiles(t)
+	opts := Options{OutDir: "out", GoLinks: true}
+	media, err := convertFiles(files, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := newWatcher(files, media, opts)
+	// A regenerated file still links to the other files of the run.
+	parser := filepath.Join("pkg", "parser.go")
+	if err := os.WriteFile(parser, []byte("// # Parser\n//\n// Back to [the intro](../intro.go), again.\npackage pkg\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if err := os.Chtimes(parser, now, now.Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	captureLog(t) // the "Regenerated" message
+	w.poll(now)
+	w.poll(now.Add(settleTime))
+	got, err := os.ReadFile(filepath.Join("out", "parser.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "# Parser\n\nBack to [the intro](intro.md), again.\n\n```go\npackage pkg\n```\n"; string(got) != want {
+		t.Errorf("got\n%q\nwant\n%q", got, want)
+	}
+}
@@ -8,7 +8,7 @@ tags = ["Go", "Hugo", "Markdown", "Hype"]
 +++
 
 
-`gotomarkdown` converts a .go file into a Markdown file. Comments can (and should) contain [Markdown](https://daringfireball.net/projects/markdown) text. Comment delimiters are stripped, and Go code is put into code fences.
+`gotomarkdown` converts a .go file into a Markdown file. Comments can (and should) contain [Markdown](https://daringfireball.net/projects/markdown) text. Comment delimiters are stripped, and Go code is put into code fences. Images that the comments refer to, with Markdown image tags or with HTML `<img src="...">` tags, get copied to the output directory. Their paths are relative to the Go file. Images outside of the Go file's directory, like `../logo.png`, would end up outside of the output directory and are refused; `-assets-dir` copies them into the output directory instead.
 
 Extra: A non-standard "HYPE" tag can be used for inserting Tumult Hype HTML animations. This tag resembles an image tag but with the "!" replaced by "HYPE", like: `HYPE[Description](path/to/exported_hype.html)`. It is replaced by the corresponding HTML snippet that loads the animation. To create the anmiation files, export your Tumult Hype animation to HTML5 and ensure the "Also save HTML file" checkbox is checked. `gotomarkdown` then extracts the required HTML snippet from the file and copies the `hyperesources` directory to the output folder.
 
@@ -16,13 +16,83 @@ Extra: A non-standard "HYPE" tag can be used for inserting Tumult Hype HTML anim
 
 ## Usage
 
-	gotomarkdown [-outdir "path/to/outputDir"] [-nocopy] <gofile.go>
+	gotomarkdown [-outdir "path/to/outputDir"] [-nocopy] <gofile.go>...
+	gotomarkdown [-stdin-name <gofile.go>] - < gofile.go
 
 ### Flags
 
-*`-outdir`: Specifies the output directory. Defaults to "out".
+*`-outdir`: Specifies the output directory. Defaults to the value of the environment variable `GOTOMARKDOWN_OUTDIR`, if set, or else to "out". A config file overrides the environment variable.
+*`-preserve-paths`: If set, the output mirrors the directory structure of the input files below the output directory: `pkg/sub/foo.go` becomes &lt;outdir>/pkg/sub/foo.md, and its media files go there, too. This way, `a/foo.go` and `b/foo.go` do not overwrite each other's output. Absolute paths and paths that start with `..` are not mirrored.
 *`-nocopy`: If set, the image and animation files do not get copied to the output directory.
 *`-subdir`: If set, the image and animation files are copied into &lt;outdir>/&lt;subdir>, rather than into &lt;outdir>.
+*`-html`: If set, the Markdown is rendered to HTML and written to &lt;outdir>/&lt;basename>.html instead. Front matter stays at the top as it is.
+*`-output-ext`: The extension of the output files, like `.markdown` or `.mdx`. Defaults to `.md`. With `-html`, the extension is always `.html`.
+*`-name-from-heading`: If set, the output file is named after the first heading of the document, like `getting-started.md` for `# Getting Started`, rather than after the Go file. Documents without a heading keep the name of the Go file.
+*`-format`: The output format, `markdown` (the default) or `asciidoc`. In AsciiDoc, headings become `==` titles, code goes into `[source,go]` listing blocks, and images and links become `image::` and `link:` macros. The output files get the extension `.adoc`.
+*`-j`: The number of files to convert in parallel. Defaults to 1.
+*`-copy-links`: If set, local files that are targets of Markdown links, like `[spec](spec.pdf)`, are copied along with the image and animation files.
+*`-assets-dir`: If set, images are copied into &lt;outdir>/&lt;assets-dir>, and the image tags are rewritten to point there. Images with the same name get a numeric suffix.
+*`-base-url`: A prefix for the paths of local images in the image tags, like `/docs/assets/`, for documents that are served from a subpath. `![logo](img/logo.png)` becomes `![logo](/docs/assets/img/logo.png)`. The images are copied to the same places as without the prefix. Remote images are left alone.
+*`-embed-images`: If set, local images are embedded into the image tags as base64 `data:` URIs instead of being copied, for self-contained documents. Remote images stay as they are.
+*`-keep-directives`: If set, Go directives like `//go:generate` and build constraints are emitted as code instead of being dropped.
+*`-keep-go-comments`: If set, only the comments before the package clause, like the top-of-file introduction, become prose. All the comments after it stay in the code blocks as written, so the code keeps its comments and reads as one piece. For other languages with `-r`, the comments before the first line of code become prose.
+*`-skip-package`: If set, the package clause and the import declarations are omitted from the output.
+*`-trim-imports`: If set, an import group like `import (` ... `)` becomes the single line `import ( ... )` in the code, as the list of packages is rarely of interest in a tutorial. Single imports like `import "fmt"` stay as they are.
+*`-no-hype`: If set, Hype tags are left in the text as they are, rather than replaced with the HTML snippet of the animation.
+*`-snippet-start`, `-snippet-end`: The marker lines between which the HTML snippet is found in a Hype file. Default to the markers that Tumult Hype generates.
+*`-r`: If set, directories on the command line get searched for source files, including subdirectories. Besides Go files, these can be Python (`.py`), shell (`.sh`, `.bash`), or Ruby (`.rb`) files, whose `#` comments become prose and whose code blocks get the matching fence language. A shebang line like `#!/usr/bin/env bash` stays code, unless `-keep-shebang=false` drops it. Files with other extensions are skipped with a warning.
+*`-watch`: If set, `gotomarkdown` keeps running and converts the files again whenever they or their media files change. Press Ctrl-C to stop. The modification times are polled every 250 ms, which needs no dependencies outside of the standard library.
+*`-config`: A config file with default settings, one `flag = value` per line. Defaults to `.gotomarkdown.toml` or `.gotomarkdown.yaml` in the current directory, if present. Flags on the command line take precedence.
+*`-no-clobber`: If set, existing output files are not overwritten. A warning is logged instead.
+*`-check`: Compare the documents with the output files that exist already, instead of writing them. Lists the documents that differ or are missing, and fails if there are any. Writes nothing and copies no media files. For CI.
+*`-force`: If set, existing output files are overwritten even if `-no-clobber` is set (for example, in the config file).
+*`-code-style`: Either `fenced` (the default) to put code into code fences, or `indented` to indent code by four spaces instead, for Markdown renderers that don't know code fences.
+*`-manifest`: If set, a JSON file that lists the output file and the copied media files for each input file is written to the given path.
+*`-strict`: If set, suspicious input, like an unterminated `/*` comment or a missing image file, is an error rather than a warning.
+*`-frontmatter-format`: One of `hugo-toml` (or `toml`), `hugo-yaml` (or `yaml`), or `jekyll` to generate front matter with a title and a date at the top of the output, or `none` (the default).
+*`-title`: The title for the front matter. Defaults to the first heading, or else the file name. Also replaces the title in front matter that the Go file contains.
+*`-date`: The date for the front matter. Defaults to the date from `-date-source`. Also replaces the date in front matter that the Go file contains.
+*`-date-source`: Where the default date comes from: `mtime` (the default) for the modification time of the Go file, `git` for the date of its last commit, or `now` for today. `git` falls back to `mtime`, and `mtime` to `now`.
+*`-keep-hard-breaks`: Trailing whitespace is removed from all lines. If set, two trailing spaces in prose, which mark a hard line break in Markdown, are kept.
+*`-source-field`: If set, the path of the Go file is recorded as `source` in the front matter, or in an HTML comment at the top if there is no front matter.
+*`-auto-weight`: If set, each document gets the position of its Go file on the command line, starting at 1, as `weight` in the front matter, for the menus of Hugo. Use with `-frontmatter-format`. A `weight` in the front matter of the Go file stays.
+*Sidecar files: If there is a file `foo.go.frontmatter.yaml` or `foo.go.frontmatter.toml` next to `foo.go`, its content becomes the front matter of `foo.md` as it is, with `---` or `+++` fences added if missing. It replaces any front matter from the Go file, and `-frontmatter-format`, `-title`, `-date`, `-source-field`, `-auto-weight`, and `-front-matter-from-doc` do not apply.
+*`-exclude`: A regular expression. Comment paragraphs whose first line matches it, like `-exclude '^TODO:'`, are left out of the output. Code is not affected.
+*`-stdin-name`: With `-` as the file name, the Go source is read from standard input, and the output goes to standard output. If set, the output is written to the output directory instead, named after the given file name, like `-stdin-name foo.go` for `foo.md`.
+*`-prefix`, `-suffix`: Files with text to insert at the top of each document (below any front matter), or to append at the bottom. The texts can contain the template variables `{{.Filename}}`, `{{.Title}}`, and `{{.Date}}`.
+*`-strip-stars`: If set, a leading `*` and the space after it are removed from each line within a block comment, for comments formatted like ` * line one`. Off by default, as such lines could be list items, too.
+*`-examples`: If set, `Example...` functions in `_test.go` files are shown as the code of their body, followed by their `// Output:` in an `output` code block.
+*`-line-numbers`: If set, the lines of each code block are numbered. Fenced code blocks get the info string `go {linenos=true}` that Hugo and other renderers understand. With `-code-style indented`, each line starts with its number.
+*`-code-caption`: If set, each code block gets a caption line before it, like `*Listing: Parsing the input*`, for readers who skim or use a screen reader. The caption comes from the nearest heading above the code block, or from a `//gtm:caption Parsing the input` line before the code. Code blocks with neither get no caption.
+*`-caption-format`: The template for the captions of `-code-caption` (see package `text/template`). `{{.Heading}}` is the heading and `{{.Number}}` the number of the code block. Defaults to `*Listing: {{.Heading}}*`.
+*`-archive`: Converts all Go files in the given `.zip`, `.tar`, `.tar.gz`, or `.tgz` archive. The output mirrors the directory structure of the archive, and media paths are relative to the directory of each Go file in the archive.
+*`-files-from`: A file with the paths of the files to convert, one per line, like a manifest for reproducible builds. Blank lines and lines starting with `#` are skipped. The files get converted after the files on the command line, in the order of the list. The paths are relative to the current directory, and may contain wildcards.
+*`-v`: If set, each file is logged as it gets converted. Either way, a summary of the run is logged at the end.
+*`-min-prose-len`: Comments within code that have fewer characters than this, like `// ok`, stay in the code block instead of becoming prose. A comment counts up to the next blank line or empty comment line. Defaults to 0, which turns all comments into prose.
+*`-heading-offset`: Increases the level of every heading by the given number, up to level 6. Useful for embedding the output in a larger page.
+*`-anchors`: If set, each heading gets an explicit id like `{#converting-a-file}`, derived from its text, for links that do not depend on the renderer. Headings with the same text get the ids `-1`, `-2`, and so on.
+*`-section-breaks`: A separator, like `---` for a horizontal rule, to insert before each top-level `#` heading but the first, to set the sections of a long file apart.
+*`-sort-sections`: If set, the sections that start at top-level `#` headings get sorted alphabetically by their titles, ignoring case, for reference documents. Each section keeps its text and code. Anything before the first heading stays at the top.
+*`-section`: A heading, like `Usage`. If set, the output contains only the section with this heading, of any level, ignoring case, down to the next heading of the same or a higher level. The section includes its subsections and code blocks. If there is no such section, the conversion fails.
+*`-doclinks`: A base URL like `https://pkg.go.dev/example.com/mypkg`. If set, doc links in comments, like `[Reader]` or `[Reader.Read]`, become links to `<base URL>#Reader`. Package-qualified names like `[io.Reader]` stay as they are.
+*`-rewrite-go-links`: Links to `.go` files that get converted in the same run, like `[the parser](parser.go)`, point to their output files, like `parser.md`. Does not work with `-name-from-heading`.
+*`-strict-markdown`: If set, characters in prose that a renderer would take as markup by accident are escaped: `<` and `>`, as in `List<T>`, and `*` or `_` that do not pair up as emphasis, as in `a_b_c`. Code spans, links, URLs, and common HTML tags are left alone.
+*`-admonitions`: If set to `blockquote` or `github`, comment paragraphs that start with `Deprecated:`, `Warning:`, `Note:`, or `TODO:` become callouts. `blockquote` makes them block quotes with an icon, like `> ⚠️ **Deprecated:** Use Bar instead.`, and `github` makes them GitHub alerts, like `> [!CAUTION]`. With `-format asciidoc`, they become AsciiDoc admonitions, like `CAUTION: Deprecated: Use Bar instead.`
+*`-only-exported`: If set, only the package comment and the exported functions, methods, types, constants, and variables are converted, together with their doc comments. Functions and methods show their signature only. Imports, unexported declarations, and all other comments are dropped. Useful for API documentation.
+*`-tabwidth`: If greater than 0, tabs in code are replaced by spaces, with tab stops this many columns apart. Comments are left as they are. Defaults to 0, which keeps the tabs.
+*`-wrap`: If greater than 0, paragraphs of prose are reflowed to lines of at most this many characters, for clean diffs. Lines break at spaces only, not within code spans or links. Code, headings, lists, and other blocks stay as they are. Defaults to 0, which keeps the lines of the comments.
+*`-license`: What to do with a license comment at the top of the file, that is, a first comment that contains "Copyright" or "License": `keep` it (the default), `strip` it, or `collapse` it into a `<details>` element.
+*`-front-matter-passthrough`: If set, front matter between `---` or `+++` lines at the start of the first comment goes to the top of the output unchanged, without the empty lines before it, so that static site generators find it.
+*`-front-matter-from-doc`: If set, `Key: value` lines at the top of the first comment, like `// Title: Converting files` or `// Tags: go, markdown`, become fields of the front matter and are removed from the document. The first line that is not a `Key: value` line ends them. The values of `tags`, `categories`, and `keywords` are lists, separated by commas. Without `-frontmatter-format`, the front matter is YAML.
+
+### Directives
+
+Comment lines starting with `//gtm:` control the conversion. They do not appear in the output.
+
+*`//gtm:fence <info>`: Sets the info string of the next code fence, like `//gtm:fence go {hl_lines=[2]}` for line highlighting. The fences after that get the default `go` again. Within code, the directive ends the code block, and the code after it gets the new fence.
+*`//gtm:include <file>`: Inserts the Go file as code. `<file>#func=Foo` inserts only the function `Foo`, and `<file>#lines=10-20` only lines 10 to 20. The path is relative to the directory of the Go file.
+*`//gtm:caption <heading>`: Sets the heading for the caption of the next code block, with `-code-caption`, instead of the nearest heading above.
+*`//gtm:hide` and `//gtm:show`: Everything between these lines is dropped, prose and code alike, like implementation details that would distract the reader. A `//gtm:hide` without a matching `//gtm:show` hides the rest of the file, with a warning, or an error with `-strict`.
 
 ## License
 
@@ -36,89 +106,175 @@ This code is governed by a BSD 3-clause license that can be found in LICENSE.txt
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"errors"
 	"flag"
+	"io"
+	"io/fs"
 	"io/ioutil"
 	"log"
 	"os"
-	"os/exec"
 	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	defaultSnippetStart = "<!-- copy these lines to your document: -->"
+	defaultSnippetEnd   = "<!-- end copy -->"
+	// defaultCaptionFormat is the template for the captions of code blocks.
+	defaultCaptionFormat = "*Listing: {{.Heading}}*"
 )
 
+// The patterns below run on every line, and lines can be very long, like
+// in generated files. Go's `regexp` package matches in time linear in the
+// length of the input and never backtracks, so no pattern can get stuck
+// on a long, malformed line like `![[[[...`. What can take quadratic time
+// is matching again and again from each position of a line; see
+// `linkFinder` in reflow.go.
 const (
-	commentPtrn      = `^\s*//\s?`
-	commentStartPtrn = `^\s*/\*\s?`
+	commentPtrn      = `^\s*// ?` // only a space goes; a tab indents a code example
+	commentStartPtrn = `^\s*/\* ?`
 	commentEndPtrn   = `\s?\*/\s*$`
 	directivePtrn    = `^//go:`
+	gtmDirectivePtrn = `^\s*//gtm:(\w+)\s*(.*?)\s*$`
+	buildPtrn        = `^//\s*\+build(\s+[\w!,.]+)+\s*$`
+	packagePtrn      = `^package\s`
+	importPtrn       = `^import(?:\s*(\()|\s)`
+	importEndPtrn    = `^\s*\)`
 	imagePtrn        = `[^\x60]!\[[^\]]+\]\( *([^"\)]+) *["\)]` // \x60 = backtick
+	badImagePtrn     = `[^\x60]!\[[^\]]+\]\( *["\)]`
+	htmlImgPtrn      = `<img\s[^>]*?\bsrc\s*=\s*(?:"([^"]*)"|'([^']*)')`
 	hypePtrn         = `[^\x60]HYPE\[[^\]]+\]\( *([^\)]+) *\)`
+	linkPtrn         = `(?:^|[^!\x60\]])\[[^\]]+\]\( *([^"\)]+) *["\)]`
+	remotePtrn       = `^([a-zA-Z][a-zA-Z0-9+.-]*:|//|#)` // URL scheme, protocol-relative URL, or in-page anchor
+	refImagePtrn     = `!\[([^\]]*)\]\[([^\]]*)\]|!\[([^\]]+)\]`
+	refDefPtrn       = `^ {0,3}\[([^\]]+)\]:\s*(<[^>]*>|\S+)`
+	fencePtrn        = "^\\s*(`{3,}|~{3,})"
+	headingPtrn      = `^( {0,3})(#{1,6})(\s|$)`
+	starPtrn         = `^\s*\*( |$)`
+	indentedPtrn     = `^( {4}|\t)`
 )
 
 var (
-	comment          = regexp.MustCompile(commentPtrn)      // pattern for single-line comments
-	commentStart     = regexp.MustCompile(commentStartPtrn) // pattern for /* comment delimiter
-	commentEnd       = regexp.MustCompile(commentEndPtrn)   // pattern for */ comment delimiter
-	directive        = regexp.MustCompile(directivePtrn)    // pattern for //go: directive, like //go:generate
-	imageTag         = regexp.MustCompile(imagePtrn)        // pattern for Markdown image tag
-	hypeTag          = regexp.MustCompile(hypePtrn)         // pattern for Hype animation tag
-	allCommentDelims = regexp.MustCompile(commentPtrn + "|" + commentStartPtrn + "|" + commentEndPtrn)
-	outDir           = flag.String("outdir", "out", "Output directory")
-	dontCopyMedia    = flag.Bool("nocopy", false, "Do not copy media files to outdir")
-	subDir           = flag.Bool("subdir", false, "Use subdirectory <outdir>/<gofilebasename>/ for media files, ex.: out/gotomarkdown/")
+	comment         = regexp.MustCompile(commentPtrn)      // pattern for single-line comments
+	commentStart    = regexp.MustCompile(commentStartPtrn) // pattern for /* comment delimiter
+	commentEnd      = regexp.MustCompile(commentEndPtrn)   // pattern for */ comment delimiter
+	directive       = regexp.MustCompile(directivePtrn)    // pattern for //go: directive, like //go:generate
+	gtmDirective    = regexp.MustCompile(gtmDirectivePtrn) // pattern for a gotomarkdown directive, like //gtm:fence go; submatches are the name and the argument
+	buildConstraint = regexp.MustCompile(buildPtrn)        // pattern for legacy // +build constraint
+	packageClause   = regexp.MustCompile(packagePtrn)      // pattern for the package clause
+	importDecl      = regexp.MustCompile(importPtrn)       // pattern for an import declaration; submatch 1 is "(" for a group
+	importEnd       = regexp.MustCompile(importEndPtrn)    // pattern for the end of an import group
+	imageTag        = regexp.MustCompile(imagePtrn)        // pattern for Markdown image tag
+	badImageTag     = regexp.MustCompile(badImagePtrn)     // pattern for a Markdown image tag without a path, like ![Alt text]()
+	htmlImgTag      = regexp.MustCompile(htmlImgPtrn)      // pattern for HTML image tag; submatch 1 or 2 is the src, in double or single quotes
+	hypeTag         = regexp.MustCompile(hypePtrn)         // pattern for Hype animation tag
+	linkTag         = regexp.MustCompile(linkPtrn)         // pattern for Markdown inline link
+	remote          = regexp.MustCompile(remotePtrn)       // pattern for link targets that are not local files
+	refImage        = regexp.MustCompile(refImagePtrn)     // pattern for a reference-style image, like ![alt][id] or ![id]
+	refDef          = regexp.MustCompile(refDefPtrn)       // pattern for a link reference definition, like [id]: path.png
+	fence           = regexp.MustCompile(fencePtrn)        // pattern for a code fence within comment text
+	heading         = regexp.MustCompile(headingPtrn)      // pattern for a Markdown ATX heading
+	indentedCode    = regexp.MustCompile(indentedPtrn)     // pattern for a line of an indented code block
+	leadingStar     = regexp.MustCompile(starPtrn)         // pattern for a leading * in a /*...*/ section, as in " * text"
 )
 
 // ## First, some helper functions
 //
 // copyFiles copies a list of files or directories to a destination directory.
-// The destination path must exist.
+// `srcpaths` maps each source path to its target path relative to `dest`.
+// The destination path must exist. Missing subdirectories below the
+// destination path, like `assets/diagrams` for `assets/diagrams/x.png`, get
+// created by the copier.
 // The source paths must be relative. (Usually they are, as they are taken from an MD image tag)
-func copyFiles(dest string, srcpaths map[string]struct{}) (err error) {
-	var result []byte
-	for src, _ := range srcpaths {
-		result, err = exec.Command("cp", "-R", path.Clean(strings.Trim(src, " \t")), path.Clean(path.Join(dest, src))).Output() // TODO: Windows "copy"
+// Target paths that lead outside of `dest`, like `../../etc/x`, are
+// refused, as the document decides about them.
+// The actual copying is up to `cp`, usually `mediaCopier` (see copier.go).
+func copyFiles(dest string, srcpaths map[string]string, cp copier, counts *runSummary) (err error) {
+	for src, target := range srcpaths {
+		target = strings.Replace(target, "\\", "/", -1)
+		clean := path.Clean(target)
+		if clean == ".." || strings.HasPrefix(clean, "../") {
+			return errors.New("Refusing to copy " + src + " to " + target + ", which is outside of " + dest)
+		}
+		destpath := path.Clean(path.Join(dest, target))
+		err = cp.copy(path.Clean(strings.Trim(src, " \t")), destpath)
 		if err != nil {
-			return errors.New(string(result) + "\n" + err.Error())
+			return err
 		}
+		counts.add(&counts.copied)
 	}
 	return nil
 }
 
 // commentFinder returns a function that determines if the current line belongs to
-// a comment region.
-func commentFinder() func(string) bool {
+// a comment region, and a function that tells if the last line was within a
+// `/*...*/` section that has not ended yet.
+//
+// Like in Go, `/*...*/` sections do not nest. A `/*` within a section is
+// just text, and the first `*/` ends the section, so in
+// `/* see /* this */ */`, the final ` */` is code. Go would not compile
+// that code, so counting the depth would only hide the mistake.
+func commentFinder() (func(string) bool, func() bool) {
 	commentSectionInProgress := false
+	inSection := func() bool {
+		return commentSectionInProgress
+	}
 	return func(line string) bool {
+		// The current line is within a `/*...*/` section.
+		// At the end `*/` of the section, clear the flag.
+		if commentSectionInProgress {
+			if strings.Contains(line, "*/") {
+				commentSectionInProgress = false
+			}
+			return true
+		}
 		if comment.FindString(line) != "" {
 			// "//" Comment line found.
 			return true
 		}
 		// If the current line is at the start `/*` of a multi-line comment,
-		// set a flag to remember we're within a multi-line comment.
-		if commentStart.FindString(line) != "" {
-			commentSectionInProgress = true
-			return true
-		}
-		// At the end `*/` of a multi-line comment, clear the flag.
-		if commentEnd.FindString(line) != "" {
-			commentSectionInProgress = false
+		// set a flag to remember we're within a multi-line comment --
+		// unless the comment ends on the same line, as in `/* note */`.
+		if loc := commentStart.FindStringIndex(line); loc != nil {
+			commentSectionInProgress = !strings.Contains(line[loc[1]:], "*/")
 			return true
 		}
-		// The current line is within a `/*...*/` section.
-		if commentSectionInProgress {
-			return true
-		}
-		// Anything else is not a comment region.
+		// Anything else is not a comment region. This includes code with
+		// a trailing comment, like `x := 1 /* note */`.
 		return false
-	}
+	}, inSection
 }
 
-// isInComment returns true if the current line belongs to a comment region.
-// A comment region `//` is either a comment line (starting with `//`) or
-// a `/*...*/` multi-line comment.
-var isInComment func(string) bool = commentFinder()
+// splitBlockEnd splits a line after the `*/` that ends a `/*...*/` section,
+// if there is code after it, as in `/* note */ x := 1`. `inSection` tells if
+// the line starts within a `/*...*/` section. If the line does not end a
+// section, or nothing but whitespace follows the `*/`, `rest` is empty.
+func splitBlockEnd(line string, inSection bool) (commentPart, rest string) {
+	from := 0
+	if !inSection {
+		loc := commentStart.FindStringIndex(line)
+		if loc == nil {
+			return line, ""
+		}
+		from = loc[1]
+	}
+	end := strings.Index(line[from:], "*/")
+	if end < 0 {
+		return line, ""
+	}
+	end += from + len("*/")
+	return line[:end], strings.TrimSpace(line[end:])
+}
 
 // isDirective returns true if the input argument is a Go directive,
 // like `//go:generate`.
@@ -129,18 +285,148 @@ func isDirective(line string) bool {
 	return false
 }
 
+// isRemote returns true if a media or link path does not refer to a local
+// file, like `http://example.com/image.png` or `#anchor`.
+func isRemote(path string) bool {
+	return remote.MatchString(path)
+}
+
+// isBuildConstraint returns true if the input argument looks like a legacy
+// build constraint, like `// +build linux,amd64`. (The current `//go:build`
+// form is a directive already.) As in the go tool, a build constraint must be
+// followed by a blank line, possibly after more build constraints; otherwise,
+// it is prose like `// +build tags are legacy`. Build constraints are also only
+// valid before the package clause. The caller must check both conditions.
+func isBuildConstraint(line string) bool {
+	return buildConstraint.MatchString(line)
+}
+
 // extractMediaPath receives a line of text and searches for an image
 // tag. If it finds one, it adds the path to the media list.
+// The path may contain spaces, and may be enclosed in angle brackets, as
+// CommonMark requires for such paths.
+// Remote images are not media files and are ignored.
+// Backslashes, as in `img\x.png` from Windows authors, become slashes.
 // NOTE: The function can only handle one image tag per line.
 func extractMediaPath(line string) (path string, err error) {
 	matches := imageTag.FindStringSubmatch(line)
 	if len(matches) == 0 {
+		if badImageTag.MatchString(line) {
+			return "", errors.New("Error: Found image tag but no valid path, in line:\n" + line)
+		}
+		return "", nil
+	}
+	path = unbracket(strings.Trim(matches[1], " \t"))
+	if isRemote(path) {
 		return "", nil
 	}
-	if len(matches) == 1 {
-		return "", errors.New("Error: Found image tag but no valid path, in line:\n" + line)
+	return strings.Replace(path, "\\", "/", -1), nil
+}
+
+// extractImageRefs returns the reference ids of all reference-style images
+// in a line: `![alt][id]`, `![id][]`, and `![id]`. Ids are case-insensitive,
+// so they are returned in lower case.
+func extractImageRefs(line string) (ids []string) {
+	for _, loc := range refImage.FindAllStringSubmatchIndex(line, -1) {
+		var id string
+		switch {
+		case loc[4] >= 0 && loc[5] > loc[4]: // ![alt][id]
+			id = line[loc[4]:loc[5]]
+		case loc[2] >= 0: // ![id][]
+			id = line[loc[2]:loc[3]]
+		default: // ![id], unless it is an inline image ![alt](path)
+			if loc[1] < len(line) && line[loc[1]] == '(' {
+				continue
+			}
+			id = line[loc[6]:loc[7]]
+		}
+		ids = append(ids, strings.ToLower(strings.TrimSpace(id)))
+	}
+	return ids
+}
+
+// extractRefDef returns the id (in lower case) and the path of a link
+// reference definition like `[id]: path.png "Title"`, or two empty
+// strings if the line is no definition.
+func extractRefDef(line string) (id, path string) {
+	m := refDef.FindStringSubmatch(line)
+	if m == nil {
+		return "", ""
+	}
+	return strings.ToLower(strings.TrimSpace(m[1])), unbracket(m[2])
+}
+
+// unbracket removes the angle brackets around a path like `<my image.jpg>`.
+func unbracket(p string) string {
+	if len(p) > 1 && strings.HasPrefix(p, "<") && strings.HasSuffix(p, ">") {
+		return p[1 : len(p)-1]
+	}
+	return p
+}
+
+// rewriteImagePath replaces the path of the image tag in `line` by `newPath`.
+// Whitespace between the path and an optional image title is preserved,
+// and so are angle brackets around the path.
+func rewriteImagePath(line, newPath string) string {
+	loc := imageTag.FindStringSubmatchIndex(line)
+	if loc == nil {
+		return line
+	}
+	old := line[loc[2]:loc[3]]
+	trimmed := strings.TrimRight(old, " \t")
+	trailing := old[len(trimmed):]
+	if unbracket(trimmed) != trimmed {
+		newPath = "<" + newPath + ">"
+	}
+	return line[:loc[2]] + newPath + trailing + line[loc[3]:]
+}
+
+// assetNames assigns each image a unique file name within an assets
+// directory. Images from different source directories may share the same
+// file name; in this case, a numeric suffix is appended, as in `image-1.png`.
+// As all conversions of a run into the same assets directory must agree on
+// the names, there is one assetNames per run (see `runState`).
+type assetNames struct {
+	sync.Mutex
+	names map[string]string // assets directory + source path -> asset file name
+	taken map[string]bool   // assets directory + asset file names in use
+}
+
+// name returns the asset file name for the source path `src` within the
+// assets directory `dir`.
+func (a *assetNames) name(dir, src string) string {
+	a.Lock()
+	defer a.Unlock()
+	if name, ok := a.names[dir+"\x00"+src]; ok {
+		return name
+	}
+	name := path.Base(src)
+	ext := path.Ext(name)
+	for i := 1; a.taken[dir+"\x00"+name]; i++ {
+		name = strings.TrimSuffix(path.Base(src), ext) + "-" + strconv.Itoa(i) + ext
+	}
+	a.names[dir+"\x00"+src] = name
+	a.taken[dir+"\x00"+name] = true
+	return name
+}
+
+// extractLinkPaths receives a line of text and returns the targets of all
+// inline links `[text](path)` that refer to existing local files.
+// Remote links, in-page anchors, and directories are ignored.
+// The paths are relative to `dir` in `fsys`.
+func extractLinkPaths(line string, fsys fs.FS, dir string) (paths []string) {
+	for _, matches := range linkTag.FindAllStringSubmatch(line, -1) {
+		p := strings.Trim(matches[1], " \t")
+		if p == "" || isRemote(p) {
+			continue
+		}
+		fi, err := fs.Stat(fsys, filepath.Join(dir, p))
+		if err != nil || fi.IsDir() {
+			continue
+		}
+		paths = append(paths, p)
 	}
-	return strings.Trim(matches[1], " \t"), nil
+	return paths
 }
 
 // imageTag should properly match the following image tags:
@@ -162,195 +448,2028 @@ func extractMediaPath(line string) (path string, err error) {
 // ![Alt text](gotomarkdown image.jpg "Title")
 
 // getHTMLSnippet opens the file determined by `path`, and scans the file for the HTML
-// snippet to insert. It returns the HTML snippet.
-func getHTMLSnippet(path string) (out string, err error) {
-	hypeHTML, err := ioutil.ReadFile(path)
+// snippet to insert, which is found between the `start` and `end` marker lines.
+// It returns the HTML snippet, or an error if the file contains no start marker.
+func getHTMLSnippet(fsys fs.FS, path, start, end string) (out string, err error) {
+	hypeHTML, err := fs.ReadFile(fsys, path)
 	if err != nil {
 		return "", errors.New("Unable to open Hype file " + path + "\n" + err.Error())
 	}
 	inSnippet := false
-	// Remove carriage returns.
-	lines := strings.Replace(string(hypeHTML), "\r", "", -1)
-	// Split at newline and process each line.
-	for _, line := range strings.Split(lines, "\n") {
-		if strings.Index(line, "<!-- copy these lines to your document: -->") >= 0 {
+	found := false
+	ended := false
+	var b strings.Builder
+	// Scan the file line by line. Hype writes the whole animation into
+	// one line sometimes, so a line may be as long as the file.
+	scanner := bufio.NewScanner(bytes.NewReader(hypeHTML))
+	scanner.Buffer(nil, len(hypeHTML)+1)
+	for scanner.Scan() {
+		// Remove carriage returns.
+		line := strings.Replace(scanner.Text(), "\r", "", -1)
+		if strings.Index(line, start) >= 0 {
 			inSnippet = true
+			found = true
 			continue
 		}
-		if strings.Index(line, "<!-- end copy -->") >= 0 {
+		if strings.Index(line, end) >= 0 {
 			if inSnippet == true {
+				ended = true
 				break
 			}
-			inSnippet = false // there can be more than one "end copy" strings in the file
+			inSnippet = false // there can be more than one end marker in the file
 		}
 		if inSnippet {
-			out += strings.Trim(line, "	\t") + "\n"
+			b.WriteString(strings.Trim(line, "\t") + "\n")
 		}
 	}
-	return out + "\n", nil
+	if err := scanner.Err(); err != nil {
+		return "", errors.New("Unable to read Hype file " + path + "\n" + err.Error())
+	}
+	if !found {
+		return "", errors.New("No HTML snippet found in Hype file " + path + "\nThe file does not contain the start marker " + start)
+	}
+	// A snippet without an end marker runs to the end of the file,
+	// including the empty line after a final line break.
+	if inSnippet && !ended && strings.HasSuffix(strings.TrimRight(string(hypeHTML), "\r"), "\n") {
+		b.WriteString("\n")
+	}
+	return b.String() + "\n", nil
+}
+
+// hypeSnippet is the HTML snippet that replaces a Hype tag, along with the
+// path to the hyperesources directory that the snippet needs.
+type hypeSnippet struct {
+	html      string
+	resources string
 }
 
-// replaceHypeTag identifies a tag like `HYPE[description](gotomarkdown_animation.html)`
-// and replaces it by the correspoding HTML snippet generated by [Tumult Hype](http://tumult.com).
+// replaceHypeTags identifies tags like `HYPE[description](gotomarkdown_animation.html)`
+// and replaces them by the correspoding HTML snippets generated by [Tumult Hype](http://tumult.com).
 //
 // HYPE[description](gotomarkdown_animation.html)
 //
-// It returns the (possibly modified) line and the path to the hyperesources directory.
-func replaceHypeTag(line string) (out string, path string, err error) {
-	matches := hypeTag.FindStringSubmatch(line)
-	if len(matches) == 0 {
-		return line, "", nil
+// The snippets are found between the `start` and `end` marker lines of the Hype files.
+// Empty markers mean the markers that Tumult Hype generates.
+// The paths in the tags are relative to `dir` in `fsys`.
+// It returns one snippet per Hype tag in the line, in order, or none if the
+// line contains no Hype tag.
+func replaceHypeTags(fsys fs.FS, line, start, end, dir string) (snippets []hypeSnippet, err error) {
+	if start == "" {
+		start = defaultSnippetStart
+	}
+	if end == "" {
+		end = defaultSnippetEnd
 	}
-	if len(matches) == 1 {
-		return "", "", errors.New("Error: Found Hype tag but no valid path, in line:\n" + line)
+	for _, matches := range hypeTag.FindAllStringSubmatch(line, -1) {
+		if len(matches) == 1 {
+			return nil, errors.New("Error: Found Hype tag but no valid path, in line:\n" + line)
+		}
+		path := matches[1]
+		out, err := getHTMLSnippet(fsys, filepath.Join(dir, path), start, end)
+		if err != nil {
+			return nil, err
+		}
+		out += "<noscript><em>Please enable JavaScript to view the animation.</em></noscript>\n"
+		snippets = append(snippets, hypeSnippet{
+			html:      out,
+			resources: strings.Replace(path, ".html", ".hyperesources", -1),
+		})
 	}
-	path = matches[1]
-	out, err = getHTMLSnippet(path)
-	out += "<noscript><em>Please enable JavaScript to view the animation.</em></noscript>\n"
-	path = strings.Replace(path, ".html", ".hyperesources", -1)
-	return out, path, err
+	return snippets, nil
 }
 
-// convert receives a string containing commented Go code and converts it
-// line by line into a Markdown document. Collect and return any media files
-// found during this process.
-func convert(in string) (out string, media map[string]struct{}, err error) {
-	const (
-		neither = iota
-		comment
-		code
-	)
-	lastLine := neither
-	media = map[string]struct{}{}
-
-	// Remove carriage returns.
-	in = strings.Replace(in, "\r", "", -1)
-	// Split at newline and process each line.
-	for _, line := range strings.Split(in, "\n") {
-		// Skip the line if it is a Go directive like //go:generate
-		if isDirective(line) {
+// trimTrailing removes trailing whitespace from a line of prose. Trailing
+// whitespace trips up linters and diffs, and stripping the comment delimiters
+// can leave some. However, two trailing spaces mark a hard line break in
+// Markdown, so with `keepHardBreaks`, these are left intact.
+func trimTrailing(line string, keepHardBreaks bool) string {
+	trimmed := strings.TrimRight(line, " \t")
+	if keepHardBreaks && trimmed != "" && strings.HasSuffix(line, "  ") {
+		return trimmed + "  "
+	}
+	return trimmed
+}
+
+// expandTabs replaces the tabs in `line` by spaces, up to the next tab
+// stop. Tab stops are `width` characters apart.
+func expandTabs(line string, width int) string {
+	if width <= 0 || !strings.Contains(line, "\t") {
+		return line
+	}
+	var b strings.Builder
+	col := 0
+	for _, r := range line {
+		if r == '\t' {
+			n := width - col%width
+			b.WriteString(strings.Repeat(" ", n))
+			col += n
 			continue
 		}
-		// Determine if the line belongs to a comment.
-		if isInComment(line) {
-			// Close the code block if a new comment begins.
-			if lastLine == code {
-				out += "```\n\n"
+		b.WriteRune(r)
+		col++
+	}
+	return b.String()
+}
+
+// offsetHeading increases the level of an ATX heading by `n`, up to the
+// maximum level of 6. Lines that are no headings are returned unchanged.
+func offsetHeading(line string, n int) string {
+	m := heading.FindStringSubmatchIndex(line)
+	if m == nil || n <= 0 {
+		return line
+	}
+	level := m[5] - m[4] + n
+	if level > 6 {
+		level = 6
+	}
+	return line[:m[4]] + strings.Repeat("#", level) + line[m[5]:]
+}
+
+// ### The conversion options
+//
+// `Options` control the conversion. The command line tool sets them from
+// the flags (see `setFlags`), and the conversion itself takes its settings
+// from Options only, so several conversions with different Options can run
+// in the same process. The conversions of one run share some state, like
+// the counters of the run summary (see `runState`); conversions with
+// different Options, or from different FileConverters, do not.
+// The zero value of Options is usable: it converts to fenced Markdown in
+// the current directory.
+type Options struct {
+	OutDir         string // output directory
+	NoCopy         bool   // do not copy media files to OutDir
+	SubDir         bool   // copy media files to OutDir/<basename>/ instead
+	HTML           bool   // render the Markdown to HTML
+	OutputExt      string // extension of the output files, like ".markdown" (default: ".md"; ".adoc" with AsciiDoc; ".html" with HTML)
+	HeadingName    bool   // name the output file after the first heading
+	Format         string // output format: "markdown"/"" or "asciidoc" (see emitter.go)
+	Jobs           int    // number of files to convert in parallel
+	NoClobber      bool   // do not overwrite existing output files
+	Force          bool   // overwrite existing output files even with NoClobber
+	KeepDirectives bool   // emit Go directives as code instead of dropping them
+	KeepComments   bool   // only the comments before the package clause are prose; all later comments stay in the code
+	KeepShebang    bool   // keep a shebang line at the top of a script as code instead of dropping it
+	SkipPackage    bool   // omit the package clause and the import declarations
+	TrimImports    bool   // fold each import group into a single "import ( ... )" line
+	OnlyExported   bool   // document the exported declarations only (see exported.go)
+	CodeStyle      string // "fenced" (the default) or "indented"
+	MinProseLen    int    // comments within code that are shorter than this stay in the code block
+	HeadingOffset  int    // increase the level of each heading by this number
+	SectionBreaks  string // separator to insert before each top-level heading but the first; empty: none
+	SortSections   bool   // sort the sections at the top-level headings by their titles (see sections.go)
+	Section        string // keep only the section with this heading, ignoring case (see sections.go); empty: all
+	Anchors        bool   // give each heading an explicit, unique id (see anchors.go)
+	StrictMarkdown bool   // escape accidental markup in prose (see escape.go)
+	Admonitions    string // style of the callouts for paragraphs like "Deprecated: ...": "blockquote" or "github"; empty: none (see admonitions.go)
+	DocLinks       string // base URL for doc links like [Name] (see doclinks.go); empty: leave them as they are
+	GoLinks        bool   // make links to the .go files of this run point to their output files (see golinks.go)
+	Wrap           int    // reflow paragraphs of prose to this many columns (see reflow.go); 0: keep the lines
+	TabWidth       int    // expand the tabs in code to tab stops this far apart; 0: keep the tabs
+	RawFrontMatter bool   // pass front matter at the start of the first comment through unchanged
+	DocFields      bool   // turn the "Key: value" lines at the top of the first comment into front matter (see frontmatter.go)
+	License        string // what to do with a license comment at the top: "keep"/"", "strip", or "collapse" (see license.go)
+	AssetsDir      string // copy images to OutDir/AssetsDir and rewrite their tags
+	BaseURL        string // prefix for the local image paths in the tags, like "/docs/assets/"; the copies stay where they are
+	CopyLinks      bool   // collect local link targets as media files
+	EmbedImages    bool   // embed local images as data: URIs instead of copying them (see embed.go)
+	NoHype         bool   // leave Hype tags as they are
+	SnippetStart   string // marker line that starts the HTML snippet in Hype files (default: Hype's marker)
+	SnippetEnd     string // marker line that ends the HTML snippet in Hype files (default: Hype's marker)
+	KeepHardBreaks bool   // keep two trailing spaces that mark a hard line break in prose
+	Strict         bool   // treat suspicious input as an error rather than a warning
+	FrontMatter    string // generate front matter: "hugo-toml", "hugo-yaml", "jekyll", or "none"/"" (see frontmatter.go)
+	Title          string // title for the front matter; default: the first heading or the file name
+	Date           string // date for the front matter; default: the date from DateSource
+	DateSource     string // where the date comes from: "git", "mtime"/"" (the modification time), or "now"
+	LineNumbers    bool   // number the lines of each code block
+	CodeCaption    bool   // put a caption line before each code block, from the nearest heading or //gtm:caption
+	CaptionFormat  string // template for the caption line (default: "*Listing: {{.Heading}}*"; see captionData)
+	Examples       bool   // show the body and the output of Example functions in _test.go files (see examples.go)
+	StripStars     bool   // strip a leading "*" from the lines of /*...*/ sections
+	SourceField    bool   // record the path of the Go file in the front matter
+	AutoWeight     bool   // record the position of the file in the run as the weight in the front matter
+	Exclude        string // drop comment paragraphs whose first line matches this regular expression
+	Prefix         string // template for text to insert at the top, below any front matter (see wrap.go)
+	Suffix         string // template for text to append at the bottom (see wrap.go)
+	BaseDir        string // directory that the media paths in the document are relative to (default: the current directory)
+	PreservePaths  bool   // write the output to OutDir/<dir>/, where <dir> is the directory of the input file
+	Verbose        bool   // log each file that gets converted, and each media directory
+	Name           string // name of the input, for messages; convertFile sets it to the file name
+	// MediaResolver, if set, provides the content of the media files
+	// instead of the file system (see resolver.go).
+	MediaResolver MediaResolver
+	// PostProcess, if set, transforms each document after the conversion
+	// and before it gets written, like for adding custom shortcodes. The
+	// command line tool does not set it.
+	PostProcess func(md string) (string, error)
+	// A FileConverter reads from `fsys` and writes to `out`; by default,
+	// both are the OS file system (see filesystem.go).
+	fsys fs.FS
+	out  OutputFS
+	// lang is the language of the input (see languages.go); render sets
+	// it from the file name.
+	lang language
+	// runFiles holds the absolute paths of the files that convertFiles
+	// converts, for GoLinks, and weights their positions, for AutoWeight.
+	runFiles map[string]bool
+	weights  map[string]int
+	// docFields are the front matter fields from the first comment, for
+	// DocFields; render sets them.
+	docFields []frontMatterField
+	// lineMap maps the lines of the source, as the rewrites in render
+	// leave it, to the lines of the file, for messages.
+	lineMap lineMap
+	// run is the state of the run that the conversion belongs to.
+	run *runState
+}
+
+// A `runState` holds what the conversions of one run share: the counters
+// of the run summary, the asset names of `-assets-dir`, and the output
+// names of `-name-from-heading`. The command line tool has one run, and
+// each FileConverter is one.
+type runState struct {
+	summary  *runSummary
+	assets   *assetNames
+	docNames *headingNames
+}
+
+func newRunState() *runState {
+	return &runState{
+		summary:  &runSummary{},
+		assets:   &assetNames{names: map[string]string{}, taken: map[string]bool{}},
+		docNames: newHeadingNames(),
+	}
+}
+
+// `withRun` returns `o` with a run of its own, unless it belongs to one
+// already, like a single conversion outside of the command line tool.
+func (o Options) withRun() Options {
+	if o.run == nil {
+		o.run = newRunState()
+	}
+	return o
+}
+
+// ### The converter
+//
+// A `converter` holds the state of a conversion while it processes the
+// input line by line, and writes the Markdown to `out` as it goes.
+type converter struct {
+	opts     Options
+	out      *bufio.Writer
+	media    map[string]string // media files, mapped to their target paths in the output directory
+	lastLine int               // the kind of the last line: lineNeither, lineComment, or lineCode
+	// isInComment returns true if the current line belongs to a comment region.
+	// A comment region `//` is either a comment line (starting with `//`) or
+	// a `/*...*/` multi-line comment. Each conversion gets its own finder, so
+	// that files can be converted concurrently.
+	isInComment func(string) bool
+	// inBlockComment returns true while a `/*...*/` section has not ended yet.
+	inBlockComment func() bool
+	lineNo         int    // number of the current line
+	blockStart     int    // line number of the `/*` of the current `/*...*/` section
+	inBlockBody    bool   // true if the current line continues a `/*...*/` section
+	inFence        bool   // true while inside a code fence within comment text
+	fenceStart     string // the delimiter of the last code fence within comment text
+	fenceLine      int    // the line of the last code fence within comment text
+	fenceHeld      string // the opening of that fence and the empty lines after it, until content follows
+	pastPackage    bool   // true once the package clause has been seen
+	inImports      bool   // true while inside an import group that gets skipped
+	skipped        bool   // true after a skipped line, until the next line that is not blank
+	blanks         int    // number of blank lines seen after a comment
+	// Reference-style images, like `![alt][id]`, get their paths from
+	// definitions like `[id]: path.png` that may come before or after them.
+	// The media files they refer to are known only at the end.
+	imageRefs map[string]bool   // ids used by reference-style images
+	refDefs   map[string]string // paths of all reference definitions, per id
+	refLines  map[string]int    // line numbers of the reference definitions, per id
+	// With `-exclude`, comment paragraphs whose first line matches `exclude`
+	// are dropped. A paragraph ends at an empty comment line or at any line
+	// that is not a comment.
+	exclude   *regexp.Regexp
+	inPara    bool // true while inside a comment paragraph
+	excluding bool // true while inside a paragraph that gets dropped
+	// mediaLines maps each media file to the line that refers to it first,
+	// for reporting media that does not exist.
+	mediaLines map[string]int
+	// ids holds the ids of the headings so far, for `-anchors`.
+	ids map[string]bool
+	// Code blocks are fenced by default. Some legacy Markdown renderers
+	// only know indented code blocks, so these are available, too.
+	// The emitter decides about the fences (see emitter.go).
+	emit                                   emitter
+	openCode, closeCode, finalCode, indent string
+	// The lines of the current code block are held back until the block
+	// ends. Only then is it clear how long the fence must be: longer than
+	// any run of backticks that starts a line within the block, like in a
+	// raw string that contains Markdown.
+	codeOpen string   // the opening of the current code block, with "```" as the fence
+	code     []string // the lines of the current code block
+	pending  []string // comment lines within code, held back for `-min-prose-len`
+	// nextFence is the info string for the next code fence, as set by a
+	// `//gtm:fence` directive.
+	nextFence string
+	// For `-code-caption`: the caption template, the last heading, the
+	// heading from `//gtm:caption`, and the number of captioned blocks.
+	caption     *template.Template
+	lastHeading string
+	nextCaption string
+	listings    int
+	// hideStart is the line of the `//gtm:hide` of the current hidden
+	// region, or 0 outside of hidden regions.
+	hideStart int
+	// frontMatter is the fence of the front matter that passes through,
+	// "---" or "+++", while inside it; sawText is true once the input had
+	// anything but empty lines, as only then front matter cannot follow.
+	frontMatter string
+	sawText     bool
+	// For `-section-breaks`: sawHeading is true after the first top-level
+	// heading, and afterText is true if the last line written was text.
+	sawHeading, afterText bool
+	// callout is the leading word of the admonition paragraph that the
+	// last line belongs to, like "Deprecated", for `-admonitions`.
+	callout string
+	// inExample is true within an indented code example in a comment.
+	inExample bool
+}
+
+// The kinds of lines, for `converter.lastLine`.
+const (
+	lineNeither = iota
+	lineComment
+	lineCode
+)
+
+// newConverter returns a converter that writes to `w`.
+func newConverter(w io.Writer, opts Options) *converter {
+	c := &converter{
+		opts:       opts,
+		out:        bufio.NewWriter(w),
+		media:      map[string]string{},
+		imageRefs:  map[string]bool{},
+		refDefs:    map[string]string{},
+		refLines:   map[string]int{},
+		mediaLines: map[string]int{},
+		ids:        map[string]bool{},
+		lastLine:   lineNeither,
+		emit:       newEmitter(opts),
+	}
+	c.isInComment, c.inBlockComment = commentFinder()
+	c.openCode = "\n" + c.emit.openCode("")
+	c.closeCode = c.emit.closeCode() + "\n"
+	c.finalCode = c.emit.closeCode()
+	// Indented code has no fences, so the converter indents each line.
+	if opts.CodeStyle == "indented" && opts.Format != "asciidoc" {
+		c.indent = "    "
+	}
+	return c
+}
+
+// convertStream reads commented Go code from `r` line by line, converts it
+// into a Markdown document, and writes the document to `w`. It checks for
+// cancellation of `ctx` between lines. Collect and return any media files
+// found during this process, mapped to their target paths in the output directory.
+func convertStream(ctx context.Context, r io.Reader, w io.Writer, opts Options) (media map[string]string, err error) {
+	c := newConverter(w, opts.withRun())
+	if opts.CodeCaption {
+		format := opts.CaptionFormat
+		if format == "" {
+			format = defaultCaptionFormat
+		}
+		c.caption, err = template.New("caption").Parse(format)
+		if err != nil {
+			return nil, errors.New("Invalid -caption-format " + format + "\n" + err.Error())
+		}
+	}
+	if opts.Exclude != "" {
+		c.exclude, err = regexp.Compile(opts.Exclude)
+		if err != nil {
+			return nil, errors.New("Invalid -exclude pattern " + opts.Exclude + "\n" + err.Error())
+		}
+	}
+	in := bufio.NewReader(r)
+	// Legacy build constraints must be followed by a blank line, possibly
+	// after more build constraints; otherwise, they are prose like
+	// `// +build tags are legacy`. (See `isBuildConstraint`.)
+	// Such lines are held back until the next line decides.
+	var held []string
+	first := true
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		raw, readErr := in.ReadString('\n')
+		if readErr != nil && readErr != io.EOF {
+			return nil, errors.New("Cannot read input\n" + readErr.Error())
+		}
+		// Remove carriage returns.
+		line := strings.Replace(strings.TrimSuffix(raw, "\n"), "\r", "", -1)
+		// Remove a byte order mark that some editors put at the start.
+		if first {
+			line = strings.TrimPrefix(line, "\uFEFF")
+			first = false
+		}
+		hold := false
+		switch {
+		case !c.pastPackage && isBuildConstraint(line):
+			held = append(held, line)
+			hold = true
+		case len(held) > 0 && isDirective(line):
+			held = append(held, line)
+			hold = true
+		case len(held) > 0:
+			// A blank line confirms the held lines as build constraints.
+			confirmed := strings.TrimSpace(line) == ""
+			for _, h := range held {
+				err = c.line(h, confirmed || isDirective(h))
+				if err != nil {
+					return nil, err
+				}
 			}
-			lastLine = comment
-			// Detect `![image](path)` tags and add the path to the
-			// media list.
-			path, err := extractMediaPath(line)
+			held = nil
+		}
+		if !hold {
+			err = c.line(line, isDirective(line))
 			if err != nil {
-				return "", nil, errors.New("Unable to extract media path from line " + line + "\n" + err.Error())
-			}
-			if path != "" {
-				media[path] = struct{}{}
+				return nil, err
 			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+	}
+	// Held lines at the end of the input are prose.
+	for _, h := range held {
+		err = c.line(h, isDirective(h))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c.media, c.finish()
+}
 
-			repl, path, err := replaceHypeTag(line)
-			if err != nil {
-				return "", nil, errors.New("Failed generating Hype tag from line " + line + "\n" + err.Error())
+// line converts a single line. `isDirectiveLine` is true for Go directives
+// like //go:generate and build constraints like // +build linux.
+func (c *converter) line(line string, isDirectiveLine bool) error {
+	c.lineNo++
+	// With `-min-prose-len`, a comment within code is held back until its
+	// paragraph ends. Only then is it clear whether it is long enough to
+	// become prose, or if it stays in the code block. An empty comment line,
+	// like a bare `//`, ends the paragraph, unless no text came yet.
+	if c.opts.MinProseLen > 0 && !c.keepsComments() {
+		inCode := !isDirectiveLine && c.lastLine == lineCode && !c.inBlockComment() && !gtmDirective.MatchString(line) &&
+			c.hideStart == 0 && comment.MatchString(line)
+		if inCode && (strings.TrimSpace(stripOnce(comment, line)) != "" || proseLen(c.pending) == 0) {
+			c.pending = append(c.pending, line)
+			return nil
+		}
+		err := c.flushPending()
+		if err != nil {
+			return err
+		}
+		// Between two paragraphs that stay in the code block, the empty
+		// comment line stays there, too.
+		if inCode && c.lastLine == lineCode {
+			c.codeLine(line)
+			return nil
+		}
+	}
+	// gotomarkdown's own directives never make it into the output.
+	// Within a hidden region, only `//gtm:hide` and `//gtm:show` count.
+	if m := gtmDirective.FindStringSubmatch(line); m != nil && (c.hideStart == 0 || m[1] == "hide" || m[1] == "show") {
+		return c.gtmDirective(m[1], m[2])
+	}
+	// A hidden line goes, but a `/*...*/` section that starts or ends
+	// within the hidden region must still be tracked.
+	if c.hideStart != 0 {
+		c.isInComment(line)
+		return nil
+	}
+	// Skip the line if it is a directive.
+	// With `-keep-directives`, the line is treated as code instead.
+	if isDirectiveLine && !c.opts.KeepDirectives {
+		return nil
+	}
+	if packageClause.MatchString(line) && !c.inBlockComment() {
+		c.pastPackage = true
+		if c.opts.SkipPackage {
+			c.skip()
+			return nil
+		}
+	}
+	// With `-skip-package`, skip all import declarations, including
+	// any comments within an import group. With `-trim-imports`, an import
+	// group that spans several lines becomes a single line, and single
+	// imports stay.
+	if c.opts.SkipPackage || c.opts.TrimImports {
+		if c.inImports {
+			c.inImports = !importEnd.MatchString(line)
+			if c.opts.SkipPackage {
+				c.skip()
 			}
-			if repl != "" && path != "" {
-				out += repl
-				media[path] = struct{}{}
-			} else {
-				// Strip out any comment delimiter and add the line to the output.
-				out += allCommentDelims.ReplaceAllString(line, "") + "\n"
+			return nil
+		}
+		if m := importDecl.FindStringSubmatch(line); m != nil {
+			group := m[1] == "(" && !strings.Contains(line, ")")
+			if c.opts.SkipPackage {
+				c.inImports = group
+				c.skip()
+				return nil
 			}
-		} else { // not in comment
-			// Open a new code block if the last line was a comment,
-			// but take care of empty lines between two comment lines.
-			if lastLine == comment && len(line) > 0 {
-				lastLine = code
-				out += "\n```go\n"
+			if group {
+				c.inImports = true
+				c.codeLine("import ( ... )")
+				return nil
 			}
-			// Add code lines verbatim to the output.
-			out += line + "\n"
 		}
 	}
-	if lastLine == code {
-		out += "\n```\n"
+	if isDirectiveLine || c.keepsComments() {
+		c.codeLine(line)
+		return nil
 	}
-	return out, media, nil
+	return c.commentOrCode(line)
 }
 
-// ## Converting a file
-//
-// ### Again, some helper functions
-//
-// `base` strips the extension from a filename. For some reason, this
-// function is missing from the standard path library.
-func base(name string) string {
-	return strings.TrimSuffix(name, filepath.Ext(name))
+// skip drops a line from the output, like the package clause with
+// `-skip-package`. The blank lines around it go, too, but for one that
+// keeps the comments or the code before and after it apart.
+func (c *converter) skip() {
+	c.skipped = true
+	switch c.lastLine {
+	case lineCode:
+		for len(c.code) > 0 && c.code[len(c.code)-1] == "" {
+			c.code = c.code[:len(c.code)-1]
+		}
+	case lineComment:
+		c.blanks = 1
+	}
 }
 
-// `createPath` receives a path and creates all directories in that path
-// that are missing. Permissions are set to u+rwx go+r.
-func createPath(p string) (err error) {
-	if path.Clean(p) != "." {
-		err = os.MkdirAll(p, 0744) // -rwxr--r--
+// keepsComments returns true if comments are code from now on, as with
+// `-keep-go-comments` after the package clause. (Files of other languages
+// have no package clause; see `rewriteComments`.)
+func (c *converter) keepsComments() bool {
+	return c.opts.KeepComments && c.pastPackage
+}
+
+// flushPending converts the comment lines held back for
+// `-min-prose-len`: as code if their text is shorter than `MinProseLen`,
+// or else as prose.
+func (c *converter) flushPending() error {
+	pending := c.pending
+	c.pending = nil
+	n := proseLen(pending)
+	// The output of an example is never too short (see examples.go).
+	if len(pending) > 0 && outputComment.MatchString(pending[0]) {
+		n = c.opts.MinProseLen
+	}
+	for _, line := range pending {
+		if n < c.opts.MinProseLen {
+			c.codeLine(line)
+			continue
+		}
+		err := c.commentOrCode(line)
 		if err != nil {
-			return errors.New("Cannot create path: " + p + " - Error: " + err.Error())
+			return err
 		}
 	}
 	return nil
 }
 
-// ### Now the actual conversion
+// proseLen returns the number of characters of text in the comment `lines`.
+func proseLen(lines []string) int {
+	n := 0
+	for _, line := range lines {
+		n += len(strings.TrimSpace(stripOnce(comment, line)))
+	}
+	return n
+}
+
+// gtmDirective handles a `//gtm:name arg` directive:
 //
-// `convertFile` takes a file name, reads that file, converts it to
-// Markdown, and writes it to `*outDir/&lt;basename>.md
-func convertFile(filename string) (media map[string]struct{}, err error) {
-	src, err := ioutil.ReadFile(filename)
-	if err != nil {
-		log.Fatal("Cannot read file " + filename + "\n" + err.Error())
+//   - `//gtm:fence go {hl_lines=[2]}` sets the info string of the next code
+//     fence, for example to add hints for the syntax highlighter. Within
+//     code, it ends the code block, and the code after it gets the fence.
+//   - `//gtm:include other.go#func=Foo` inserts code from another file
+//     (see include.go).
+//   - `//gtm:caption Parsing the input` sets the heading for the caption
+//     of the next code block, with `-code-caption`.
+//   - `//gtm:hide` and `//gtm:show` drop everything between them, prose
+//     and code alike, like the details that would distract a reader.
+func (c *converter) gtmDirective(name, arg string) error {
+	var msg string
+	switch name {
+	case "fence":
+		// Indented code has no fences to set, so it can go on.
+		if c.lastLine == lineCode && c.indent == "" {
+			c.writeCode(c.finalCode)
+			c.lastLine, c.blanks = lineComment, 1
+		}
+		c.nextFence = arg
+		return nil
+	case "caption":
+		c.nextCaption = arg
+		return nil
+	case "include":
+		lines, err := readInclude(c.opts.files(), arg, c.opts.BaseDir)
+		if err != nil {
+			return errors.New(err.Error() + "\nat " + c.position(c.lineNo))
+		}
+		for _, line := range lines {
+			c.codeLine(line)
+		}
+		return nil
+	case "hide":
+		if c.hideStart == 0 {
+			c.hideStart = c.lineNo
+			return nil
+		}
+		msg = "Nested //gtm:hide at " + c.position(c.lineNo) + " within the hidden region from " + c.position(c.hideStart)
+	case "show":
+		if c.hideStart != 0 {
+			c.hideStart = 0
+			return nil
+		}
+		msg = "//gtm:show without //gtm:hide at " + c.position(c.lineNo)
+	default:
+		msg = "Unknown directive //gtm:" + name + " at " + c.position(c.lineNo)
 	}
-	name := filepath.Base(filename)
-	ext := ".md"
-	basename := base(name) // strip ".go"
-	outname := filepath.Join(*outDir, basename) + ext
-	md, media, err := convert(string(src))
-	if err != nil {
-		return nil, errors.New("Error converting " + filename + "\n" + err.Error())
+	if c.opts.Strict {
+		return errors.New(msg)
 	}
-	err = createPath(*outDir)
-	if err != nil {
-		return nil, err // The error message from createPath is chatty enough.
+	log.Println("[Warning] " + msg)
+	return nil
+}
+
+// commentOrCode converts a line, depending on whether it belongs to a comment.
+func (c *converter) commentOrCode(line string) error {
+	wasInBlock := c.inBlockComment()
+	c.inBlockBody = wasInBlock
+	// Determine if the line belongs to a comment.
+	if !c.isInComment(line) {
+		c.codeLine(line)
+		return nil
 	}
-	err = ioutil.WriteFile(outname, []byte(md), 0644) // -rw-r--r--
-	if err != nil {
-		return nil, errors.New("Cannot write file " + outname + " \n" + err.Error())
+	if !c.inBlockComment() {
+		c.blockStart = 0
+	} else if c.blockStart == 0 {
+		c.blockStart = c.lineNo
 	}
-	return media, nil
+	// A `/*...*/` section can end in the middle of a line, as in
+	// `/* note */ x := 1`. Whatever follows the `*/` is converted on
+	// its own, as if it were on the next line.
+	if wasInBlock || !comment.MatchString(line) {
+		commentPart, rest := splitBlockEnd(line, wasInBlock)
+		if rest != "" {
+			err := c.commentLine(commentPart)
+			if err != nil {
+				return err
+			}
+			return c.commentOrCode(rest)
+		}
+	}
+	return c.commentLine(line)
 }
 
-// ## main - Where it all starts
+// commentLine converts a line that belongs to a comment.
+func (c *converter) commentLine(line string) error {
+	// With `-strip-stars`, the lines of a `/*...*/` section lose the
+	// leading ` * ` that some authors put there for decoration.
+	if c.opts.StripStars && c.inBlockBody {
+		line = leadingStar.ReplaceAllString(line, "")
+	}
+	// Drop excluded paragraphs as if they were not there at all.
+	if c.exclude != nil {
+		text := strings.TrimSpace(c.stripDelims(line))
+		if text == "" {
+			// The empty line that ends a dropped paragraph goes, too.
+			wasExcluding := c.excluding
+			c.inPara, c.excluding = false, false
+			if wasExcluding {
+				return nil
+			}
+		} else if !c.inPara {
+			c.inPara = true
+			c.excluding = c.exclude.MatchString(text)
+		}
+		if c.excluding {
+			return nil
+		}
+	}
+	// Close the code block if a new comment begins.
+	afterCode := c.lastLine == lineCode
+	if afterCode {
+		c.writeCode(c.closeCode)
+	}
+	// Blank lines between two comments separate paragraphs.
+	// Blank lines at the start of the file are dropped.
+	if c.lastLine != lineNeither {
+		c.out.WriteString(strings.Repeat("\n", c.blanks))
+	}
+	if c.blanks > 0 {
+		c.afterText = false
+	}
+	c.blanks, c.skipped = 0, false
+	c.lastLine = lineComment
+	// With `-front-matter-passthrough`, front matter in the first comment,
+	// between `---` or `+++` lines, goes to the top of the output as it is.
+	if c.frontMatter != "" {
+		text := strings.TrimRight(c.stripDelims(line), " \t")
+		if strings.TrimSpace(text) == c.frontMatter {
+			c.frontMatter = ""
+			c.sawText = true
+		}
+		c.out.WriteString(text + "\n")
+		return nil
+	}
+	if c.opts.RawFrontMatter && !c.sawText {
+		text := strings.TrimSpace(c.stripDelims(line))
+		if text == "" {
+			return nil
+		}
+		if text == "---" || text == "+++" {
+			c.frontMatter = text
+			c.out.WriteString(text + "\n")
+			return nil
+		}
+	}
+	c.sawText = true
+	// So do empty comment lines, like a bare `//` or an empty line within
+	// `/*...*/`. Each one becomes an empty line, whatever comes before or
+	// after, except right after a code block, which ends with an empty line
+	// already.
+	if strings.TrimSpace(c.stripDelims(line)) == "" {
+		if c.fenceHeld != "" {
+			c.fenceHeld += "\n"
+			return nil
+		}
+		if !afterCode {
+			c.out.WriteString("\n")
+		}
+		c.afterText, c.callout = false, ""
+		return nil
+	}
+	// The content of a code fence within the comment, like a ```mermaid
+	// diagram, passes through untouched. Only the comment delimiters go.
+	if c.inFence {
+		text := c.stripDelims(line)
+		if closesFence(text, c.fenceStart) {
+			c.inFence = false
+		}
+		c.out.WriteString(c.fenceHeld + trimTrailing(text, c.opts.KeepHardBreaks) + "\n")
+		c.fenceHeld = ""
+		return nil
+	}
+	// Detect `![image](path)` tags and add the path to the
+	// media list.
+	path, err := extractMediaPath(line)
+	if err != nil {
+		return errors.New("Unable to extract media path at " + c.position(c.lineNo) + "\n" + err.Error())
+	}
+	if path == "" && imageTag.MatchString(line) {
+		c.count(&c.opts.run.summary.remote)
+	}
+	if path != "" {
+		target, copy, err := c.imageTarget(path)
+		if err != nil {
+			return err
+		}
+		// The tag gets the new target, and the slashes instead of
+		// backslashes, for renderers on Unix.
+		if tag := c.mediaURL(target, copy); tag != unbracket(strings.Trim(imageTag.FindStringSubmatch(line)[1], " \t")) {
+			line = rewriteImagePath(line, tag)
+		}
+		if copy {
+			c.addMedia(path, target, c.lineNo)
+		}
+	}
+	// Raw HTML images, like `<img src="x.png">`, are media files, too.
+	line, err = c.htmlImages(line)
+	if err != nil {
+		return err
+	}
+	// Collect reference-style images and reference definitions.
+	stripped := c.stripDelims(line)
+	for _, id := range extractImageRefs(stripped) {
+		c.imageRefs[id] = true
+	}
+	if id, p := extractRefDef(stripped); id != "" {
+		c.refDefs[id] = p
+		c.refLines[id] = c.lineNo
+	}
+	// With `-copy-links`, also collect local files that are
+	// targets of `[text](path)` links.
+	if c.opts.CopyLinks {
+		for _, p := range extractLinkPaths(line, c.opts.files(), c.opts.BaseDir) {
+			if _, ok := c.runFile(p); ok && c.opts.GoLinks {
+				continue // a link to another document (see golinks.go)
+			}
+			if _, ok := c.media[c.resolve(p)]; !ok {
+				c.addMedia(p, p, c.lineNo)
+			}
+		}
+	}
 
-func main() {
-	flag.Parse()
-	for _, filename := range flag.Args() {
-		log.Println("Converting", filename)
-		media, err := convertFile(filename)
+	// Replace Hype tags by their HTML snippets, unless `-no-hype` turns
+	// them into plain text.
+	var snippets []hypeSnippet
+	if !c.opts.NoHype {
+		snippets, err = replaceHypeTags(c.opts.files(), line, c.opts.SnippetStart, c.opts.SnippetEnd, c.opts.BaseDir)
 		if err != nil {
-			log.Fatal("[Conversion Error] " + err.Error())
-		}
-		if *dontCopyMedia == false && media != nil && (path.Clean(*outDir) != "." || *subDir == true) {
-			log.Println("Copying media")
-			out := *outDir
-			if *subDir {
-				out = filepath.Join(*outDir, base(filename))
-				err := createPath(out)
-				if err != nil {
-					log.Fatal("[CopyMedia Error] Cannot create subdir for media files.\n" + err.Error())
+			return errors.New("Failed generating Hype tag at " + c.position(c.lineNo) + "\n" + err.Error())
+		}
+	}
+	if len(snippets) > 0 {
+		for _, snippet := range snippets {
+			c.out.WriteString(snippet.html)
+			c.addMedia(snippet.resources, snippet.resources, c.lineNo)
+		}
+		return nil
+	}
+	// Strip out any comment delimiter and add the line to the output.
+	// Headings get offset and anchored, doc links get replaced, and
+	// accidental markup gets escaped, if requested.
+	// An indented block after an empty line is a code example, as in Go
+	// doc comments. It keeps its indentation and gets no other changes.
+	text := c.stripDelims(line)
+	c.inExample = indentedCode.MatchString(text) && (c.inExample || !c.afterText)
+	if m := fence.FindStringSubmatch(text); m != nil && !c.inExample {
+		c.inFence = true
+		c.fenceStart, c.fenceLine = m[1], c.lineNo
+		c.callout = ""
+		// The opening waits for the content of the fence. A fence that
+		// never gets any, at the end of the file, say, gets dropped.
+		c.fenceHeld = trimTrailing(text, c.opts.KeepHardBreaks) + "\n"
+		c.afterText = true
+		return nil
+	} else if !c.inExample {
+		// With `-section-breaks`, each top-level heading but the first
+		// starts a new section. A separator after a line of text needs a
+		// blank line before it, or else `---` would turn that line into
+		// a heading.
+		if h := heading.FindStringSubmatch(text); h != nil && h[2] == "#" && c.opts.SectionBreaks != "" {
+			if c.sawHeading {
+				if c.afterText {
+					c.out.WriteString("\n")
 				}
+				c.out.WriteString(c.opts.SectionBreaks + "\n\n")
 			}
-			err := copyFiles(out, media)
-			if err != nil {
-				log.Fatal("[CopyMedia Error] cp failed:\n" + err.Error())
+			c.sawHeading = true
+		}
+		if h := heading.FindStringSubmatchIndex(text); h != nil {
+			c.lastHeading = strings.TrimSpace(headingID.ReplaceAllString(strings.TrimRight(strings.TrimSpace(text[h[1]:]), "#"), ""))
+		}
+		text = offsetHeading(text, c.opts.HeadingOffset)
+		if c.opts.Anchors {
+			text = anchorHeading(text, c.ids)
+		}
+		if c.opts.DocLinks != "" {
+			text = replaceDocLinks(text, c.opts.DocLinks)
+		}
+		if c.opts.GoLinks {
+			text = c.rewriteGoLinks(text)
+		}
+		if c.opts.StrictMarkdown {
+			text = escapeProse(text)
+		}
+		text = c.emit.prose(text)
+		if c.opts.Admonitions != "" {
+			first := !c.afterText
+			if first {
+				c.callout = admonitionStart(text)
+			}
+			if c.callout != "" {
+				text = admonish(text, c.callout, first, c.opts.Admonitions, c.opts.Format)
 			}
 		}
 	}
-	log.Println("Done.")
+	c.out.WriteString(trimTrailing(text, c.opts.KeepHardBreaks) + "\n")
+	c.afterText = true
+	return nil
+}
+
+// codeLine converts a line that does not belong to a comment.
+func (c *converter) codeLine(line string) {
+	c.inPara, c.afterText, c.callout = false, false, ""
+	// The blank lines after a skipped line go (see `skip`), and one blank
+	// line takes their place within a code block.
+	if c.skipped {
+		if strings.TrimSpace(line) == "" {
+			return
+		}
+		c.skipped = false
+		if c.lastLine == lineCode && len(c.code) > 0 {
+			c.code = append(c.code, "")
+		}
+	}
+	// Open a new code block if the last line was a comment, or if the
+	// file starts with code, but take care of empty lines between two
+	// comment lines. These are held back until it is clear whether a
+	// comment or code follows. A code block never starts with blank lines;
+	// a single blank line separates it from the prose.
+	if c.lastLine != lineCode {
+		if strings.TrimSpace(line) == "" {
+			c.blanks++
+			return
+		}
+		c.blanks = 0
+		atStart := c.lastLine == lineNeither
+		c.lastLine = lineCode
+		c.sawText = true
+		if c.frontMatter != "" {
+			log.Println("[Warning] Unterminated front matter in comment before " + c.position(c.lineNo))
+			c.out.WriteString(c.frontMatter + "\n")
+			c.frontMatter = ""
+		}
+		// A code fence in the comment that is still open would swallow
+		// the code block, so close it first.
+		if c.inFence {
+			c.closeFence("before " + c.position(c.lineNo))
+		}
+		c.codeOpen = c.openCode
+		if c.nextFence != "" {
+			c.codeOpen = "\n" + c.emit.openCode(c.nextFence)
+		}
+		c.nextFence = ""
+		if c.caption != nil {
+			c.codeOpen = c.captionLine() + c.codeOpen
+		}
+		if atStart {
+			c.codeOpen = strings.TrimPrefix(c.codeOpen, "\n")
+		}
+	}
+	// Add code lines verbatim to the output, except for trailing whitespace.
+	// Indented code lines get indented, except for blank lines, which
+	// remain blank.
+	// With `-tabwidth`, tabs get expanded, as renderers disagree about
+	// how wide a tab is.
+	line = expandTabs(strings.TrimRight(line, " \t"), c.opts.TabWidth)
+	if c.indent != "" {
+		if line != "" {
+			line = c.indent + line
+		}
+	}
+	c.code = append(c.code, line)
+}
+
+// captionData are the variables for the caption template of a code block.
+type captionData struct {
+	Heading string // the text of the nearest heading above, or of the last //gtm:caption
+	Number  int    // the number of the code block, counting from 1
+}
+
+// captionLine returns the caption for the code block that starts now,
+// followed by a line break, or nothing if there is no heading to take the
+// caption from.
+func (c *converter) captionLine() string {
+	heading := c.lastHeading
+	if c.nextCaption != "" {
+		heading, c.nextCaption = c.nextCaption, ""
+	}
+	if heading == "" {
+		return ""
+	}
+	c.listings++
+	var b strings.Builder
+	err := c.caption.Execute(&b, captionData{Heading: heading, Number: c.listings})
+	if err != nil {
+		log.Println("[Warning] Cannot fill in the caption at " + c.position(c.lineNo) + ": " + err.Error())
+		return ""
+	}
+	return "\n" + b.String() + "\n"
+}
+
+// writeCode writes the current code block, followed by `end`. Blank lines
+// at the end of the block are dropped, and so is a block without any code,
+// so that every opening fence gets exactly one closing fence and no fenced
+// block is empty.
+func (c *converter) writeCode(end string) {
+	for len(c.code) > 0 && c.code[len(c.code)-1] == "" {
+		c.code = c.code[:len(c.code)-1]
+	}
+	if len(c.code) == 0 {
+		// Keep the paragraph break between the comments around the block.
+		if strings.HasSuffix(end, "\n\n") {
+			c.out.WriteString("\n")
+		}
+		return
+	}
+	f := c.emit.fence(c.code)
+	c.out.WriteString(strings.Replace(c.codeOpen, "```", f, 1))
+	width := len(strconv.Itoa(len(c.code)))
+	for i, line := range c.code {
+		if c.opts.LineNumbers && c.indent != "" {
+			n := strconv.Itoa(i + 1)
+			line = strings.TrimRight(c.indent+strings.Repeat(" ", width-len(n))+n+"  "+strings.TrimPrefix(line, c.indent), " ")
+		}
+		c.out.WriteString(line + "\n")
+	}
+	c.out.WriteString(strings.Replace(end, "```", f, 1))
+	c.code = nil
+}
+
+// closesFence returns true if `text` is the closing fence for the opening
+// fence `start`: at least as many of the same characters, and nothing else.
+func closesFence(text, start string) bool {
+	text = strings.TrimSpace(text)
+	run := strings.TrimLeft(text, start[:1])
+	return run == "" && len(text) >= len(start)
+}
+
+// codeFence returns a fence of backticks that is longer than any run of
+// backticks at the start of the `lines`, and at least three backticks long.
+func codeFence(lines []string) string {
+	n := 3
+	for _, line := range lines {
+		line = strings.TrimLeft(line, " \t")
+		run := len(line) - len(strings.TrimLeft(line, "`"))
+		if run >= n {
+			n = run + 1
+		}
+	}
+	return strings.Repeat("`", n)
+}
+
+// finish closes an open code block and flushes the output.
+// A `/*` without a matching `*/` turns the rest of the file into prose,
+// which is hardly ever intended. finish logs a warning about this, or
+// returns an error with `-strict`.
+func (c *converter) finish() error {
+	err := c.flushPending()
+	if err != nil {
+		return err
+	}
+	// Now that all reference definitions are known, add the media files
+	// of the reference-style images.
+	for id := range c.imageRefs {
+		p, ok := c.refDefs[id]
+		if ok && p != "" && isRemote(p) {
+			c.count(&c.opts.run.summary.remote)
+		} else if ok && p != "" {
+			if _, ok := c.media[c.resolve(p)]; !ok {
+				c.addMedia(p, p, c.refLines[id])
+			}
+		}
+	}
+	// Media files that do not exist would make the copying fail later,
+	// so check them now while the line that refers to them is known.
+	if copiesMedia(c.opts) && c.opts.MediaResolver == nil {
+		err := c.checkMedia()
+		if err != nil {
+			return err
+		}
+	}
+	if c.blockStart != 0 {
+		msg := "Unterminated block comment starting at " + c.position(c.blockStart)
+		if c.opts.Strict {
+			return errors.New(msg)
+		}
+		log.Println("[Warning] " + msg)
+	}
+	if c.hideStart != 0 {
+		msg := "//gtm:hide at " + c.position(c.hideStart) + " has no //gtm:show; the rest of the file is hidden"
+		if c.opts.Strict {
+			return errors.New(msg)
+		}
+		log.Println("[Warning] " + msg)
+	}
+	if c.inFence {
+		c.closeFence("at the end of the file, starting at " + c.position(c.fenceLine))
+	}
+	if c.lastLine == lineCode {
+		c.writeCode(c.finalCode)
+	}
+	err = c.out.Flush()
+	if err != nil {
+		return errors.New("Cannot write output\n" + err.Error())
+	}
+	return nil
+}
+
+// closeFence closes the code fence within comment text that is still
+// open `where` the comment ends. A fence without any content gets dropped
+// instead, as an empty code block helps no one.
+func (c *converter) closeFence(where string) {
+	if c.fenceHeld != "" {
+		log.Println("[Warning] Dropping empty, unterminated code fence in comment " + where)
+	} else {
+		log.Println("[Warning] Unterminated code fence in comment " + where)
+		c.out.WriteString(c.fenceStart + "\n")
+	}
+	c.inFence, c.fenceHeld = false, ""
+}
+
+// stripDelims removes the comment delimiters from a comment line: the
+// leading `//` of a line comment, or the `/*` and `*/` of a `/*...*/`
+// section. Any other `//` or `*/` is text, like in the URL
+// `//example.com/x.js` within a `/*...*/` section, or in `// the glob a*/`.
+func (c *converter) stripDelims(line string) string {
+	if c.inBlockBody {
+		return stripOnce(commentEnd, line)
+	}
+	if comment.MatchString(line) {
+		return stripOnce(comment, line)
+	}
+	line = stripOnce(commentStart, line)
+	if !c.inBlockComment() {
+		line = stripOnce(commentEnd, line)
+	}
+	return line
+}
+
+// stripOnce removes the first match of `re` from `line`.
+func stripOnce(re *regexp.Regexp, line string) string {
+	loc := re.FindStringIndex(line)
+	if loc == nil {
+		return line
+	}
+	return line[:loc[0]] + line[loc[1]:]
+}
+
+// resolve returns the path of the media file `p` from the document,
+// which is relative to `BaseDir`.
+func (c *converter) resolve(p string) string {
+	if c.opts.BaseDir == "" {
+		return p
+	}
+	return filepath.Join(c.opts.BaseDir, strings.Trim(p, " \t"))
+}
+
+// imageTarget returns the path that the tag of the local image `p` must
+// refer to, and true if the image must be copied there.
+// With `-embed-images`, the target is a `data:` URI, and there is nothing
+// to copy (see embed.go). With `-assets-dir`, the image goes into the
+// assets directory.
+func (c *converter) imageTarget(p string) (target string, copy bool, err error) {
+	switch {
+	case c.opts.EmbedImages:
+		uri, err := dataURI(c.opts.files(), c.resolve(p))
+		if err != nil {
+			msg := "Cannot embed image " + p + " at " + c.position(c.lineNo)
+			if c.opts.Strict {
+				return "", false, errors.New(msg + "\n" + err.Error())
+			}
+			log.Println("[Warning] " + msg)
+			c.count(&c.opts.run.summary.missing)
+			return p, false, nil
+		}
+		return uri, false, nil
+	case c.opts.AssetsDir != "":
+		name := c.opts.run.assets.name(filepath.Join(c.opts.OutDir, c.opts.AssetsDir), c.resolve(p))
+		return filepath.ToSlash(filepath.Join(c.opts.AssetsDir, name)), true, nil
+	}
+	return p, true, nil
+}
+
+// mediaURL returns the path for the tag of an image with the target path
+// `target`. With `-base-url`, the path of an image that gets copied starts
+// with the base URL, for documents that are served from a subpath.
+func (c *converter) mediaURL(target string, copy bool) string {
+	if c.opts.BaseURL == "" || !copy {
+		return target
+	}
+	return strings.TrimSuffix(c.opts.BaseURL, "/") + "/" + path.Clean(target)
+}
+
+// htmlImages adds the local images of the HTML image tags in `line` to the
+// media files, and returns the line with the `src` attributes rewritten as
+// `imageTarget` says.
+func (c *converter) htmlImages(line string) (string, error) {
+	locs := htmlImgTag.FindAllStringSubmatchIndex(line, -1)
+	inCodeSpan := codeSpans(line)
+	// Rewrite from the end, so that the offsets stay valid.
+	for i := len(locs) - 1; i >= 0; i-- {
+		// A tag in a code span, like `<img src="...">`, is only text.
+		if inCodeSpan(locs[i][0]) {
+			continue
+		}
+		start, end := locs[i][2], locs[i][3]
+		if start < 0 {
+			start, end = locs[i][4], locs[i][5]
+		}
+		raw := line[start:end]
+		p := strings.Replace(strings.TrimSpace(raw), "\\", "/", -1)
+		if p == "" {
+			continue
+		}
+		if isRemote(p) {
+			c.count(&c.opts.run.summary.remote)
+			continue
+		}
+		target, copy, err := c.imageTarget(p)
+		if err != nil {
+			return "", err
+		}
+		if tag := c.mediaURL(target, copy); tag != raw {
+			line = line[:start] + tag + line[end:]
+		}
+		if copy {
+			c.addMedia(p, target, c.lineNo)
+		}
+	}
+	return line, nil
+}
+
+// codeSpans returns a function that tells if the position `i` of `line`
+// is within a code span, that is, after an odd number of backticks, as in
+// doclinks.go. The backticks are found once, so that a line with many
+// matches does not take quadratic time.
+func codeSpans(line string) func(i int) bool {
+	var ticks []int // positions of the backticks
+	for i := 0; i < len(line); i++ {
+		if line[i] == '`' {
+			ticks = append(ticks, i)
+		}
+	}
+	return func(i int) bool {
+		return sort.SearchInts(ticks, i)%2 == 1
+	}
+}
+
+// addMedia adds the media file `p` from the document with the target path
+// `target`, which the line `lineNo` refers to.
+func (c *converter) addMedia(p, target string, lineNo int) {
+	p = c.resolve(p)
+	if _, ok := c.media[p]; !ok {
+		c.mediaLines[p] = lineNo
+	}
+	c.media[p] = target
+}
+
+// checkMedia verifies that the media files exist. Missing files are an
+// error with `-strict`. Otherwise, they get a warning and are dropped from
+// the media list, so that the other files can still be copied.
+func (c *converter) checkMedia() error {
+	var paths []string
+	for p := range c.media {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		if _, err := fs.Stat(c.opts.files(), strings.Trim(p, " \t")); err == nil {
+			continue
+		}
+		msg := "Referenced media not found: " + p + " at " + c.position(c.mediaLines[p])
+		if c.opts.Strict {
+			return errors.New(msg)
+		}
+		log.Println("[Warning] " + msg)
+		delete(c.media, p)
+		c.count(&c.opts.run.summary.missing)
+	}
+	return nil
+}
+
+// count increments the counter `n` of the run summary.
+func (c *converter) count(n *int) {
+	c.opts.run.summary.add(n)
+}
+
+// position returns a line number for messages, including the name of the
+// input if known, as in `foo.go:12`.
+func (c *converter) position(lineNo int) string {
+	lineNo = c.opts.lineMap.line(lineNo)
+	if c.opts.Name == "" {
+		return "line " + strconv.Itoa(lineNo)
+	}
+	return c.opts.Name + ":" + strconv.Itoa(lineNo)
+}
+
+// ConvertStream reads commented Go code from `r`, converts it into a
+// Markdown document, and writes the document to `w`, line by line. This
+// way, even very large generated files need not fit into memory at once.
+// ConvertStream stops early if `ctx` gets canceled. It returns the paths of
+// the media files that the document refers to.
+func ConvertStream(ctx context.Context, r io.Reader, w io.Writer, opts Options) (media []string, err error) {
+	m, err := convertStream(ctx, r, w, opts)
+	if err != nil {
+		return nil, err
+	}
+	for p := range m {
+		media = append(media, p)
+	}
+	sort.Strings(media)
+	return media, nil
+}
+
+// convert receives a string containing commented Go code and converts it
+// into a Markdown document. Collect and return any media files
+// found during this process, mapped to their target paths in the output directory.
+func convert(in string, opts Options) (out string, media map[string]string, err error) {
+	var buf bytes.Buffer
+	media, err = convertStream(context.Background(), strings.NewReader(in), &buf, opts)
+	if err != nil {
+		return "", nil, err
+	}
+	return buf.String(), media, nil
+}
+
+// ## Converting a file
+//
+// ### Again, some helper functions
+//
+// `base` strips the extension from a filename. For some reason, this
+// function is missing from the standard path library.
+func base(name string) string {
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+// `samePath` returns true if the paths `a` and `b` refer to the same file.
+func samePath(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	if errA != nil || errB != nil {
+		return filepath.Clean(a) == filepath.Clean(b)
+	}
+	return absA == absB
+}
+
+// `createPath` receives a path and creates all directories in that path
+// that are missing. Permissions are set to u+rwx go+r.
+func createPath(p string) (err error) {
+	if path.Clean(p) != "." {
+		err = os.MkdirAll(p, 0744) // -rwxr--r--
+		if err != nil {
+			return errors.New("Cannot create path: " + p + " - Error: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// `outputName` returns the path of the output file for the source file
+// `filename`: `OutDir/&lt;basename>.md`, or another extension with
+// `-output-ext`, or `.html` with `-html`. With `-name-from-heading`, the
+// base name comes from the first heading of the document, if it has one.
+func outputName(filename string, opts Options) string {
+	name := filepath.Base(filename)
+	ext := ".md"
+	if opts.OutputExt != "" {
+		ext = opts.OutputExt
+	}
+	if opts.Format == "asciidoc" && (ext == ".md" || opts.OutputExt == "") {
+		ext = ".adoc"
+	}
+	if opts.HTML {
+		ext = ".html"
+	}
+	basename := base(name) // strip ".go"
+	if opts.HeadingName && opts.run != nil {
+		if n, ok := opts.run.docNames.get(filename); ok {
+			basename = n
+		}
+	}
+	return filepath.Join(outDir(filename, opts), basename) + ext
+}
+
+// headingNames holds the base names of the output files that come from
+// the first heading of each document. Documents with the same heading get
+// a numeric suffix, as in `getting-started-1.md`. The suffixes follow the
+// order of the files in the run, also with `-j`: a file waits with its
+// name until the files before it have theirs.
+type headingNames struct {
+	sync.Mutex
+	turn  *sync.Cond
+	names map[string]string // source file name -> base name
+	taken map[string]bool   // output directory + base names in use
+	order map[string]int    // source file name -> position in the run
+	next  int               // the position whose turn it is
+}
+
+func newHeadingNames() *headingNames {
+	h := &headingNames{names: map[string]string{}, taken: map[string]bool{}}
+	h.turn = sync.NewCond(h)
+	return h
+}
+
+// start sets the order of the files of a run.
+func (h *headingNames) start(filenames []string) {
+	h.Lock()
+	defer h.Unlock()
+	h.order = map[string]int{}
+	for i, f := range filenames {
+		if _, ok := h.order[f]; !ok {
+			h.order[f] = i
+		}
+	}
+	h.next = 0
+	h.skip()
+}
+
+// set derives the base name of `filename` in the output directory `dir`
+// from `heading`, once it is the turn of `filename`. A document without
+// a heading passes "", to give the turn to the next file.
+func (h *headingNames) set(filename, dir, heading string) {
+	h.Lock()
+	defer h.Unlock()
+	i, inRun := h.order[filename]
+	for inRun && h.next < i {
+		h.turn.Wait()
+	}
+	if inRun {
+		h.done(filename)
+	}
+	if heading == "" {
+		return
+	}
+	// After a change in watch mode, the old name is free again.
+	if old, ok := h.names[filename]; ok {
+		delete(h.taken, dir+"\x00"+old)
+	}
+	name := slug(heading)
+	for i := 1; h.taken[dir+"\x00"+name]; i++ {
+		name = slug(heading) + "-" + strconv.Itoa(i)
+	}
+	h.names[filename] = name
+	h.taken[dir+"\x00"+name] = true
+}
+
+// finish gives up the turn of `filename`, for files that fail before they
+// get to set their name.
+func (h *headingNames) finish(filename string) {
+	h.Lock()
+	defer h.Unlock()
+	h.done(filename)
+}
+
+// done removes `filename` from the order, and passes the turn on to the
+// next file that is still to come. h must be locked.
+func (h *headingNames) done(filename string) {
+	if _, ok := h.order[filename]; !ok {
+		return
+	}
+	delete(h.order, filename)
+	h.skip()
+	h.turn.Broadcast()
+}
+
+// skip moves the turn past the positions of the files that are done.
+// h must be locked.
+func (h *headingNames) skip() {
+	waiting := map[int]bool{}
+	for _, i := range h.order {
+		waiting[i] = true
+	}
+	for len(waiting) > 0 && !waiting[h.next] {
+		h.next++
+	}
+}
+
+// get returns the base name of `filename`, and false if it has none.
+func (h *headingNames) get(filename string) (string, bool) {
+	h.Lock()
+	defer h.Unlock()
+	name, ok := h.names[filename]
+	return name, ok
+}
+
+// `outDir` returns the directory for the output of the source file
+// `filename`: `OutDir`, or with `PreservePaths`, the directory of the file
+// below `OutDir`. Paths that would lead outside of `OutDir` are not preserved.
+func outDir(filename string, opts Options) string {
+	dir := filepath.Dir(filename)
+	if !opts.PreservePaths || filepath.IsAbs(dir) || dir == ".." || strings.HasPrefix(dir, ".."+string(filepath.Separator)) {
+		return opts.OutDir
+	}
+	return filepath.Join(opts.OutDir, dir)
+}
+
+// `mediaDir` returns the directory that the media files of the source file
+// `filename` get copied to: the output directory, or
+// `&lt;outdir>/&lt;basename>` with `-subdir`.
+func mediaDir(filename string, opts Options) string {
+	if opts.SubDir {
+		return filepath.Join(outDir(filename, opts), base(filepath.Base(filename)))
+	}
+	return outDir(filename, opts)
+}
+
+// `copiesMedia` returns true if media files get copied at all. They are not
+// copied with `-nocopy`, or if they would be copied onto themselves.
+func copiesMedia(opts Options) bool {
+	return !opts.NoCopy && (path.Clean(opts.OutDir) != "." || opts.SubDir)
+}
+
+// ### Now the actual conversion
+//
+// `convertFile` takes a file name, reads that file, and converts it.
+// Image paths in a Go file are relative to the file, like `img/x.png` in
+// `docs/foo.go` for `docs/img/x.png`, so the media files are looked up
+// there.
+func convertFile(filename string, opts Options) (media map[string]string, err error) {
+	src, err := fs.ReadFile(opts.files(), filename)
+	if err != nil {
+		return nil, errors.New("Cannot read file " + filename + "\n" + err.Error())
+	}
+	if opts.BaseDir == "" {
+		opts.BaseDir = filepath.Dir(filename)
+	}
+	return convertSource(string(src), filename, opts)
+}
+
+// A lineMap holds the line of the original source for each line of a
+// rewritten source, for the rewrites that add lines. nil maps each line
+// to itself.
+type lineMap []int
+
+// line returns the original line of the line `n` of the rewritten source.
+func (m lineMap) line(n int) int {
+	if n >= 1 && n <= len(m) {
+		return m[n-1]
+	}
+	return n
+}
+
+// then returns the map for the rewrite with the map `next` after the
+// rewrites of `m`.
+func (m lineMap) then(next lineMap) lineMap {
+	if m == nil || next == nil {
+		return append(m, next...)
+	}
+	out := make(lineMap, len(next))
+	for i, n := range next {
+		out[i] = m.line(n)
+	}
+	return out
+}
+
+// `render` converts the source `src` of the file `filename` into the final
+// document: Markdown with front matter, or HTML with `-html`.
+func render(src, filename string, opts Options) (doc string, media map[string]string, err error) {
+	opts = opts.withRun()
+	opts.Name = filename
+	opts.lang = languageOf(filename)
+	if !opts.KeepShebang {
+		src = dropShebang(src, opts.lang)
+	}
+	src = rewriteComments(src, opts.lang, opts.KeepComments)
+	src, lines := rewriteLicense(src, opts.License)
+	if opts.DocFields {
+		src, opts.docFields = rewriteDocFields(src)
+	}
+	if opts.Examples && strings.HasSuffix(filename, "_test.go") {
+		src, err = rewriteExamples(src, filename)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	src, outputLines := rewriteOutputs(src)
+	opts.lineMap = lines.then(outputLines)
+	if opts.OnlyExported && opts.lang.comment == "" {
+		src, err = rewriteExported(src, filename)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	md, media, err := convert(src, opts)
+	if err != nil {
+		return "", nil, errors.New("Error converting " + filename + "\n" + err.Error())
+	}
+	if opts.Section != "" {
+		md, err = extractSection(md, opts.Section, filename, opts)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	if opts.SortSections {
+		md = sortSections(md, opts)
+	}
+	md = reflow(md, opts.Wrap, opts)
+	if opts.HeadingName {
+		opts.run.docNames.set(filename, outDir(filename, opts), firstHeading(md))
+	}
+	md = addFrontMatter(md, filename, opts)
+	md, err = wrap(md, filename, opts)
+	if err != nil {
+		return "", nil, err
+	}
+	if opts.HTML {
+		md = renderHTML(md)
+	}
+	if opts.PostProcess != nil {
+		md, err = opts.PostProcess(md)
+		if err != nil {
+			return "", nil, errors.New("Error post-processing " + filename + "\n" + err.Error())
+		}
+	}
+	return md, media, nil
+}
+
+// `convertSource` converts the source `src` of the file `filename` to
+// Markdown, and writes it to `OutDir/&lt;basename>.md`. With `-html`,
+// the Markdown is rendered to HTML and written to `OutDir/&lt;basename>.html`.
+func convertSource(src, filename string, opts Options) (media map[string]string, err error) {
+	opts = opts.withRun()
+	md, media, err := render(src, filename, opts)
+	if err != nil {
+		return nil, err
+	}
+	outname := outputName(filename, opts)
+	// A misconfiguration, like `-outdir . -output-ext .go`, must not
+	// destroy the source.
+	if samePath(outname, filename) {
+		return nil, errors.New("Refusing to overwrite the input file " + filename + " with its output")
+	}
+	// With `-no-clobber`, an existing output file might contain manual
+	// edits, so leave it alone (and skip its media files, too).
+	out := opts.output()
+	if opts.NoClobber && !opts.Force && out.Exists(outname) {
+		log.Println("[Warning] Not overwriting existing file " + outname)
+		return nil, nil
+	}
+	err = out.WriteFile(outname, []byte(md))
+	if err != nil {
+		return nil, err
+	}
+	opts.run.summary.add(&opts.run.summary.converted)
+	return media, nil
+}
+
+// ### Reading from standard input
+//
+// With `-` as the file name, `gotomarkdown` reads the Go source from
+// standard input. There is no file name to derive the output name from, so
+// the document goes to standard output. With `-stdin-name foo.go`, it is
+// written to `OutDir/foo.md` instead, as if `foo.go` had been converted,
+// and its media files get copied.
+func convertStdin(name string, opts Options) (media map[string]map[string]string, err error) {
+	opts = opts.withRun()
+	src, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return nil, errors.New("Cannot read standard input\n" + err.Error())
+	}
+	if name == "" {
+		doc, _, err := render(string(src), "stdin", opts)
+		if err != nil {
+			return nil, err
+		}
+		_, err = os.Stdout.WriteString(doc)
+		opts.run.summary.add(&opts.run.summary.converted)
+		return nil, err
+	}
+	m, err := convertSource(string(src), name, opts)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]map[string]string{name: m}, nil
+}
+
+// ## Converting many files
+//
+// Shells on Windows do not expand wildcards, and neither does a Unix shell
+// if the pattern is quoted. `expandArgs` therefore expands every argument
+// that contains wildcard characters with `filepath.Glob`. With `-r`,
+// directories get searched for source files (see languages.go). Other
+// arguments are used as they are.
+func expandArgs(args []string, recursive bool) (filenames []string, err error) {
+	for _, arg := range args {
+		if fi, err := os.Stat(arg); recursive && err == nil && fi.IsDir() {
+			found, err := findSources(arg)
+			if err != nil {
+				return nil, err
+			}
+			filenames = append(filenames, found...)
+			continue
+		}
+		if !strings.ContainsAny(arg, "*?[") {
+			filenames = append(filenames, arg)
+			continue
+		}
+		matches, err := filepath.Glob(arg)
+		if err != nil {
+			return nil, errors.New("Invalid pattern " + arg + ": " + err.Error())
+		}
+		if len(matches) == 0 {
+			log.Println("[Warning] No files match " + arg)
+		}
+		filenames = append(filenames, matches...)
+	}
+	return filenames, nil
+}
+
+// `readFileList` reads the paths of the files to convert from the file
+// `name`, for `-files-from`: one path per line, in order. Blank lines and
+// lines starting with `#` are skipped. The paths are relative to the
+// current directory, like the ones on the command line.
+func readFileList(name string) (paths []string, err error) {
+	content, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, errors.New("Cannot read file list " + name + "\n" + err.Error())
+	}
+	for _, line := range strings.Split(strings.Replace(string(content), "\r", "", -1), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		paths = append(paths, line)
+	}
+	return paths, nil
+}
+
+// `convertFiles` converts the files concurrently with up to `Jobs` workers.
+// It returns the media files found, per source file.
+func convertFiles(filenames []string, opts Options) (media map[string]map[string]string, err error) {
+	var (
+		mu sync.Mutex // guards media and err
+		wg sync.WaitGroup
+	)
+	media = map[string]map[string]string{}
+	opts = forRun(filenames, opts.withRun())
+	jobs := opts.Jobs
+	if jobs < 1 {
+		jobs = 1
+	}
+	if opts.HeadingName {
+		opts.run.docNames.start(filenames)
+	}
+	fc := NewFileConverter(osFS{}, opts.output(), opts)
+	queue := make(chan string)
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for filename := range queue {
+				if opts.Verbose {
+					log.Println("Converting", filename)
+				}
+				m, convErr := fc.Convert(filename)
+				if opts.HeadingName {
+					opts.run.docNames.finish(filename)
+				}
+				mu.Lock()
+				if convErr != nil && err == nil {
+					err = convErr
+				}
+				media[filename] = m
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, filename := range filenames {
+		queue <- filename
+	}
+	close(queue)
+	wg.Wait()
+	return media, err
+}
+
+// `forRun` returns `opts` for converting `filenames` in one run, with the
+// files of the run for `-rewrite-go-links`, and their weights for
+// `-auto-weight`. Options that have these already keep them, as when
+// `-watch` converts one of the files again.
+func forRun(filenames []string, opts Options) Options {
+	if opts.GoLinks && opts.runFiles == nil {
+		opts.runFiles = runFileSet(filenames)
+	}
+	if opts.AutoWeight && opts.weights == nil {
+		opts.weights = weightsOf(filenames)
+	}
+	return opts
+}
+
+// `copyMedia` copies the media files of each source file to the output
+// directory, or to &lt;outdir>/&lt;basename> with `-subdir`. Media files that
+// several source files share get copied only once.
+func copyMedia(media map[string]map[string]string, opts Options) error {
+	if !copiesMedia(opts) {
+		return nil
+	}
+	opts = opts.withRun()
+	perDest := map[string]map[string]string{} // media paths per destination directory
+	for filename, files := range media {
+		dest := mediaDir(filename, opts)
+		if perDest[dest] == nil {
+			perDest[dest] = map[string]string{}
+		}
+		for p, target := range files {
+			perDest[dest][p] = target
+		}
+	}
+	for dest, files := range perDest {
+		if opts.Verbose {
+			log.Println("Copying media to", dest)
+		}
+		if opts.SubDir {
+			err := createPath(dest)
+			if err != nil {
+				return errors.New("Cannot create subdir for media files.\n" + err.Error())
+			}
+		}
+		err := copyFiles(dest, files, opts.copier(mediaCopier), opts.run.summary)
+		if err != nil {
+			return errors.New("cp failed:\n" + err.Error())
+		}
+	}
+	return nil
+}
+
+// `copier` returns the copier for the media files: `cp`, unless a
+// MediaResolver provides them.
+func (o Options) copier(cp copier) copier {
+	if o.MediaResolver != nil {
+		return resolverCopier{o.MediaResolver, o.output()}
+	}
+	return cp
+}
+
+// ## main - Where it all starts
+//
+// `setFlags` defines the command line flags, which store their values
+// in `opts`.
+func setFlags(opts *Options) {
+	// In CI, the output directory can come from the environment.
+	outdir := "out"
+	if env := os.Getenv("GOTOMARKDOWN_OUTDIR"); env != "" {
+		outdir = env
+	}
+	flag.StringVar(&opts.OutDir, "outdir", outdir, "Output directory; $GOTOMARKDOWN_OUTDIR, if set, changes the default")
+	flag.BoolVar(&opts.PreservePaths, "preserve-paths", false, "Mirror the directories of the input files below outdir, like pkg/sub/foo.go to <outdir>/pkg/sub/foo.md")
+	flag.BoolVar(&opts.NoCopy, "nocopy", false, "Do not copy media files to outdir")
+	flag.BoolVar(&opts.SubDir, "subdir", false, "Use subdirectory <outdir>/<gofilebasename>/ for media files, ex.: out/gotomarkdown/")
+	flag.BoolVar(&opts.HeadingName, "name-from-heading", false, "Name each output file after the first heading of the document instead of the Go file")
+	flag.StringVar(&opts.Format, "format", "markdown", "Output format: markdown or asciidoc")
+	flag.StringVar(&opts.OutputExt, "output-ext", ".md", "Extension of the output files, like .markdown or .mdx (.html with -html)")
+	flag.BoolVar(&opts.HTML, "html", false, "Render the Markdown to HTML and write <basename>.html instead of <basename>.md")
+	flag.IntVar(&opts.Jobs, "j", 1, "Number of files to convert in parallel")
+	flag.BoolVar(&opts.CopyLinks, "copy-links", false, "Also copy local files that are targets of Markdown links")
+	flag.BoolVar(&opts.EmbedImages, "embed-images", false, "Embed local images into the image tags as data: URIs instead of copying them")
+	flag.StringVar(&opts.BaseURL, "base-url", "", "Prefix for the paths of local images in the image tags, like /docs/assets/")
+	flag.StringVar(&opts.AssetsDir, "assets-dir", "", "Copy images into <outdir>/<assets-dir>/ and rewrite the image tags accordingly")
+	flag.BoolVar(&opts.KeepComments, "keep-go-comments", false, "Keep all comments after the package clause in the code; only the comments above it become prose")
+	flag.BoolVar(&opts.KeepShebang, "keep-shebang", true, "Keep a shebang line like #!/usr/bin/env bash as code; false: drop it")
+	flag.BoolVar(&opts.KeepDirectives, "keep-directives", false, "Emit Go directives like //go:generate as code instead of dropping them")
+	flag.BoolVar(&opts.TrimImports, "trim-imports", false, "Fold each import group into a single import ( ... ) line")
+	flag.BoolVar(&opts.SkipPackage, "skip-package", false, "Omit the package clause and the import declarations from the output")
+	flag.BoolVar(&opts.NoHype, "no-hype", false, "Leave HYPE[...](...) tags as they are instead of inserting the Hype animation")
+	flag.StringVar(&opts.SnippetStart, "snippet-start", defaultSnippetStart, "Marker line that starts the HTML snippet in a Hype file")
+	flag.StringVar(&opts.SnippetEnd, "snippet-end", defaultSnippetEnd, "Marker line that ends the HTML snippet in a Hype file")
+	flag.BoolVar(&opts.NoClobber, "no-clobber", false, "Do not overwrite existing output files")
+	flag.BoolVar(&opts.Force, "force", false, "Overwrite existing output files, even with -no-clobber")
+	flag.StringVar(&opts.CodeStyle, "code-style", "fenced", "How to mark up code blocks: fenced or indented")
+	flag.BoolVar(&opts.Verbose, "v", false, "Log each file as it gets converted")
+	flag.BoolVar(&opts.Strict, "strict", false, "Treat suspicious input, like an unterminated block comment or a missing image file, as an error")
+	flag.StringVar(&opts.FrontMatter, "frontmatter-format", "none", "Generate front matter: hugo-toml, hugo-yaml, jekyll, or none")
+	flag.StringVar(&opts.Title, "title", "", "Title for the front matter (default: the first heading, or the file name)")
+	flag.StringVar(&opts.Date, "date", "", "Date for the front matter (default: the date from -date-source)")
+	flag.StringVar(&opts.DateSource, "date-source", "mtime", "Where the default date comes from: git, mtime, or now")
+	flag.BoolVar(&opts.KeepHardBreaks, "keep-hard-breaks", false, "Keep two trailing spaces that mark a hard line break in prose")
+	flag.BoolVar(&opts.AutoWeight, "auto-weight", false, "Record the position of each file on the command line as the weight in the front matter, for the menus of Hugo")
+	flag.BoolVar(&opts.SourceField, "source-field", false, "Record the path of the Go file in the front matter, or in an HTML comment if there is none")
+	flag.StringVar(&opts.Exclude, "exclude", "", "Drop comment paragraphs whose first line matches this regular expression")
+	flag.BoolVar(&opts.CodeCaption, "code-caption", false, "Put a caption from the nearest heading, or from //gtm:caption, before each code block")
+	flag.StringVar(&opts.CaptionFormat, "caption-format", defaultCaptionFormat, "Template for the captions of -code-caption, with {{.Heading}} and {{.Number}}")
+	flag.BoolVar(&opts.LineNumbers, "line-numbers", false, "Number the lines of each code block")
+	flag.BoolVar(&opts.Examples, "examples", false, "Show Example functions in _test.go files as their body and their output")
+	flag.BoolVar(&opts.StripStars, "strip-stars", false, "Strip a leading * from the lines of /*...*/ comments")
+	flag.IntVar(&opts.MinProseLen, "min-prose-len", 0, "Keep comments within code that have fewer characters than this in the code block")
+	flag.IntVar(&opts.HeadingOffset, "heading-offset", 0, "Increase the level of every heading by this number (up to level 6)")
+	flag.BoolVar(&opts.SortSections, "sort-sections", false, "Sort the sections at the top-level headings alphabetically by their titles")
+	flag.StringVar(&opts.Section, "section", "", "Output only the section with this heading, ignoring case, down to the next heading of the same or a higher level")
+	flag.StringVar(&opts.SectionBreaks, "section-breaks", "", "Separator to insert before each top-level heading but the first, like ---")
+	flag.BoolVar(&opts.Anchors, "anchors", false, "Give each heading an explicit, unique id like {#slug}")
+	flag.BoolVar(&opts.DocFields, "front-matter-from-doc", false, "Turn Key: value lines at the top of the first comment, like Title: or Tags:, into front matter")
+	flag.BoolVar(&opts.RawFrontMatter, "front-matter-passthrough", false, "Pass ---/+++ front matter at the start of the first comment through to the top of the output unchanged")
+	flag.StringVar(&opts.License, "license", "keep", "What to do with a license comment at the top of a file: keep, strip, or collapse")
+	flag.IntVar(&opts.Wrap, "wrap", 0, "Reflow paragraphs of prose to at most this many columns (0: keep the lines)")
+	flag.IntVar(&opts.TabWidth, "tabwidth", 0, "Expand tabs in code to spaces, with tab stops this many columns apart (0: keep tabs)")
+	flag.BoolVar(&opts.OnlyExported, "only-exported", false, "Document only the exported declarations and their doc comments")
+	flag.StringVar(&opts.Admonitions, "admonitions", "", "Turn paragraphs that start with Deprecated:, Warning:, Note:, or TODO: into callouts: blockquote or github")
+	flag.BoolVar(&opts.StrictMarkdown, "strict-markdown", false, "Escape characters in prose that would be taken as Markdown or HTML by accident")
+	flag.StringVar(&opts.DocLinks, "doclinks", "", "Base URL for doc links like [Name], ex.: https://pkg.go.dev/example.com/mypkg")
+	flag.BoolVar(&opts.GoLinks, "rewrite-go-links", false, "Make links to the .go files of this run point to their output files")
+}
+
+// All files get converted first, then the media files are copied in one go.
+// With `-watch`, the files get converted again whenever they change.
+
+func main() {
+	start := time.Now()
+	opts := Options{run: newRunState()}
+	setFlags(&opts)
+	filesFrom := flag.String("files-from", "", "File with the paths of the files to convert, one per line, in order")
+	recursive := flag.Bool("r", false, "Convert the source files in the directories on the command line and below, in all known languages")
+	check := flag.Bool("check", false, "Compare the documents with the existing output files instead of writing them, and fail if any differ")
+	watchFiles := flag.Bool("watch", false, "Keep running and convert the files again whenever they or their media files change (polls the modification times every 250 ms)")
+	manifestFile := flag.String("manifest", "", "Write a JSON manifest of all generated files to this file")
+	stdinName := flag.String("stdin-name", "", "With - as the file name, the name of the Go file to derive the output name from; default: write to stdout")
+	prefixFile := flag.String("prefix", "", "File with text to insert at the top of each document, below any front matter")
+	suffixFile := flag.String("suffix", "", "File with text to append at the bottom of each document")
+	archiveFile := flag.String("archive", "", "Convert the Go files in this zip or tar archive")
+	configFile := flag.String("config", "", "Config file with default settings (default .gotomarkdown.toml or .gotomarkdown.yaml, if present)")
+	flag.Parse()
+	err := loadConfig(flag.CommandLine, *configFile)
+	if err != nil {
+		log.Fatal("[Config Error] " + err.Error())
+	}
+	if !strings.HasPrefix(opts.OutputExt, ".") || len(opts.OutputExt) < 2 {
+		log.Fatal("[Flag Error] -output-ext must start with a dot, like .md, not " + opts.OutputExt)
+	}
+	if opts.CodeStyle != "fenced" && opts.CodeStyle != "indented" {
+		log.Fatal("[Flag Error] -code-style must be fenced or indented, not " + opts.CodeStyle)
+	}
+	if opts.Format != "markdown" && opts.Format != "asciidoc" {
+		log.Fatal("[Flag Error] -format must be markdown or asciidoc, not " + opts.Format)
+	}
+	if opts.Format == "asciidoc" && opts.HTML {
+		log.Fatal("[Flag Error] -html works only with -format markdown")
+	}
+	if opts.Admonitions != "" && !admonitionStyles[opts.Admonitions] {
+		log.Fatal("[Flag Error] -admonitions must be blockquote or github, not " + opts.Admonitions)
+	}
+	if opts.GoLinks && opts.HeadingName {
+		log.Fatal("[Flag Error] -rewrite-go-links does not work with -name-from-heading")
+	}
+	if opts.License != "keep" && opts.License != "strip" && opts.License != "collapse" {
+		log.Fatal("[Flag Error] -license must be keep, strip, or collapse, not " + opts.License)
+	}
+	for _, w := range []struct {
+		name, file string
+		text       *string
+	}{{"prefix", *prefixFile, &opts.Prefix}, {"suffix", *suffixFile, &opts.Suffix}} {
+		if w.file == "" {
+			continue
+		}
+		text, err := ioutil.ReadFile(w.file)
+		if err != nil {
+			log.Fatal("[Flag Error] Cannot read -" + w.name + " file " + w.file + ": " + err.Error())
+		}
+		*w.text = string(text)
+		if _, err := parseWrapper(w.file, *w.text); err != nil {
+			log.Fatal("[Flag Error] " + err.Error())
+		}
+	}
+	if _, err := regexp.Compile(opts.Exclude); err != nil {
+		log.Fatal("[Flag Error] -exclude is not a valid regular expression: " + err.Error())
+	}
+	if opts.DateSource != "git" && opts.DateSource != "mtime" && opts.DateSource != "now" {
+		log.Fatal("[Flag Error] -date-source must be git, mtime, or now, not " + opts.DateSource)
+	}
+	if _, ok := frontMatterFormats[opts.FrontMatter]; !ok && opts.FrontMatter != "none" {
+		log.Fatal("[Flag Error] -frontmatter-format must be hugo-toml, hugo-yaml, jekyll, or none, not " + opts.FrontMatter)
+	}
+	args := flag.Args()
+	if *filesFrom != "" {
+		listed, err := readFileList(*filesFrom)
+		if err != nil {
+			log.Fatal("[Flag Error] " + err.Error())
+		}
+		args = append(args, listed...)
+	}
+	filenames, err := expandArgs(args, *recursive)
+	if err != nil {
+		log.Fatal("[Flag Error] " + err.Error())
+	}
+	var checked *checkOutput
+	if *check {
+		if *watchFiles {
+			log.Fatal("[Flag Error] -check does not work with -watch")
+		}
+		if len(filenames) == 1 && filenames[0] == "-" && *stdinName == "" {
+			log.Fatal("[Flag Error] -check needs -stdin-name to read from standard input")
+		}
+		checked = &checkOutput{}
+		opts.out = checked
+	}
+	var media map[string]map[string]string
+	cleanup := func() {}
+	if *archiveFile != "" {
+		if *watchFiles {
+			log.Fatal("[Flag Error] -watch does not work with -archive")
+		}
+		filenames, media, cleanup, err = convertArchive(*archiveFile, opts)
+		opts.PreservePaths = true
+	} else if len(filenames) == 1 && filenames[0] == "-" {
+		media, err = convertStdin(*stdinName, opts)
+		filenames = nil
+		if *stdinName != "" {
+			filenames = []string{*stdinName}
+		}
+	} else {
+		media, err = convertFiles(filenames, opts)
+	}
+	if err != nil {
+		log.Fatal("[Conversion Error] " + err.Error())
+	}
+	if checked != nil {
+		cleanup()
+		err = checked.result(len(filenames))
+		if err != nil {
+			log.Fatal("[Check Error] " + err.Error())
+		}
+		return
+	}
+	err = copyMedia(media, opts)
+	cleanup()
+	if err != nil {
+		log.Fatal("[CopyMedia Error] " + err.Error())
+	}
+	if *manifestFile != "" {
+		err = writeManifest(*manifestFile, filenames, media, opts)
+		if err != nil {
+			log.Fatal("[Manifest Error] " + err.Error())
+		}
+	}
+	if *watchFiles {
+		watch(filenames, media, opts)
+	}
+	log.Println(opts.run.summary.report(start))
 }
@@ -0,0 +1,1895 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestConvertStream(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    string
+		opts  Options
+		want  string
+		media map[string]string
+	}{
+		{
+			name: "empty",
+			in:   "",
+			want: "",
+		},
+		{
+			name: "prose before code",
+			in:   "// # Title\n//\n// Text.\n\npackage main\n",
+			want: "# Title\n\nText.\n\n```go\npackage main\n```\n",
+		},
+		{
+			name: "prose between code",
+			in:   "package main\n\n// Prose.\nfunc f() {}\n",
+			want: "```go\npackage main\n```\n\nProse.\n\n```go\nfunc f() {}\n```\n",
+		},
+		{
+			name: "trailing comment stays code",
+			in:   "package main\n\nfunc f() {} // trailing\n",
+			want: "```go\npackage main\n\nfunc f() {} // trailing\n```\n",
+		},
+		{
+			name:  "image",
+			in:    "// Text ![img](img.png)\npackage main\n",
+			want:  "Text ![img](img.png)\n\n```go\npackage main\n```\n",
+			media: map[string]string{"img.png": "img.png"},
+		},
+		{
+			name: "carriage returns",
+			in:   "// Text.\r\npackage main\r\n",
+			want: "Text.\n\n```go\npackage main\n```\n",
+		},
+		{
+			name: "indented code",
+			in:   "// Text.\npackage main\n",
+			opts: Options{CodeStyle: "indented"},
+			want: "Text.\n\n    package main\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			media, err := convertStream(context.Background(), strings.NewReader(tt.in), &out, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("got\n%q\nwant\n%q", out.String(), tt.want)
+			}
+			if len(media)+len(tt.media) > 0 && !reflect.DeepEqual(media, tt.media) {
+				t.Errorf("got media %v, want %v", media, tt.media)
+			}
+		})
+	}
+}
+
+func TestConvertStreamMedia(t *testing.T) {
+	in := "// ![b](b.png)\n// ![a](a.png)\npackage main\n"
+	media, err := ConvertStream(context.Background(), strings.NewReader(in), io.Discard, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a.png", "b.png"}; !reflect.DeepEqual(media, want) {
+		t.Errorf("got %v, want %v", media, want)
+	}
+}
+
+// cancelReader cancels its context after the first read.
+type cancelReader struct {
+	r      io.Reader
+	cancel context.CancelFunc
+}
+
+func (c cancelReader) Read(p []byte) (int, error) {
+	defer c.cancel()
+	return c.r.Read(p)
+}
+
+func TestConvertStreamCanceled(t *testing.T) {
+	in := "// Text.\npackage main\n\nfunc f() {}\n"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var out bytes.Buffer
+	_, err := ConvertStream(ctx, strings.NewReader(in), &out, Options{})
+	if err != context.Canceled {
+		t.Errorf("before the first line: got error %v, want %v", err, context.Canceled)
+	}
+	if out.Len() > 0 {
+		t.Errorf("before the first line: got output %q", out.String())
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	media, err := ConvertStream(ctx, cancelReader{strings.NewReader(in), cancel}, io.Discard, Options{})
+	if err != context.Canceled {
+		t.Errorf("during the conversion: got error %v, want %v", err, context.Canceled)
+	}
+	if media != nil {
+		t.Errorf("during the conversion: got media %v", media)
+	}
+}
+
+func TestLineMap(t *testing.T) {
+	outputs := lineMap{1, 2, 2, 2, 3}
+	tests := []struct {
+		name  string
+		m     lineMap
+		lines []int // the lines of the result for the lines 1 to 6
+	}{
+		{"nil", nil, []int{1, 2, 3, 4, 5, 6}},
+		{"one rewrite", outputs, []int{1, 2, 2, 2, 3, 6}},
+		{"nil then a rewrite", lineMap(nil).then(outputs), []int{1, 2, 2, 2, 3, 6}},
+		{"a rewrite then nil", outputs.then(nil), []int{1, 2, 2, 2, 3, 6}},
+		{"two rewrites", lineMap{1, 3, 4}.then(outputs), []int{1, 3, 3, 3, 4, 6}},
+	}
+	for _, tt := range tests {
+		var got []int
+		for n := 1; n <= 6; n++ {
+			got = append(got, tt.m.line(n))
+		}
+		if !reflect.DeepEqual(got, tt.lines) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.lines)
+		}
+	}
+}
+
+func TestCommentFinder(t *testing.T) {
+	tests := []struct {
+		name      string
+		lines     []string
+		inComment []bool // for each line
+		inSection []bool // after each line
+	}{
+		{
+			name:      "one-line section",
+			lines:     []string{"/* note */", "x := 1"},
+			inComment: []bool{true, false},
+			inSection: []bool{false, false},
+		},
+		{
+			name:      "/* within a section",
+			lines:     []string{"/*", "see /* this", "*/", "x := 1"},
+			inComment: []bool{true, true, true, false},
+			inSection: []bool{true, true, false, false},
+		},
+		{
+			name:      "two /* before the */",
+			lines:     []string{"/* a", "/* b", "*/", "*/"},
+			inComment: []bool{true, true, true, false},
+			inSection: []bool{true, true, false, false},
+		},
+		{
+			name:      "/* within a one-line section",
+			lines:     []string{"/* see /* this */ */"},
+			inComment: []bool{true},
+			inSection: []bool{false},
+		},
+		{
+			name:      "trailing comment",
+			lines:     []string{"x := 1 /* note", "*/"},
+			inComment: []bool{false, false},
+			inSection: []bool{false, false},
+		},
+	}
+	for _, tt := range tests {
+		isInComment, inSection := commentFinder()
+		for i, line := range tt.lines {
+			if got := isInComment(line); got != tt.inComment[i] {
+				t.Errorf("%s: line %q: got in comment %v, want %v", tt.name, line, got, tt.inComment[i])
+			}
+			if got := inSection(); got != tt.inSection[i] {
+				t.Errorf("%s: after line %q: got in section %v, want %v", tt.name, line, got, tt.inSection[i])
+			}
+		}
+	}
+}
+
+func TestConvertNestedBlockComment(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{
+			// The /* is text.
+			name: "/* within a section",
+			in:   "/*\nsee /* this\n*/\npackage p\n",
+			want: "\nsee /* this\n\n\n```go\npackage p\n```\n",
+		},
+		{
+			// The first */ ends the section, and the second one is code.
+			name: "/* within a one-line section",
+			in:   "/* see /* this */ */\npackage p\n",
+			want: "see /* this\n\n```go\n*/\npackage p\n```\n",
+		},
+	}
+	for _, tt := range tests {
+		got, _, err := convert(tt.in, Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: got\n%q\nwant\n%q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestConvertOneLineBlockComment(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{
+			name: "comment only",
+			in:   "package p\n\n/* c */\nfunc f() {}\n",
+			want: "```go\npackage p\n```\n\nc\n\n```go\nfunc f() {}\n```\n",
+		},
+		{
+			// The code after the comment is code.
+			name: "comment, then code",
+			in:   "package p\n\n/* c */ var x = 1\nfunc f() {}\n",
+			want: "```go\npackage p\n```\n\nc\n\n```go\nvar x = 1\nfunc f() {}\n```\n",
+		},
+		{
+			// A trailing comment stays in the code.
+			name: "code, then comment",
+			in:   "package p\n\nvar x = 1 /* c */\nfunc f() {}\n",
+			want: "```go\npackage p\n\nvar x = 1 /* c */\nfunc f() {}\n```\n",
+		},
+	}
+	for _, tt := range tests {
+		got, _, err := convert(tt.in, Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: got\n%q\nwant\n%q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// largeSource returns a synthetic Go file with `n` functions, each with a
+// doc comment of some prose.
+func largeSource(n int) string {
+	var b strings.Builder
+	b.WriteString("// # A large file\n//\n// It has many functions.\n\npackage large\n")
+	for i := 0; i < n; i++ {
+		b.WriteString("\n// The next function does *something*, see [the docs](docs.md)\n// and `f(x)`, and then some more, to fill the line.\n")
+		b.WriteString("func f() int {\n\tx := 1\n\treturn x + 1 // trailing comment\n}\n")
+	}
+	return b.String()
+}
+
+func BenchmarkConvert(b *testing.B) {
+	src := largeSource(20000)
+	b.SetBytes(int64(len(src)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := convert(src, Options{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestExtractMediaPath(t *testing.T) {
+	tests := []struct {
+		line, want string
+	}{
+		{`// ![Alt text](gotomarkdown_animation.gif)`, "gotomarkdown_animation.gif"},
+		{`// ![Alt text](gotomarkdown_animation.gif "Title")`, "gotomarkdown_animation.gif"},
+		{`// ![Alt text](gotomarkdown image.jpg)`, "gotomarkdown image.jpg"},
+		{`// ![Alt text](gotomarkdown image.jpg "Title")`, "gotomarkdown image.jpg"},
+		{`// ![Alt text](<gotomarkdown image.jpg> "Title")`, "gotomarkdown image.jpg"},
+		{`// ![Alt text]( spaced.png )`, "spaced.png"},
+		{`// ![Alt text](img\win.png)`, "img/win.png"},
+		{`// ![Alt text](https://example.com/a.png)`, ""},
+		{"// `![Alt text](code.png)`", ""},
+		{`// no image`, ""},
+	}
+	for _, tt := range tests {
+		got, err := extractMediaPath(tt.line)
+		if err != nil {
+			t.Errorf("%s: %v", tt.line, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestMalformedImageTag(t *testing.T) {
+	for _, tag := range []string{`![Alt text]()`, `![Alt text]("Title")`} {
+		src := "// Text.\n//\n// " + tag + "\npackage p\n"
+		_, _, err := render(src, "doc.go", Options{})
+		if err == nil || !strings.Contains(err.Error(), "doc.go:3") {
+			t.Errorf("%s: got error %v, want one at doc.go:3", tag, err)
+		}
+	}
+}
+
+func TestRewriteImagePath(t *testing.T) {
+	tests := []struct {
+		line, want string
+	}{
+		{`// ![Alt text](a.gif)`, `// ![Alt text](assets/new name.png)`},
+		{`// ![Alt text](a.gif "Title")`, `// ![Alt text](assets/new name.png "Title")`},
+		{`// ![Alt text](my image.jpg  "Title")`, `// ![Alt text](assets/new name.png  "Title")`},
+		{`// ![Alt text](<my image.jpg> "Title")`, `// ![Alt text](<assets/new name.png> "Title")`},
+		{`// no image`, `// no image`},
+	}
+	for _, tt := range tests {
+		if got := rewriteImagePath(tt.line, "assets/new name.png"); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+// recordingCopier records the copies instead of making them.
+type recordingCopier map[string]string
+
+func (r recordingCopier) copy(src, dest string) error {
+	r[src] = dest
+	return nil
+}
+
+func TestCopyFilesOutside(t *testing.T) {
+	for _, target := range []string{"../../etc/x.png", "..\\..\\etc\\x.png", "img/../../x.png", ".."} {
+		cp := recordingCopier{}
+		err := copyFiles("out", map[string]string{"x.png": target}, cp, &runSummary{})
+		if err == nil {
+			t.Errorf("%s: got no error", target)
+		}
+		if len(cp) > 0 {
+			t.Errorf("%s: got copies %v", target, cp)
+		}
+	}
+	cp := recordingCopier{}
+	if err := copyFiles("out", map[string]string{"img/x.png": "img/../x.png"}, cp, &runSummary{}); err != nil {
+		t.Fatal(err)
+	}
+	if want := (recordingCopier{"img/x.png": "out/x.png"}); !reflect.DeepEqual(cp, want) {
+		t.Errorf("got %v, want %v", cp, want)
+	}
+}
+
+func TestCopyImagesWithSpaces(t *testing.T) {
+	src := fstest.MapFS{
+		"doc.go":                 {Data: []byte("// ![a](gotomarkdown image.jpg)\n// ![b](<my image.png> \"Title\")\npackage doc\n")},
+		"gotomarkdown image.jpg": {Data: []byte("jpg")},
+		"my image.png":           {Data: []byte("png")},
+	}
+	out := MemOutput{}
+	fc := NewFileConverter(src, out, Options{OutDir: "out"})
+	media, err := fc.Convert("doc.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fc.CopyMedia("doc.go", media); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"out/gotomarkdown image.jpg": "jpg", "out/my image.png": "png"} {
+		if got := string(out[name]); got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestHeadingNamesOrder(t *testing.T) {
+	files := []string{"a.go", "b.go", "c.go", "d.go"}
+	h := newHeadingNames()
+	h.start(files)
+	// The files claim their names in reverse order. c.go has no heading,
+	// and b.go fails before it gets to its name.
+	var wg sync.WaitGroup
+	for i := len(files) - 1; i >= 0; i-- {
+		wg.Add(1)
+		go func(f string) {
+			defer wg.Done()
+			switch f {
+			case "b.go":
+				h.finish(f)
+			case "c.go":
+				h.set(f, "out", "")
+			default:
+				h.set(f, "out", "Same Heading")
+			}
+		}(files[i])
+		time.Sleep(time.Millisecond)
+	}
+	wg.Wait()
+	for f, want := range map[string]string{"a.go": "same-heading", "d.go": "same-heading-1"} {
+		if got, _ := h.get(f); got != want {
+			t.Errorf("%s: got %q, want %q", f, got, want)
+		}
+	}
+	for _, f := range []string{"b.go", "c.go"} {
+		if got, ok := h.get(f); ok {
+			t.Errorf("%s: got %q, want no name", f, got)
+		}
+	}
+}
+
+func TestFenceDirective(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+		opts           Options
+	}{
+		{
+			name: "once",
+			in:   "// Text.\n//gtm:fence go {hl_lines=[1]}\nfunc f() {}\n\n// More.\nfunc g() {}\n",
+			want: "Text.\n\n```go {hl_lines=[1]}\nfunc f() {}\n```\n\nMore.\n\n```go\nfunc g() {}\n```\n",
+		},
+		{
+			name: "within code",
+			in:   "func f() {}\n//gtm:fence text\n\nfunc g() {}\n// More.\nfunc h() {}\n",
+			want: "```go\nfunc f() {}\n```\n\n```text\nfunc g() {}\n```\n\nMore.\n\n```go\nfunc h() {}\n```\n",
+		},
+		{
+			name: "within code, before a comment",
+			in:   "func f() {}\n//gtm:fence text\n// More.\nfunc g() {}\n",
+			want: "```go\nfunc f() {}\n```\n\nMore.\n\n```text\nfunc g() {}\n```\n",
+		},
+		{
+			name: "within indented code",
+			in:   "func f() {}\n//gtm:fence text\nfunc g() {}\n",
+			opts: Options{CodeStyle: "indented"},
+			want: "    func f() {}\n    func g() {}\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := convert(tt.in, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHideDirective(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+		strict, err    bool
+	}{
+		{
+			name: "prose and code",
+			in:   "// Intro.\n//gtm:hide\n// Secret.\nfunc secret() {}\n//gtm:show\n\n// Visible.\nfunc f() {}\n",
+			want: "Intro.\n\nVisible.\n\n```go\nfunc f() {}\n```\n",
+		},
+		{
+			name: "within code",
+			in:   "func f() {}\n//gtm:hide\nfunc g() {}\n//gtm:show\nfunc h() {}\n",
+			want: "```go\nfunc f() {}\nfunc h() {}\n```\n",
+		},
+		{
+			name: "unclosed",
+			in:   "// Intro.\n//gtm:hide\nfunc g() {}\n",
+			want: "Intro.\n",
+		},
+		{
+			name:   "unclosed, strict",
+			in:     "// Intro.\n//gtm:hide\nfunc g() {}\n",
+			strict: true,
+			err:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := convert(tt.in, Options{Strict: tt.strict})
+			if tt.err {
+				if err == nil {
+					t.Errorf("got no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSkipPackage(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{
+			name: "single import",
+			in:   "// Intro.\n\npackage p\n\nimport \"fmt\"\n\n// Doc.\nfunc f() {}\n",
+			want: "Intro.\n\nDoc.\n\n```go\nfunc f() {}\n```\n",
+		},
+		{
+			name: "grouped imports",
+			in:   "// Intro.\n\npackage p\n\nimport (\n\t\"fmt\"\n\n\t// os is for Exit.\n\t\"os\"\n)\n\n// Doc.\nfunc f() {}\n",
+			want: "Intro.\n\nDoc.\n\n```go\nfunc f() {}\n```\n",
+		},
+		{
+			name: "no blank lines",
+			in:   "// Intro.\npackage p\nimport \"fmt\"\n// Doc.\nfunc f() {}\n",
+			want: "Intro.\n\nDoc.\n\n```go\nfunc f() {}\n```\n",
+		},
+		{
+			name: "code first",
+			in:   "package p\n\nimport \"fmt\"\n\nfunc f() {}\n",
+			want: "```go\nfunc f() {}\n```\n",
+		},
+		{
+			name: "within code",
+			in:   "// Intro.\n\nvar x = 1\n\nimport \"fmt\"\n\nfunc f() {}\n",
+			want: "Intro.\n\n```go\nvar x = 1\n\nfunc f() {}\n```\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := convert(tt.in, Options{SkipPackage: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrimImports(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{
+			name: "grouped imports",
+			in:   "package p\n\nimport (\n\t\"fmt\"\n\t// os (for Exit)\n\t\"os\"\n)\n\nfunc f() {}\n",
+			want: "```go\npackage p\n\nimport ( ... )\n\nfunc f() {}\n```\n",
+		},
+		{
+			name: "single import",
+			in:   "package p\n\nimport \"fmt\"\n\nfunc f() {}\n",
+			want: "```go\npackage p\n\nimport \"fmt\"\n\nfunc f() {}\n```\n",
+		},
+		{
+			name: "group on one line",
+			in:   "package p\n\nimport (\"fmt\"; \"os\")\n",
+			want: "```go\npackage p\n\nimport (\"fmt\"; \"os\")\n```\n",
+		},
+		{
+			name: "group, then a single import",
+			in:   "package p\n\nimport (\n\t\"fmt\"\n)\nimport \"os\"\n\nfunc f() { g(1) }\n",
+			want: "```go\npackage p\n\nimport ( ... )\nimport \"os\"\n\nfunc f() { g(1) }\n```\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := convert(tt.in, Options{TrimImports: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertFilesParallel(t *testing.T) {
+	dir := t.TempDir()
+	var files []string
+	for i := 0; i < 50; i++ {
+		f := filepath.Join(dir, "f"+strconv.Itoa(i)+".go")
+		if err := os.WriteFile(f, []byte("// File "+strconv.Itoa(i)+".\npackage p\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, f)
+	}
+	out := filepath.Join(dir, "out")
+	if _, err := convertFiles(files, Options{OutDir: out, Jobs: 8}); err != nil {
+		t.Fatal(err)
+	}
+	for i := range files {
+		name := filepath.Join(out, "f"+strconv.Itoa(i)+".md")
+		got, err := os.ReadFile(name)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if want := "File " + strconv.Itoa(i) + ".\n\n```go\npackage p\n```\n"; string(got) != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestHTMLImages(t *testing.T) {
+	tests := []struct {
+		name, in string
+		media    map[string]string
+	}{
+		{
+			name:  "double quotes",
+			in:    "// <img src=\"a.png\" width=\"50%\">\npackage p\n",
+			media: map[string]string{"a.png": "a.png"},
+		},
+		{
+			name:  "single quotes",
+			in:    "// <img alt='x' src='a.png'>\npackage p\n",
+			media: map[string]string{"a.png": "a.png"},
+		},
+		{
+			name: "remote",
+			in:   "// <img src=\"https://example.com/a.png\">\npackage p\n",
+		},
+		{
+			name:  "in a code span",
+			in:    "// Write `<img src=\"code.png\">`, as in <img src=\"a.png\">.\npackage p\n",
+			media: map[string]string{"a.png": "a.png"},
+		},
+	}
+	for _, tt := range tests {
+		_, media, err := convert(tt.in, Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(media)+len(tt.media) > 0 && !reflect.DeepEqual(media, tt.media) {
+			t.Errorf("%s: got media %v, want %v", tt.name, media, tt.media)
+		}
+	}
+}
+
+// TestHTMLImagesLongLine converts a comment line with many HTML image
+// tags and code spans. Counting the backticks before each tag would take
+// quadratic time.
+func TestHTMLImagesLongLine(t *testing.T) {
+	line := "// " + strings.Repeat("`x` <img src=\"https://example.com/a.png\"> ", 1<<17) + "\npackage p\n"
+	done := make(chan error)
+	go func() {
+		_, _, err := convert(line, Options{})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the conversion did not finish within 10 seconds")
+	}
+}
+
+func TestCopyLinks(t *testing.T) {
+	src := fstest.MapFS{
+		"doc.go":   {Data: []byte("// See the [spec](spec.pdf), the [site](https://example.com/spec.pdf), and the [draft](draft.pdf).\npackage doc\n")},
+		"spec.pdf": {Data: []byte("pdf")},
+	}
+	tests := []struct {
+		name string
+		opts Options
+		want map[string]string // media file -> target
+	}{
+		{"copy links", Options{OutDir: "out", CopyLinks: true}, map[string]string{"spec.pdf": "spec.pdf"}},
+		{"default", Options{OutDir: "out"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := MemOutput{}
+			fc := NewFileConverter(src, out, tt.opts)
+			media, err := fc.Convert("doc.go")
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The remote link and the link to a missing file are no media.
+			if len(media)+len(tt.want) > 0 && !reflect.DeepEqual(media, tt.want) {
+				t.Errorf("got media %v, want %v", media, tt.want)
+			}
+			if err := fc.CopyMedia("doc.go", media); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := out.Exists("out/spec.pdf"), tt.want != nil; got != want {
+				t.Errorf("out/spec.pdf exists: got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestHeadingOffset(t *testing.T) {
+	const in = "// # Title\n//\n// ###### Deep\n//\n// Use `# not a heading`.\n//\n// ```\n// # in a fence\n// ```\npackage p\n"
+	tests := []struct {
+		offset int
+		want   string
+	}{
+		{0, "# Title\n\n###### Deep\n\nUse `# not a heading`.\n\n```\n# in a fence\n```\n\n```go\npackage p\n```\n"},
+		{1, "## Title\n\n###### Deep\n\nUse `# not a heading`.\n\n```\n# in a fence\n```\n\n```go\npackage p\n```\n"},
+		{2, "### Title\n\n###### Deep\n\nUse `# not a heading`.\n\n```\n# in a fence\n```\n\n```go\npackage p\n```\n"},
+	}
+	for _, tt := range tests {
+		got, _, err := convert(in, Options{HeadingOffset: tt.offset})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("offset %d: got\n%q\nwant\n%q", tt.offset, got, tt.want)
+		}
+	}
+}
+
+func TestBuildConstraints(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{
+			name: "go:build",
+			in:   "//go:build linux\n\n// Package p.\npackage p\n",
+			want: "Package p.\n\n```go\npackage p\n```\n",
+		},
+		{
+			name: "+build",
+			in:   "// +build linux,amd64\n\n// Package p.\npackage p\n",
+			want: "Package p.\n\n```go\npackage p\n```\n",
+		},
+		{
+			name: "both",
+			in:   "//go:build linux && amd64\n// +build linux,amd64\n\n// Package p.\npackage p\n",
+			want: "Package p.\n\n```go\npackage p\n```\n",
+		},
+		{
+			name: "+build in prose",
+			in:   "// +build tags are the old form.\n// They still work.\npackage p\n",
+			want: "+build tags are the old form.\nThey still work.\n\n```go\npackage p\n```\n",
+		},
+		{
+			name: "+build after the package clause",
+			in:   "package p\n\n// +build linux\n\nfunc f() {}\n",
+			want: "```go\npackage p\n```\n\n+build linux\n\n```go\nfunc f() {}\n```\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := convert(tt.in, Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeepDirectives(t *testing.T) {
+	tests := []struct {
+		name, in string
+		keep     bool
+		want     string
+	}{
+		{
+			name: "after prose",
+			in:   "// Generate the tables.\n//go:generate go run gen.go\npackage p\n",
+			want: "Generate the tables.\n\n```go\npackage p\n```\n",
+		},
+		{
+			name: "after prose, kept",
+			in:   "// Generate the tables.\n//go:generate go run gen.go\npackage p\n",
+			keep: true,
+			want: "Generate the tables.\n\n```go\n//go:generate go run gen.go\npackage p\n```\n",
+		},
+		{
+			name: "within code",
+			in:   "package p\n\n//go:generate go run gen.go\nfunc f() {}\n",
+			want: "```go\npackage p\n\nfunc f() {}\n```\n",
+		},
+		{
+			name: "within code, kept",
+			in:   "package p\n\n//go:generate go run gen.go\nfunc f() {}\n",
+			keep: true,
+			want: "```go\npackage p\n\n//go:generate go run gen.go\nfunc f() {}\n```\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := convert(tt.in, Options{KeepDirectives: tt.keep})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBlankLinesBeforeCode(t *testing.T) {
+	const want = "Text.\n\n```go\nfunc f() {}\n```\n"
+	for n := 0; n <= 3; n++ {
+		in := "// Text.\n" + strings.Repeat("\n", n) + "func f() {}\n"
+		got, _, err := convert(in, Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("%d blank lines: got\n%q\nwant\n%q", n, got, want)
+		}
+	}
+}
+
+func TestGetHTMLSnippet(t *testing.T) {
+	fsys := fstest.MapFS{
+		"hype.html":   {Data: []byte("<head>\n\t<!-- copy these lines to your document head: -->\n\t<meta>\n\t<!-- end copy -->\n</head>\n<body>\n\t<!-- copy these lines to your document: -->\n\t<div>\n\t</div>\n\t<!-- end copy -->\n</body>\n")},
+		"custom.html": {Data: []byte("<!-- end snippet -->\n<!-- begin snippet -->\n<div>\n<!-- end copy -->\n</div>\n<!-- end snippet -->\n<p>\n")},
+	}
+	tests := []struct {
+		name, path, start, end, want string
+	}{
+		{
+			name:  "Hype markers",
+			path:  "hype.html",
+			start: defaultSnippetStart,
+			end:   defaultSnippetEnd,
+			want:  "<div>\n</div>\n\n",
+		},
+		{
+			// The end marker before the start marker ends nothing, and
+			// Hype's end marker within the snippet is part of it.
+			name:  "custom markers",
+			path:  "custom.html",
+			start: "<!-- begin snippet -->",
+			end:   "<!-- end snippet -->",
+			want:  "<div>\n<!-- end copy -->\n</div>\n\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := getHTMLSnippet(fsys, tt.path, tt.start, tt.end)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHypeSnippetOptions(t *testing.T) {
+	src := fstest.MapFS{
+		"doc.go":                         {Data: []byte("// See HYPE[animation](custom.html).\npackage doc\n")},
+		"custom.html":                    {Data: []byte("<!-- begin snippet -->\n<div></div>\n<!-- end snippet -->\n")},
+		"custom.hyperesources/script.js": {Data: []byte("js")},
+	}
+	out := MemOutput{}
+	fc := NewFileConverter(src, out, Options{OutDir: "out", SnippetStart: "<!-- begin snippet -->", SnippetEnd: "<!-- end snippet -->"})
+	media, err := fc.Convert("doc.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"custom.hyperesources": "custom.hyperesources"}; !reflect.DeepEqual(media, want) {
+		t.Errorf("got media %v, want %v", media, want)
+	}
+	if got := string(out["out/doc.md"]); !strings.Contains(got, "<div></div>\n") {
+		t.Errorf("got\n%q\nwant the snippet", got)
+	}
+}
+
+func TestReplaceHypeTags(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.html": {Data: []byte(defaultSnippetStart + "\n<div id=\"a\"></div>\n" + defaultSnippetEnd + "\n")},
+		"b.html": {Data: []byte(defaultSnippetStart + "\n<div id=\"b\"></div>\n" + defaultSnippetEnd + "\n")},
+	}
+	const noscript = "<noscript><em>Please enable JavaScript to view the animation.</em></noscript>\n"
+	got, err := replaceHypeTags(fsys, "// First HYPE[a](a.html), then HYPE[b](b.html).", "", "", ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []hypeSnippet{
+		{html: "<div id=\"a\"></div>\n\n" + noscript, resources: "a.hyperesources"},
+		{html: "<div id=\"b\"></div>\n\n" + noscript, resources: "b.hyperesources"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// The conversion splices in both snippets and copies both
+	// hyperesources directories.
+	fsys["doc.go"] = &fstest.MapFile{Data: []byte("// First HYPE[a](a.html), then HYPE[b](b.html).\npackage doc\n")}
+	fsys["a.hyperesources/a.js"] = &fstest.MapFile{Data: []byte("a")}
+	fsys["b.hyperesources/b.js"] = &fstest.MapFile{Data: []byte("b")}
+	out := MemOutput{}
+	fc := NewFileConverter(fsys, out, Options{OutDir: "out"})
+	media, err := fc.Convert("doc.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"a.hyperesources": "a.hyperesources", "b.hyperesources": "b.hyperesources"}; !reflect.DeepEqual(media, want) {
+		t.Errorf("got media %v, want %v", media, want)
+	}
+	if got, want := string(out["out/doc.md"]), want[0].html+want[1].html; !strings.HasPrefix(got, want) {
+		t.Errorf("got\n%q\nwant prefix\n%q", got, want)
+	}
+}
+
+func TestHypeWithoutMarkers(t *testing.T) {
+	src := fstest.MapFS{
+		"doc.go":          {Data: []byte("// Intro.\n//\n// HYPE[animation](anim/plain.html)\npackage doc\n")},
+		"anim/plain.html": {Data: []byte("<html>\n<body>\n<div></div>\n<!-- end copy -->\n</body>\n</html>\n")},
+	}
+	fc := NewFileConverter(src, MemOutput{}, Options{OutDir: "out"})
+	_, err := fc.Convert("doc.go")
+	if err == nil {
+		t.Fatal("got no error")
+	}
+	// The error names the file, the line of the tag, and the Hype file.
+	for _, want := range []string{"doc.go:3", "anim/plain.html", "No HTML snippet found"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("got error %q, want it to contain %q", err, want)
+		}
+	}
+}
+
+func TestCodeStyles(t *testing.T) {
+	const in = "// Text.\npackage main\n\nfunc f() {\n\n\tg()\n}\n\n// More.\nfunc h() {}\n"
+	tests := []struct {
+		style, want string
+	}{
+		{"", "Text.\n\n```go\npackage main\n\nfunc f() {\n\n\tg()\n}\n```\n\nMore.\n\n```go\nfunc h() {}\n```\n"},
+		{"fenced", "Text.\n\n```go\npackage main\n\nfunc f() {\n\n\tg()\n}\n```\n\nMore.\n\n```go\nfunc h() {}\n```\n"},
+		// Blank lines within the code stay blank.
+		{"indented", "Text.\n\n    package main\n\n    func f() {\n\n    \tg()\n    }\n\nMore.\n\n    func h() {}\n"},
+	}
+	for _, tt := range tests {
+		got, _, err := convert(in, Options{CodeStyle: tt.style})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("code style %q: got\n%q\nwant\n%q", tt.style, got, tt.want)
+		}
+	}
+}
+
+// captureLog collects the log output of the test `t`.
+func captureLog(t *testing.T) *bytes.Buffer {
+	var b bytes.Buffer
+	log.SetOutput(&b)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &b
+}
+
+func TestUnterminatedBlockComment(t *testing.T) {
+	const in = "package p\n\n/* Text.\n\nfunc f() {}\n"
+	logged := captureLog(t)
+	got, _, err := convert(in, Options{Name: "p.go"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "```go\npackage p\n```\n\nText.\n\nfunc f() {}\n"; !strings.HasPrefix(got, want) {
+		t.Errorf("got\n%q\nwant prefix\n%q", got, want)
+	}
+	const msg = "[Warning] Unterminated block comment starting at p.go:3"
+	if !strings.Contains(logged.String(), msg) {
+		t.Errorf("got log %q, want %q", logged, msg)
+	}
+
+	_, _, err = convert(in, Options{Name: "p.go", Strict: true})
+	if err == nil || err.Error() != "Unterminated block comment starting at p.go:3" {
+		t.Errorf("strict: got error %v", err)
+	}
+}
+
+func TestTrailingWhitespace(t *testing.T) {
+	// Code lines lose their trailing whitespace, too.
+	const in = "// Trailing spaces. \t\n//\t\n// Hard break.  \n// Next line.\npackage p\t\n"
+	tests := []struct {
+		keep bool
+		want string
+	}{
+		{false, "Trailing spaces.\n\nHard break.\nNext line.\n\n```go\npackage p\n```\n"},
+		{true, "Trailing spaces.\n\nHard break.  \nNext line.\n\n```go\npackage p\n```\n"},
+	}
+	for _, tt := range tests {
+		got, _, err := convert(in, Options{KeepHardBreaks: tt.keep})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("keep hard breaks %v: got\n%q\nwant\n%q", tt.keep, got, tt.want)
+		}
+	}
+}
+
+func TestReferenceImages(t *testing.T) {
+	src := fstest.MapFS{
+		"after.go":     {Data: []byte("// ![Logo][logo]\n//\n// [logo]: img/logo.png \"Logo\"\npackage p\n")},
+		"before.go":    {Data: []byte("// [logo]: <img/logo.png>\n//\n// ![Logo][logo] and ![Logo][]\npackage p\n")},
+		"unused.go":    {Data: []byte("// [logo]: img/logo.png\npackage p\n")},
+		"img/logo.png": {Data: []byte("png")},
+	}
+	tests := []struct {
+		filename string
+		media    map[string]string
+	}{
+		{"after.go", map[string]string{"img/logo.png": "img/logo.png"}},
+		{"before.go", map[string]string{"img/logo.png": "img/logo.png"}},
+		// A definition that no image uses may be a link.
+		{"unused.go", nil},
+	}
+	for _, tt := range tests {
+		out := MemOutput{}
+		fc := NewFileConverter(src, out, Options{OutDir: "out"})
+		media, err := fc.Convert(tt.filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(media)+len(tt.media) > 0 && !reflect.DeepEqual(media, tt.media) {
+			t.Errorf("%s: got media %v, want %v", tt.filename, media, tt.media)
+		}
+		if err := fc.CopyMedia(tt.filename, media); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := out.Exists("out/img/logo.png"), tt.media != nil; got != want {
+			t.Errorf("%s: out/img/logo.png exists: got %v, want %v", tt.filename, got, want)
+		}
+	}
+}
+
+func TestCommentParagraphs(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{
+			name: "line comments",
+			in:   "// One.\n//\n// Two.\n//\n// Three.\npackage p\n",
+			want: "One.\n\nTwo.\n\nThree.\n\n```go\npackage p\n```\n",
+		},
+		{
+			name: "line comments after code",
+			in:   "package p\n\n// F does.\n//\n// More.\nfunc F() {}\n",
+			want: "```go\npackage p\n```\n\nF does.\n\nMore.\n\n```go\nfunc F() {}\n```\n",
+		},
+		{
+			name: "block comment",
+			in:   "package p\n\n/* F does.\n\nMore. */\nfunc F() {}\n",
+			want: "```go\npackage p\n```\n\nF does.\n\nMore.\n\n```go\nfunc F() {}\n```\n",
+		},
+	}
+	for _, tt := range tests {
+		got, _, err := convert(tt.in, Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: got\n%q\nwant\n%q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExpandArgs(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.go", "b.go", "c.txt", "sub/d.go"} {
+		f := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(f), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(f, []byte("package p\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{"glob", []string{"*.go"}, []string{"a.go", "b.go"}},
+		{"character class", []string{"[ac].*"}, []string{"a.go", "c.txt"}},
+		{"glob in a directory", []string{"sub/*.go"}, []string{filepath.Join("sub", "d.go")}},
+		// Arguments without wildcards stay, even if there is no such file.
+		{"no wildcards", []string{"c.txt", "missing.go"}, []string{"c.txt", "missing.go"}},
+		{"no match", []string{"*.md"}, nil},
+	}
+	t.Chdir(dir)
+	captureLog(t) // the warning about no match
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := expandArgs(tt.args, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+	if _, err := expandArgs([]string{"[a"}, false); err == nil {
+		t.Error("[a: got no error")
+	}
+}
+
+func TestReadFileList(t *testing.T) {
+	t.Chdir(t.TempDir())
+	for _, name := range []string{"a.go", "b.go", "c.go"} {
+		if err := os.WriteFile(name, []byte("package p\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	const list = "# The chapters, in order\nc.go\n\n  a.go  \r\n# b.go is a draft\n[b].go\n"
+	if err := os.WriteFile("list.txt", []byte(list), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readFileList("list.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"c.go", "a.go", "[b].go"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	// The paths may contain wildcards, like those on the command line.
+	got, err = expandArgs(got, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"c.go", "a.go", "b.go"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if _, err := readFileList("missing.txt"); err == nil {
+		t.Error("missing.txt: got no error")
+	}
+}
+
+func TestExclude(t *testing.T) {
+	const in = "// Intro.\n//\n// TODO: fix this\n// before the release.\n//\n// Keep this.\npackage p\n\n// TODO: internal note.\nfunc f() {} // TODO: stays in the code\n"
+	tests := []struct {
+		exclude, want string
+	}{
+		{"", "Intro.\n\nTODO: fix this\nbefore the release.\n\nKeep this.\n\n```go\npackage p\n```\n\nTODO: internal note.\n\n```go\nfunc f() {} // TODO: stays in the code\n```\n"},
+		{"^TODO:", "Intro.\n\nKeep this.\n\n```go\npackage p\n\nfunc f() {} // TODO: stays in the code\n```\n"},
+	}
+	for _, tt := range tests {
+		got, _, err := convert(in, Options{Exclude: tt.exclude})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("exclude %q: got\n%q\nwant\n%q", tt.exclude, got, tt.want)
+		}
+	}
+	if _, _, err := convert(in, Options{Exclude: "("}); err == nil {
+		t.Error("invalid pattern: got no error")
+	}
+}
+
+func TestBacktickFences(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{
+			name: "backticks in prose",
+			in:   "// Use ``` to fence code.\nfunc f() {}\n",
+			want: "Use ``` to fence code.\n\n```go\nfunc f() {}\n```\n",
+		},
+		{
+			name: "unterminated fence in a comment before code",
+			in:   "// Example:\n//\n// ```\n// x := 1\nfunc f() {}\n",
+			want: "Example:\n\n```\nx := 1\n```\n\n```go\nfunc f() {}\n```\n",
+		},
+		{
+			name: "longer fence in a comment",
+			in:   "// Example:\n//\n// ````\n// ```\n// ````\nfunc f() {}\n",
+			want: "Example:\n\n````\n```\n````\n\n```go\nfunc f() {}\n```\n",
+		},
+		{
+			name: "backticks within a code line",
+			in:   "// Text.\nvar s = \"```\"\n",
+			want: "Text.\n\n```go\nvar s = \"```\"\n```\n",
+		},
+		{
+			name: "fence in code",
+			in:   "// Text.\nvar s = `\n```\n`\n",
+			want: "Text.\n\n````go\nvar s = `\n```\n`\n````\n",
+		},
+		{
+			name: "longer fence in code",
+			in:   "// Text.\nvar s = `\n````\n`\n",
+			want: "Text.\n\n`````go\nvar s = `\n````\n`\n`````\n",
+		},
+	}
+	captureLog(t) // the warning about the unterminated fence
+	for _, tt := range tests {
+		got, _, err := convert(tt.in, Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: got\n%q\nwant\n%q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// redirect makes the file `name` the standard input or output `f` of the
+// test `t`.
+func redirect(t *testing.T, f **os.File, name string, flag int) {
+	file, err := os.OpenFile(name, flag, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := *f
+	*f = file
+	t.Cleanup(func() {
+		*f = saved
+		file.Close()
+	})
+}
+
+func TestConvertStdin(t *testing.T) {
+	const doc = "# Doc\n\n```go\npackage p\n```\n"
+	tests := []struct {
+		name   string
+		stdout string            // the expected standard output
+		files  map[string]string // the expected output files
+	}{
+		{"", doc, map[string]string{}},
+		{"foo.go", "", map[string]string{"out/foo.md": doc}},
+	}
+	for _, tt := range tests {
+		t.Run("name "+strconv.Quote(tt.name), func(t *testing.T) {
+			dir := t.TempDir()
+			in, stdout := filepath.Join(dir, "stdin"), filepath.Join(dir, "stdout")
+			if err := os.WriteFile(in, []byte("// # Doc\npackage p\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			redirect(t, &os.Stdin, in, os.O_RDONLY)
+			redirect(t, &os.Stdout, stdout, os.O_WRONLY|os.O_CREATE)
+			out := MemOutput{}
+			if _, err := convertStdin(tt.name, Options{OutDir: "out", out: out}); err != nil {
+				t.Fatal(err)
+			}
+			got, err := os.ReadFile(stdout)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.stdout {
+				t.Errorf("got standard output %q, want %q", got, tt.stdout)
+			}
+			files := map[string]string{}
+			for name, data := range out {
+				files[name] = string(data)
+			}
+			if !reflect.DeepEqual(files, tt.files) {
+				t.Errorf("got files %q, want %q", files, tt.files)
+			}
+		})
+	}
+}
+
+func TestConvertEnd(t *testing.T) {
+	tests := []struct {
+		name, in, fenced, indented string
+	}{
+		{
+			name:     "code",
+			in:       "// Text.\npackage p\n",
+			fenced:   "Text.\n\n```go\npackage p\n```\n",
+			indented: "Text.\n\n    package p\n",
+		},
+		{
+			name:     "code without a final line break",
+			in:       "// Text.\npackage p",
+			fenced:   "Text.\n\n```go\npackage p\n```\n",
+			indented: "Text.\n\n    package p\n",
+		},
+		{
+			name:     "code and blank lines",
+			in:       "// Text.\npackage p\n\n\n",
+			fenced:   "Text.\n\n```go\npackage p\n```\n",
+			indented: "Text.\n\n    package p\n",
+		},
+		{
+			name:     "comment",
+			in:       "package p\n\n// The end.\n",
+			fenced:   "```go\npackage p\n```\n\nThe end.\n",
+			indented: "    package p\n\nThe end.\n",
+		},
+		{
+			name:     "comment without a final line break",
+			in:       "package p\n\n// The end.",
+			fenced:   "```go\npackage p\n```\n\nThe end.\n",
+			indented: "    package p\n\nThe end.\n",
+		},
+		{
+			name: "empty",
+			in:   "",
+		},
+		{
+			name: "blank lines",
+			in:   "\n\n",
+		},
+	}
+	for _, tt := range tests {
+		for style, want := range map[string]string{"fenced": tt.fenced, "indented": tt.indented} {
+			got, _, err := convert(tt.in, Options{CodeStyle: style})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != want {
+				t.Errorf("%s, %s: got\n%q\nwant\n%q", tt.name, style, got, want)
+			}
+		}
+	}
+}
+
+func TestConvertOnly(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{
+			name: "line comments only",
+			in:   "// Only.\n//\n// Comments.\n",
+			want: "Only.\n\nComments.\n",
+		},
+		{
+			name: "block comment only",
+			in:   "/* Only\ncomments. */\n",
+			want: "Only\ncomments.\n",
+		},
+		{
+			name: "code only",
+			in:   "package p\n\nfunc f() {}\n",
+			want: "```go\npackage p\n\nfunc f() {}\n```\n",
+		},
+		{
+			name: "code only, with a trailing comment",
+			in:   "package p // p does nothing\n",
+			want: "```go\npackage p // p does nothing\n```\n",
+		},
+	}
+	for _, tt := range tests {
+		got, _, err := convert(tt.in, Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: got\n%q\nwant\n%q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestStripStars(t *testing.T) {
+	const in = "package p\n\n/*\n * Line one\n * line two.\n *\n * * A bullet\n */\nfunc f() {}\n"
+	tests := []struct {
+		strip bool
+		want  string
+	}{
+		{false, "```go\npackage p\n```\n\n * Line one\n * line two.\n *\n * * A bullet\n\n\n```go\nfunc f() {}\n```\n"},
+		// Only the first star goes, so the bullet stays a bullet.
+		{true, "```go\npackage p\n```\n\nLine one\nline two.\n\n* A bullet\n\n\n```go\nfunc f() {}\n```\n"},
+	}
+	for _, tt := range tests {
+		got, _, err := convert(in, Options{StripStars: tt.strip})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("strip stars %v: got\n%q\nwant\n%q", tt.strip, got, tt.want)
+		}
+	}
+}
+
+func TestLineNumbers(t *testing.T) {
+	in := "// Text.\npackage p\n\nfunc f() {\n\tg()\n}\n\n// Ten lines.\n" + strings.Repeat("x()\n", 10)
+	tests := []struct {
+		style, want string
+	}{
+		{"fenced", "Text.\n\n```go {linenos=true}\npackage p\n\nfunc f() {\n\tg()\n}\n```\n\nTen lines.\n\n```go {linenos=true}\n" + strings.Repeat("x()\n", 10) + "```\n"},
+		// The numbers are right-aligned and start at 1 in each block.
+		// Blank lines get no spaces after the number.
+		{"indented", "Text.\n\n    1  package p\n    2\n    3  func f() {\n    4  \tg()\n    5  }\n\nTen lines.\n\n     1  x()\n     2  x()\n     3  x()\n     4  x()\n     5  x()\n     6  x()\n     7  x()\n     8  x()\n     9  x()\n    10  x()\n"},
+	}
+	for _, tt := range tests {
+		got, _, err := convert(in, Options{LineNumbers: true, CodeStyle: tt.style})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: got\n%q\nwant\n%q", tt.style, got, tt.want)
+		}
+	}
+}
+
+func TestByteOrderMark(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"heading", "\ufeff// # Title\npackage p\n", "# Title\n\n```go\npackage p\n```\n"},
+		{"code", "\ufeffpackage p\n", "```go\npackage p\n```\n"},
+		{"carriage returns", "\ufeff// Text.\r\npackage p\r\n", "Text.\n\n```go\npackage p\n```\n"},
+	}
+	for _, tt := range tests {
+		got, _, err := convert(tt.in, Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: got\n%q\nwant\n%q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestOutputName(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+		want string
+	}{
+		{"default", Options{OutDir: "out"}, "out/doc.md"},
+		{".md", Options{OutDir: "out", OutputExt: ".md"}, "out/doc.md"},
+		{".markdown", Options{OutDir: "out", OutputExt: ".markdown"}, "out/doc.markdown"},
+		{".mdx", Options{OutDir: "out", OutputExt: ".mdx"}, "out/doc.mdx"},
+		{"HTML", Options{OutDir: "out", OutputExt: ".mdx", HTML: true}, "out/doc.html"},
+		{"AsciiDoc", Options{OutDir: "out", Format: "asciidoc"}, "out/doc.adoc"},
+		{"AsciiDoc with an extension", Options{OutDir: "out", Format: "asciidoc", OutputExt: ".asciidoc"}, "out/doc.asciidoc"},
+	}
+	for _, tt := range tests {
+		if got := outputName(filepath.Join("src", "doc.go"), tt.opts); got != filepath.FromSlash(tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestURLsInProse(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{
+			name: "line comments",
+			in:   "// https://example.com/a//b\n// See http://x.org, too.\npackage p\n",
+			want: "https://example.com/a//b\nSee http://x.org, too.\n\n```go\npackage p\n```\n",
+		},
+		{
+			name: "indented example",
+			in:   "// Indented:\n//\n//     // https://x\npackage p\n",
+			want: "Indented:\n\n    // https://x\n\n```go\npackage p\n```\n",
+		},
+		{
+			name: "block comment",
+			in:   "/* https://example.com\n//example.org */\npackage p\n",
+			want: "https://example.com\n//example.org\n\n```go\npackage p\n```\n",
+		},
+	}
+	for _, tt := range tests {
+		got, _, err := convert(tt.in, Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: got\n%q\nwant\n%q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCommentDelimsInProse(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{
+			name: "// in a line comment",
+			in:   "// Divide with a//b, as in // x := a//b.\npackage p\n",
+			want: "Divide with a//b, as in // x := a//b.\n\n```go\npackage p\n```\n",
+		},
+		{
+			name: "*/ in a line comment",
+			in:   "// A block comment ends with */, and /* starts one.\npackage p\n",
+			want: "A block comment ends with */, and /* starts one.\n\n```go\npackage p\n```\n",
+		},
+		{
+			name: "// in a block comment",
+			in:   "/*\n// x := a//b\n*/\npackage p\n",
+			want: "\n// x := a//b\n\n\n```go\npackage p\n```\n",
+		},
+	}
+	for _, tt := range tests {
+		got, _, err := convert(tt.in, Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: got\n%q\nwant\n%q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFencePassthrough(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{
+			name: "mermaid",
+			in:   "// Diagram:\n//\n// ```mermaid\n// graph TD\n//   A[\"![x](not.png)\"] --> B\n//   C[<img src=\"no.png\">]\n// ```\npackage p\n",
+			want: "Diagram:\n\n```mermaid\ngraph TD\n  A[\"![x](not.png)\"] --> B\n  C[<img src=\"no.png\">]\n```\n\n```go\npackage p\n```\n",
+		},
+		{
+			name: "graphviz in a block comment",
+			in:   "/* Graph:\n\n~~~dot\n# not a heading\ndigraph { a -> b } // a comment\n~~~\n*/\npackage p\n",
+			want: "Graph:\n\n~~~dot\n# not a heading\ndigraph { a -> b } // a comment\n~~~\n\n\n```go\npackage p\n```\n",
+		},
+	}
+	for _, tt := range tests {
+		got, media, err := convert(tt.in, Options{HeadingOffset: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: got\n%q\nwant\n%q", tt.name, got, tt.want)
+		}
+		if len(media) > 0 {
+			t.Errorf("%s: got media %v", tt.name, media)
+		}
+	}
+}
+
+func TestNoHype(t *testing.T) {
+	// There is no missing.html, so only -no-hype makes the conversion work.
+	const in = "// See HYPE[animation](missing.html).\npackage p\n"
+	got, media, err := convert(in, Options{NoHype: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "See HYPE[animation](missing.html).\n\n```go\npackage p\n```\n"; got != want {
+		t.Errorf("got\n%q\nwant\n%q", got, want)
+	}
+	if len(media) > 0 {
+		t.Errorf("got media %v", media)
+	}
+	if _, _, err := convert(in, Options{}); err == nil {
+		t.Error("with Hype: got no error")
+	}
+}
+
+func TestConvertFilesSubdirectory(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"docs/foo.go":    "// ![x](img/x.png)\npackage foo\n",
+		"docs/img/x.png": "png",
+	} {
+		f := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(f), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(f, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The image path is relative to docs/, not to the current directory.
+	t.Chdir(dir)
+	opts := Options{OutDir: "out"}
+	media, err := convertFiles([]string{filepath.Join("docs", "foo.go")}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := copyMedia(media, opts); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		"out/foo.md":    "![x](img/x.png)\n\n```go\npackage foo\n```\n",
+		"out/img/x.png": "png",
+	} {
+		got, err := os.ReadFile(filepath.FromSlash(name))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if string(got) != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestMinProseLen(t *testing.T) {
+	const in = "// Package p.\npackage p\n\nfunc f() {\n\t// ok\n\tg()\n\t// This comment explains at length what happens next.\n\th()\n}\n"
+	tests := []struct {
+		n    int
+		want string
+	}{
+		{0, "Package p.\n\n```go\npackage p\n\nfunc f() {\n```\n\nok\n\n```go\n\tg()\n```\n\nThis comment explains at length what happens next.\n\n```go\n\th()\n}\n```\n"},
+		// The short comment stays in the code, the long one does not, and
+		// neither does the first comment of the file.
+		{10, "Package p.\n\n```go\npackage p\n\nfunc f() {\n\t// ok\n\tg()\n```\n\nThis comment explains at length what happens next.\n\n```go\n\th()\n}\n```\n"},
+	}
+	for _, tt := range tests {
+		got, _, err := convert(in, Options{MinProseLen: tt.n})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("min prose len %d: got\n%q\nwant\n%q", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestTabWidth(t *testing.T) {
+	// Prose keeps its tabs; code gets tab stops.
+	const in = "// Text\twith a tab.\nfunc f() {\n\tif x {\n\t\ty := 1\t// y\n\t}\n}\n"
+	tests := []struct {
+		width int
+		want  string
+	}{
+		{0, "Text\twith a tab.\n\n```go\nfunc f() {\n\tif x {\n\t\ty := 1\t// y\n\t}\n}\n```\n"},
+		{2, "Text\twith a tab.\n\n```go\nfunc f() {\n  if x {\n    y := 1  // y\n  }\n}\n```\n"},
+		{4, "Text\twith a tab.\n\n```go\nfunc f() {\n    if x {\n        y := 1  // y\n    }\n}\n```\n"},
+		{8, "Text\twith a tab.\n\n```go\nfunc f() {\n        if x {\n                y := 1  // y\n        }\n}\n```\n"},
+	}
+	for _, tt := range tests {
+		got, _, err := convert(in, Options{TabWidth: tt.width})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("tab width %d: got\n%q\nwant\n%q", tt.width, got, tt.want)
+		}
+	}
+}
+
+func TestBackslashMediaPath(t *testing.T) {
+	_, media, err := convert("// ![x](img\\sub\\y.png)\npackage p\n", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"img/sub/y.png": "img/sub/y.png"}; !reflect.DeepEqual(media, want) {
+		t.Fatalf("got media %v, want %v", media, want)
+	}
+	cp := recordingCopier{}
+	if err := copyFiles("out", media, cp, &runSummary{}); err != nil {
+		t.Fatal(err)
+	}
+	if want := (recordingCopier{"img/sub/y.png": "out/img/sub/y.png"}); !reflect.DeepEqual(cp, want) {
+		t.Errorf("got %v, want %v", cp, want)
+	}
+}
+
+func TestSectionBreaks(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{
+			name: "headings",
+			in:   "// # One\n//\n// Text.\n//\n// ## Sub\n//\n// # Two\npackage p\n\n// # Three\nfunc f() {}\n",
+			want: "# One\n\nText.\n\n## Sub\n\n---\n\n# Two\n\n```go\npackage p\n```\n\n---\n\n# Three\n\n```go\nfunc f() {}\n```\n",
+		},
+		{
+			name: "first heading after prose",
+			in:   "// Intro.\n//\n// # One\npackage p\n",
+			want: "Intro.\n\n# One\n\n```go\npackage p\n```\n",
+		},
+		{
+			// Without the blank line, "Text." would become a heading.
+			name: "heading right after a line of text",
+			in:   "// # One\n// Text.\n// # Two\npackage p\n",
+			want: "# One\nText.\n\n---\n\n# Two\n\n```go\npackage p\n```\n",
+		},
+	}
+	for _, tt := range tests {
+		got, _, err := convert(tt.in, Options{SectionBreaks: "---"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: got\n%q\nwant\n%q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestIndentedExamples(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{
+			name: "line comments",
+			in:   "// # Usage\n//\n//     p -flag value\n//         continued\n//\t# not a heading\n//\n// More text.\npackage p\n",
+			want: "## Usage\n\n    p -flag value\n        continued\n\t# not a heading\n\nMore text.\n\n```go\npackage p\n```\n",
+		},
+		{
+			name: "after code",
+			in:   "package p\n\n// F does.\n//\n//\tf := F()\n//\t\tf.Run()\nfunc F() {}\n",
+			want: "```go\npackage p\n```\n\nF does.\n\n\tf := F()\n\t\tf.Run()\n\n```go\nfunc F() {}\n```\n",
+		},
+	}
+	for _, tt := range tests {
+		got, _, err := convert(tt.in, Options{HeadingOffset: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: got\n%q\nwant\n%q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBareCommentSeparators(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+		opts           Options
+	}{
+		{
+			name: "between paragraphs",
+			in:   "// One.\n//\n// Two.\n//\n// Three.\npackage p\n",
+			want: "One.\n\nTwo.\n\nThree.\n\n```go\npackage p\n```\n",
+		},
+		{
+			name: "right after code",
+			in:   "package p\n\n//\n// F does things.\n//\n// More.\nfunc F() {}\n",
+			want: "```go\npackage p\n```\n\nF does things.\n\nMore.\n\n```go\nfunc F() {}\n```\n",
+		},
+		{
+			name: "with min prose len",
+			in:   "package p\n\nfunc F() {\n\tf()\n\t// A comment that is long enough.\n\t//\n\t// And a second paragraph.\n\tg()\n}\n",
+			want: "```go\npackage p\n\nfunc F() {\n\tf()\n```\n\nA comment that is long enough.\n\nAnd a second paragraph.\n\n```go\n\tg()\n}\n```\n",
+			opts: Options{MinProseLen: 20},
+		},
+		{
+			name: "short paragraphs with min prose len",
+			in:   "package p\n\nfunc F() {\n\tf() // f\n\t//\n\t// g\n}\n",
+			want: "```go\npackage p\n\nfunc F() {\n\tf() // f\n\t//\n\t// g\n}\n```\n",
+			opts: Options{MinProseLen: 20},
+		},
+	}
+	for _, tt := range tests {
+		got, _, err := convert(tt.in, tt.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: got\n%q\nwant\n%q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCodeCaption(t *testing.T) {
+	tests := []struct {
+		name, in, format, want string
+	}{
+		{
+			name: "after a heading",
+			in:   "// # Parsing the input {#parse}\npackage p\n\n// Text.\nfunc f() {}\n",
+			want: "# Parsing the input {#parse}\n\n*Listing: Parsing the input*\n\n```go\npackage p\n```\n\nText.\n\n*Listing: Parsing the input*\n\n```go\nfunc f() {}\n```\n",
+		},
+		{
+			name: "without a heading",
+			in:   "// No heading.\npackage p\n",
+			want: "No heading.\n\n```go\npackage p\n```\n",
+		},
+		{
+			name: "caption directive",
+			in:   "// Text.\n//gtm:caption The main package\npackage p\n\n// # Two ##\nfunc f() {}\n",
+			want: "Text.\n\n*Listing: The main package*\n\n```go\npackage p\n```\n\n# Two ##\n\n*Listing: Two*\n\n```go\nfunc f() {}\n```\n",
+		},
+		{
+			name:   "caption format",
+			in:     "// # One\npackage p\n\n// # Two\nfunc f() {}\n",
+			format: "Listing {{.Number}}: {{.Heading}}",
+			want:   "# One\n\nListing 1: One\n\n```go\npackage p\n```\n\n# Two\n\nListing 2: Two\n\n```go\nfunc f() {}\n```\n",
+		},
+	}
+	for _, tt := range tests {
+		got, _, err := convert(tt.in, Options{CodeCaption: true, CaptionFormat: tt.format})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: got\n%q\nwant\n%q", tt.name, got, tt.want)
+		}
+	}
+	if _, _, err := convert("package p\n", Options{CodeCaption: true, CaptionFormat: "{{.Heading"}); err == nil {
+		t.Error("invalid caption format: got no error")
+	}
+}
+
+func TestOutDirFromEnv(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.WriteFile("foo.go", []byte("// # Foo\npackage foo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	saved := flag.CommandLine
+	t.Cleanup(func() { flag.CommandLine = saved })
+	tests := []struct {
+		env  string
+		args []string
+		want string
+	}{
+		{"", nil, "out"},
+		{"ci", nil, "ci"},
+		// The flag wins over the environment.
+		{"ci", []string{"-outdir", "docs"}, "docs"},
+	}
+	for _, tt := range tests {
+		t.Setenv("GOTOMARKDOWN_OUTDIR", tt.env)
+		flag.CommandLine = flag.NewFlagSet("gotomarkdown", flag.ContinueOnError)
+		opts := Options{run: newRunState()}
+		setFlags(&opts)
+		if err := flag.CommandLine.Parse(tt.args); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := convertFiles([]string{"foo.go"}, opts); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(filepath.Join(tt.want, "foo.md")); err != nil {
+			t.Errorf("env %q, args %v: got %v, want %s/foo.md", tt.env, tt.args, err, tt.want)
+		}
+		if err := os.RemoveAll(tt.want); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestEndOfFile(t *testing.T) {
+	// These are about the code fences at the end of the file, so the
+	// empty lines at the very end do not count.
+	tests := []struct {
+		name, in, want, log string
+	}{
+		{
+			name: "code, then a line comment",
+			in:   "package p\n\nfunc f() {}\n\n// The end.\n",
+			want: "```go\npackage p\n\nfunc f() {}\n```\n\nThe end.\n",
+		},
+		{
+			name: "code, then a block comment without a final newline",
+			in:   "package p\n\nfunc f() {}\n/* The end. */",
+			want: "```go\npackage p\n\nfunc f() {}\n```\n\nThe end.\n",
+		},
+		{
+			name: "comment, then nothing",
+			in:   "// Only a comment.\n",
+			want: "Only a comment.\n",
+		},
+		{
+			name: "code fence, then nothing",
+			in:   "// Text.\n//\n// ```go\n//\n",
+			want: "Text.\n",
+			log:  "[Warning] Dropping empty, unterminated code fence in comment at the end of the file, starting at line 3",
+		},
+		{
+			name: "code, then a code fence",
+			in:   "package p\n\n// ```\n",
+			want: "```go\npackage p\n```\n",
+			log:  "[Warning] Dropping empty, unterminated code fence in comment at the end of the file, starting at line 3",
+		},
+		{
+			name: "code, then a fence directive",
+			in:   "package p\n\n//gtm:fence go {hl_lines=[1]}\n\n",
+			want: "```go\npackage p\n```\n",
+		},
+		{
+			name: "code fence with content",
+			in:   "// ```go\n// x := 1\n",
+			want: "```go\nx := 1\n```\n",
+			log:  "[Warning] Unterminated code fence in comment at the end of the file, starting at line 1",
+		},
+	}
+	for _, tt := range tests {
+		logged := captureLog(t)
+		got, _, err := convert(tt.in, Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got = strings.TrimRight(got, "\n") + "\n"; got != tt.want {
+			t.Errorf("%s: got\n%q\nwant\n%q", tt.name, got, tt.want)
+		}
+		if !strings.Contains(logged.String(), tt.log) || tt.log == "" && logged.Len() > 0 {
+			t.Errorf("%s: got log %q, want %q", tt.name, logged, tt.log)
+		}
+	}
+}
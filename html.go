@@ -0,0 +1,256 @@
+// ## Rendering the Markdown as HTML
+//
+// For quick previews, `gotomarkdown -html` renders the generated Markdown
+// to an HTML fragment. The renderer below is deliberately small: it knows
+// exactly the subset of Markdown that `convert` produces (headings, paragraphs,
+// lists, block quotes, fenced code, and the usual inline elements), and passes
+// raw HTML (like the Hype snippets) through unchanged.
+//
+// A full renderer like goldmark would be the first dependency outside the
+// standard library, and `gotomarkdown` builds with a plain `go build`. For
+// a preview of its own output, the subset is enough.
+
+package main
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	htmlFence     = regexp.MustCompile("^\\s*(```+|~~~+)\\s*([^\\s`]*)")                     // opening or closing code fence
+	htmlHeading   = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)                         // ATX heading
+	htmlRule      = regexp.MustCompile(`^\s*([-*_])(\s*([-*_])){2,}\s*$`)                    // horizontal rule
+	htmlULItem    = regexp.MustCompile(`^\s*[-*+]\s+(.*)$`)                                  // unordered list item
+	htmlOLItem    = regexp.MustCompile(`^\s*\d+\.\s+(.*)$`)                                  // ordered list item
+	htmlQuote     = regexp.MustCompile(`^\s*>\s?(.*)$`)                                      // block quote
+	htmlRawBlock  = regexp.MustCompile(`^\s*</?[a-zA-Z!][^>]*>`)                             // line starting with an HTML tag or comment
+	htmlIndented  = regexp.MustCompile(`^(\t| {4})`)                                         // indented code line
+	htmlCodeSpan  = regexp.MustCompile("`([^`]+)`")                                          // inline code
+	htmlImage     = regexp.MustCompile(`!\[([^\]]*)\]\(\s*([^"\)]+?)\s*(?:"([^"]*)")?\s*\)`) // inline image
+	htmlLink      = regexp.MustCompile(`\[([^\]]+)\]\(\s*([^"\)\s]+)\s*(?:"([^"]*)")?\s*\)`) // inline link
+	htmlStrong    = regexp.MustCompile(`(\*\*|__)([^\s*_](?:.*?[^\s])?)(\*\*|__)`)           // strong emphasis
+	htmlEmphasis  = regexp.MustCompile(`(^|[^\w*])[*_]([^\s*_](?:[^*_]*[^\s*_])?)[*_]`)      // emphasis
+	htmlLineBreak = regexp.MustCompile(`  $`)                                                // hard line break
+	htmlEscaped   = regexp.MustCompile("\\\\([!-/:-@\\[-`{-~])")                             // backslash escape of a punctuation character
+	htmlTag       = regexp.MustCompile(`</?[a-zA-Z][^>]*>`)                                  // an HTML tag within text
+	htmlTagMark   = regexp.MustCompile("\x00\\d+\x00")                                       // the mark of a tag set aside
+)
+
+// renderHTML converts Markdown text into an HTML fragment. Front matter at
+// the top is for the static site generator, not for the reader, so it
+// stays in front of the fragment as it is.
+func renderHTML(md string) string {
+	lines := strings.Split(md, "\n")
+	if start, end := findFrontMatter(lines); start >= 0 {
+		return strings.Join(lines[start:end+1], "\n") + "\n\n" + renderFragment(strings.Join(lines[end+1:], "\n"))
+	}
+	return renderFragment(md)
+}
+
+// renderFragment converts Markdown text without front matter into an
+// HTML fragment.
+func renderFragment(md string) string {
+	var out, para []string
+	list := ""          // "ul" or "ol" while a list is open
+	quote := false      // true while a block quote is open
+	fence := ""         // the opening fence delimiter while inside a fenced code block
+	fenceEmpty := false // true while the fenced code block has no line yet
+	indented := false   // true while inside an indented code block
+
+	flushPara := func() {
+		if len(para) > 0 {
+			out = append(out, "<p>"+renderInline(strings.Join(para, "\n"))+"</p>")
+			para = nil
+		}
+	}
+	closeBlocks := func() {
+		flushPara()
+		if list != "" {
+			out = append(out, "</"+list+">")
+			list = ""
+		}
+		if quote {
+			out = append(out, "</blockquote>")
+			quote = false
+		}
+		if indented {
+			// Blank lines after the code end the block, they are no code.
+			for out[len(out)-1] == "" {
+				out = out[:len(out)-1]
+			}
+			out[len(out)-1] += "</code></pre>"
+			indented = false
+		}
+	}
+
+	for _, line := range strings.Split(strings.Replace(md, "\r", "", -1), "\n") {
+		// Inside a fenced code block, only the closing fence is special.
+		if fence != "" {
+			if m := htmlFence.FindStringSubmatch(line); m != nil && strings.HasPrefix(m[1], fence) && m[2] == "" {
+				out[len(out)-1] += "</code></pre>"
+				fence = ""
+				continue
+			}
+			// The first line goes right after the opening tag, or else
+			// the code would start with an empty line.
+			if fenceEmpty {
+				out[len(out)-1] += html.EscapeString(line)
+				fenceEmpty = false
+			} else {
+				out = append(out, html.EscapeString(line))
+			}
+			continue
+		}
+		if indented {
+			if htmlIndented.MatchString(line) || strings.TrimSpace(line) == "" {
+				out = append(out, html.EscapeString(htmlIndented.ReplaceAllString(line, "")))
+				continue
+			}
+			closeBlocks()
+		}
+		if m := htmlFence.FindStringSubmatch(line); m != nil {
+			closeBlocks()
+			fence = m[1]
+			class := ""
+			if m[2] != "" {
+				class = ` class="language-` + html.EscapeString(m[2]) + `"`
+			}
+			out = append(out, "<pre><code"+class+">")
+			fenceEmpty = true
+			continue
+		}
+		if strings.TrimSpace(line) == "" {
+			closeBlocks()
+			continue
+		}
+		if len(para) == 0 && list == "" && !quote && htmlIndented.MatchString(line) {
+			indented = true
+			out = append(out, "<pre><code>"+html.EscapeString(htmlIndented.ReplaceAllString(line, "")))
+			continue
+		}
+		if m := htmlHeading.FindStringSubmatch(line); m != nil {
+			closeBlocks()
+			level := strconv.Itoa(len(m[1]))
+			text, attr := m[2], ""
+			if id := headingID.FindStringSubmatch(text); id != nil {
+				text, attr = headingID.ReplaceAllString(text, ""), ` id="`+html.EscapeString(id[1])+`"`
+			}
+			out = append(out, "<h"+level+attr+">"+renderInline(text)+"</h"+level+">")
+			continue
+		}
+		if htmlRule.MatchString(line) {
+			closeBlocks()
+			out = append(out, "<hr>")
+			continue
+		}
+		if htmlRawBlock.MatchString(line) && len(para) == 0 {
+			closeBlocks()
+			out = append(out, line)
+			continue
+		}
+		if m := htmlQuote.FindStringSubmatch(line); m != nil {
+			if !quote {
+				closeBlocks()
+				out = append(out, "<blockquote>")
+				quote = true
+			}
+			para = append(para, m[1])
+			continue
+		}
+		if m := htmlULItem.FindStringSubmatch(line); m != nil {
+			openList(&out, &list, "ul", flushPara, closeBlocks)
+			out = append(out, "<li>"+renderInline(m[1])+"</li>")
+			continue
+		}
+		if m := htmlOLItem.FindStringSubmatch(line); m != nil {
+			openList(&out, &list, "ol", flushPara, closeBlocks)
+			out = append(out, "<li>"+renderInline(m[1])+"</li>")
+			continue
+		}
+		// A continuation line of a list item is appended to that item.
+		if list != "" {
+			last := len(out) - 1
+			out[last] = strings.TrimSuffix(out[last], "</li>") + "\n" + renderInline(strings.TrimSpace(line)) + "</li>"
+			continue
+		}
+		para = append(para, line)
+	}
+	if fence != "" {
+		out[len(out)-1] += "</code></pre>"
+	}
+	closeBlocks()
+	return strings.Join(out, "\n") + "\n"
+}
+
+// openList starts a list of the given kind unless one is already open.
+func openList(out *[]string, list *string, kind string, flushPara, closeBlocks func()) {
+	if *list == kind {
+		flushPara()
+		return
+	}
+	closeBlocks()
+	*out = append(*out, "<"+kind+">")
+	*list = kind
+}
+
+// renderInline converts the inline elements of a block of text. Code spans
+// are rendered first, and their content is not processed any further.
+func renderInline(text string) string {
+	var out strings.Builder
+	for {
+		loc := htmlCodeSpan.FindStringSubmatchIndex(text)
+		if loc == nil {
+			out.WriteString(renderSpans(text))
+			return out.String()
+		}
+		out.WriteString(renderSpans(text[:loc[0]]) + "<code>" + html.EscapeString(text[loc[2]:loc[3]]) + "</code>")
+		text = text[loc[1]:]
+	}
+}
+
+// renderSpans converts escapes, images, links, emphasis, and hard line breaks.
+// Other text, including inline HTML, is passed through as is.
+func renderSpans(text string) string {
+	// Escaped characters become character references, so that they are
+	// no markup anymore.
+	text = htmlEscaped.ReplaceAllStringFunc(text, func(s string) string {
+		return "&#" + strconv.Itoa(int(s[1])) + ";"
+	})
+	text = htmlImage.ReplaceAllStringFunc(text, func(tag string) string {
+		m := htmlImage.FindStringSubmatch(tag)
+		title := ""
+		if m[3] != "" {
+			title = ` title="` + html.EscapeString(m[3]) + `"`
+		}
+		return `<img src="` + html.EscapeString(unbracket(m[2])) + `" alt="` + html.EscapeString(m[1]) + `"` + title + `>`
+	})
+	text = htmlLink.ReplaceAllStringFunc(text, func(tag string) string {
+		m := htmlLink.FindStringSubmatch(tag)
+		title := ""
+		if m[3] != "" {
+			title = ` title="` + html.EscapeString(m[3]) + `"`
+		}
+		return `<a href="` + html.EscapeString(m[2]) + `"` + title + `>` + m[1] + `</a>`
+	})
+	// Emphasis must not get into the attributes of the tags, like in
+	// href="https://example.com/_a_/", so the tags are set aside meanwhile.
+	var tags []string
+	text = htmlTag.ReplaceAllStringFunc(text, func(tag string) string {
+		tags = append(tags, tag)
+		return "\x00" + strconv.Itoa(len(tags)-1) + "\x00"
+	})
+	text = htmlStrong.ReplaceAllString(text, "<strong>$2</strong>")
+	text = htmlEmphasis.ReplaceAllString(text, "$1<em>$2</em>")
+	text = htmlTagMark.ReplaceAllStringFunc(text, func(mark string) string {
+		i, _ := strconv.Atoi(mark[1 : len(mark)-1])
+		return tags[i]
+	})
+	lines := strings.Split(text, "\n")
+	for i := range lines[:len(lines)-1] {
+		lines[i] = htmlLineBreak.ReplaceAllString(lines[i], "<br>")
+	}
+	return strings.Join(lines, "\n")
+}
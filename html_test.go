@@ -0,0 +1,92 @@
+package main
+
+import "testing"
+
+func TestRenderHTML(t *testing.T) {
+	tests := []struct {
+		name, md, want string
+	}{
+		{
+			name: "heading and emphasis",
+			md:   "# Title\n\nSome *em* and **strong** text.\n",
+			want: "<h1>Title</h1>\n<p>Some <em>em</em> and <strong>strong</strong> text.</p>\n",
+		},
+		{
+			name: "closed heading",
+			md:   "## Heading ##\n",
+			want: "<h2>Heading</h2>\n",
+		},
+		{
+			name: "fenced code",
+			md:   "```go\nfunc f() {\n\treturn a < b\n}\n```\n",
+			want: "<pre><code class=\"language-go\">func f() {\n\treturn a &lt; b\n}</code></pre>\n",
+		},
+		{
+			name: "empty fenced code",
+			md:   "```\n```\n",
+			want: "<pre><code></code></pre>\n",
+		},
+		{
+			name: "indented code",
+			md:   "    a\n\n    b\n\n\nText.\n",
+			want: "<pre><code>a\n\nb</code></pre>\n<p>Text.</p>\n",
+		},
+		{
+			name: "lists",
+			md:   "- one\n- two\n\n1. first\n2. second\n",
+			want: "<ul>\n<li>one</li>\n<li>two</li>\n</ul>\n<ol>\n<li>first</li>\n<li>second</li>\n</ol>\n",
+		},
+		{
+			name: "block quote",
+			md:   "> quoted\n> more\n",
+			want: "<blockquote>\n<p>quoted\nmore</p>\n</blockquote>\n",
+		},
+		{
+			name: "rule",
+			md:   "***\n",
+			want: "<hr>\n",
+		},
+		{
+			name: "line break",
+			md:   "line one  \nline two\n",
+			want: "<p>line one<br>\nline two</p>\n",
+		},
+		{
+			name: "links, images, and code spans",
+			md:   "A [link](https://x.org/a_b_c \"T\") and ![img](a_b.png) and `co*de*`.\n",
+			want: "<p>A <a href=\"https://x.org/a_b_c\" title=\"T\">link</a> and <img src=\"a_b.png\" alt=\"img\"> and <code>co*de*</code>.</p>\n",
+		},
+		{
+			name: "escaped punctuation",
+			md:   "\\*not em\\*\n",
+			want: "<p>&#42;not em&#42;</p>\n",
+		},
+		{
+			name: "raw HTML block",
+			md:   "<div class=\"x_y_z\">\n<p>raw</p>\n</div>\n",
+			want: "<div class=\"x_y_z\">\n<p>raw</p>\n</div>\n",
+		},
+		{
+			name: "TOML front matter",
+			md:   "+++\ntitle = \"T\"\n+++\n\n# T\n",
+			want: "+++\ntitle = \"T\"\n+++\n\n<h1>T</h1>\n",
+		},
+		{
+			name: "YAML front matter",
+			md:   "---\ntitle: \"T\"\n---\n\nText.\n\n---\n",
+			want: "---\ntitle: \"T\"\n---\n\n<p>Text.</p>\n<hr>\n",
+		},
+		{
+			name: "no emphasis in tags",
+			md:   "Text <a href=\"a_b_c.html\">x_y_z</a> _em_.\n",
+			want: "<p>Text <a href=\"a_b_c.html\">x_y_z</a> <em>em</em>.</p>\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := renderHTML(tt.md); got != tt.want {
+				t.Errorf("got\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+// ## Including code from other files
+//
+// Tutorials often show code that lives in another file. The directive
+// `//gtm:include path/to/other.go` inserts that file as code, as if it
+// were part of the Go file at this point. A suffix selects a part of the
+// file:
+//
+// * `//gtm:include other.go#func=Foo` inserts the function (or method) `Foo`.
+// * `//gtm:include other.go#lines=10-20` inserts lines 10 to 20.
+//
+// The path is relative to the directory of the Go file.
+
+package main
+
+import (
+	"errors"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// readInclude returns the lines that the include reference `ref` selects.
+// Relative paths are relative to `dir` in `fsys`.
+func readInclude(fsys fs.FS, ref, dir string) ([]string, error) {
+	name, part := ref, ""
+	if i := strings.LastIndex(ref, "#"); i >= 0 {
+		name, part = ref[:i], ref[i+1:]
+	}
+	if name == "" {
+		return nil, errors.New("Missing file name in //gtm:include " + ref)
+	}
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(dir, name)
+	}
+	src, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, errors.New("Cannot read included file " + name + "\n" + err.Error())
+	}
+	text := strings.Replace(string(src), "\r", "", -1)
+	switch {
+	case part == "":
+		return strings.Split(strings.TrimRight(text, "\n"), "\n"), nil
+	case strings.HasPrefix(part, "func="):
+		return includeFunc(text, name, strings.TrimPrefix(part, "func="))
+	case strings.HasPrefix(part, "lines="):
+		return includeLines(text, name, strings.TrimPrefix(part, "lines="))
+	}
+	return nil, errors.New("Unknown part #" + part + " in //gtm:include " + ref + ", expected #func=name or #lines=from-to")
+}
+
+// includeFunc returns the lines of the function or method `fn` in `src`,
+// the source of the file `name`.
+func includeFunc(src, name, fn string) ([]string, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, name, src, 0)
+	if err != nil {
+		return nil, errors.New("Cannot parse included file " + name + "\n" + err.Error())
+	}
+	file := fset.File(f.Pos())
+	for _, decl := range f.Decls {
+		if d, ok := decl.(*ast.FuncDecl); ok && d.Name.Name == fn {
+			return strings.Split(src[file.Offset(d.Pos()):file.Offset(d.End())], "\n"), nil
+		}
+	}
+	return nil, errors.New("No function " + fn + " in included file " + name)
+}
+
+// includeLines returns the lines `from-to` of `src`, the source of the
+// file `name`. The first line is line 1.
+func includeLines(src, name, lines string) ([]string, error) {
+	bounds := strings.SplitN(lines, "-", 2)
+	from, err := strconv.Atoi(bounds[0])
+	to := from
+	if err == nil && len(bounds) == 2 {
+		to, err = strconv.Atoi(bounds[1])
+	}
+	all := strings.Split(strings.TrimRight(src, "\n"), "\n")
+	if err != nil || from < 1 || to < from || to > len(all) {
+		return nil, errors.New("Invalid line range " + lines + " for included file " + name + " with " + strconv.Itoa(len(all)) + " lines")
+	}
+	return all[from-1 : to], nil
+}
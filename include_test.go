@@ -0,0 +1,58 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestReadInclude(t *testing.T) {
+	fsys := fstest.MapFS{
+		"src/other.go": {Data: []byte("package other\n\n// Foo does.\nfunc Foo() {\n\tbar()\n}\n\ntype T struct{}\n\nfunc (T) Foo() {}\n")},
+	}
+	tests := []struct {
+		ref  string
+		want []string
+	}{
+		{"other.go", []string{"package other", "", "// Foo does.", "func Foo() {", "\tbar()", "}", "", "type T struct{}", "", "func (T) Foo() {}"}},
+		{"other.go#lines=4-6", []string{"func Foo() {", "\tbar()", "}"}},
+		{"other.go#lines=8", []string{"type T struct{}"}},
+		// The first function of the name gets included, without its doc
+		// comment.
+		{"other.go#func=Foo", []string{"func Foo() {", "\tbar()", "}"}},
+	}
+	for _, tt := range tests {
+		got, err := readInclude(fsys, tt.ref, "src")
+		if err != nil {
+			t.Errorf("%s: %v", tt.ref, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.ref, got, tt.want)
+		}
+	}
+	for _, ref := range []string{"missing.go", "#func=Foo", "other.go#func=Bar", "other.go#lines=9-20", "other.go#lines=3-2", "other.go#lines=x", "other.go#part"} {
+		if _, err := readInclude(fsys, ref, "src"); err == nil {
+			t.Errorf("%s: got no error", ref)
+		}
+	}
+}
+
+func TestIncludeDirective(t *testing.T) {
+	src := fstest.MapFS{
+		"src/doc.go":   {Data: []byte("// The function:\n//gtm:include other.go#func=Foo\n\n// The rest.\npackage doc\n")},
+		"src/other.go": {Data: []byte("package other\n\nfunc Foo() {}\n")},
+		"src/bad.go":   {Data: []byte("//gtm:include missing.go\npackage doc\n")},
+	}
+	out := MemOutput{}
+	fc := NewFileConverter(src, out, Options{OutDir: "out"})
+	if _, err := fc.Convert("src/doc.go"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(out["out/doc.md"]), "The function:\n\n```go\nfunc Foo() {}\n```\n\nThe rest.\n\n```go\npackage doc\n```\n"; got != want {
+		t.Errorf("got\n%q\nwant\n%q", got, want)
+	}
+	if _, err := fc.Convert("src/bad.go"); err == nil {
+		t.Error("missing.go: got no error")
+	}
+}
@@ -0,0 +1,168 @@
+// ## Other languages
+//
+// Literate programming is not only a Go thing. Python files and shell
+// scripts, say, may tell their story in comments, too. The registry below
+// maps file extensions to languages. A language knows its line comment
+// marker and the info string of its code fences.
+//
+// The converter itself speaks Go. Before the conversion, the comments of
+// another language get rewritten into Go comments, so `# text` becomes
+// `// text`. Everything else stays as it is, and the code blocks get the
+// fence language of the file, like ```python. The features that need Go
+// syntax, like `-only-exported`, apply to Go files only.
+//
+// A shebang line like `#!/usr/bin/env bash` at the top of a script looks
+// like a comment, too, but it is code: it goes into a code block verbatim.
+// With `-keep-shebang=false`, it gets dropped instead.
+//
+// With `-r`, directories on the command line get searched for the files
+// of all the languages in the registry. Files with other extensions are
+// skipped with a warning, and so are hidden files and directories like
+// `.git`. Symbolic links get followed. A file behind a link gets the name
+// of the link in the output. A linked directory gets searched only once,
+// even if links lead to it in a cycle.
+
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// language describes the comment syntax of a programming language.
+type language struct {
+	fence   string // the info string of the code fences, like "python"
+	comment string // the line comment marker; empty: Go, which needs no rewrite
+}
+
+// languages maps file extensions to languages.
+var languages = map[string]language{
+	".go":   {fence: "go"},
+	".py":   {fence: "python", comment: "#"},
+	".sh":   {fence: "bash", comment: "#"},
+	".bash": {fence: "bash", comment: "#"},
+	".rb":   {fence: "ruby", comment: "#"},
+}
+
+// languageOf returns the language of the file `filename`. Files with an
+// unknown extension are taken as Go, as always.
+func languageOf(filename string) language {
+	if lang, ok := languages[strings.ToLower(filepath.Ext(filename))]; ok {
+		return lang
+	}
+	return languages[".go"]
+}
+
+// rewriteComments turns the line comments of `lang` in `src` into Go line
+// comments. Comments after code, like `x = 1  # note`, stay with the code,
+// and so does a shebang line. With `keep` for `-keep-go-comments`, only the
+// comments before the first line of code get rewritten, and the others
+// stay in the code as they are.
+func rewriteComments(src string, lang language, keep bool) string {
+	if lang.comment == "" {
+		return src
+	}
+	lines := strings.Split(src, "\n")
+	for i, line := range lines {
+		if i == 0 && strings.HasPrefix(line, "#!") {
+			continue
+		}
+		text := strings.TrimLeft(line, " \t")
+		if strings.HasPrefix(text, lang.comment) {
+			indent := line[:len(line)-len(text)]
+			lines[i] = indent + "//" + text[len(lang.comment):]
+		} else if keep && text != "" {
+			break
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// dropShebang empties a shebang line at the top of the script `src` of
+// `lang`. The line stays, so the line numbers in messages stay right.
+func dropShebang(src string, lang language) string {
+	if lang.comment == "" || !strings.HasPrefix(src, "#!") {
+		return src
+	}
+	if i := strings.IndexByte(src, '\n'); i >= 0 {
+		return src[i:]
+	}
+	return ""
+}
+
+// findSources returns the files below the directory `dir` that have the
+// extension of a known language, in lexical order.
+func findSources(dir string) (filenames []string, err error) {
+	err = walkSources(dir, map[string]bool{}, &filenames)
+	if err != nil {
+		return nil, errors.New("Cannot search directory " + dir + "\n" + err.Error())
+	}
+	return filenames, nil
+}
+
+// walkSources adds the source files below `dir` to `filenames`. `visited`
+// holds the real paths of the directories searched so far. A directory
+// that a link leads to is searched only once, under the name that comes
+// first, so that its files do not get converted twice.
+func walkSources(dir string, visited map[string]bool, filenames *[]string) error {
+	real, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	if abs, err := filepath.Abs(real); err == nil {
+		real = abs
+	}
+	if visited[real] {
+		return nil
+	}
+	visited[real] = true
+	// WalkDir does not descend into a root that is a link, so walk the
+	// real directory, but keep the names below `dir`.
+	return filepath.WalkDir(real, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// WalkDir does not follow links, so the paths it passes are real.
+		realPath := p
+		if rel, err := filepath.Rel(real, p); err == nil {
+			p = filepath.Join(dir, rel)
+		}
+		if p != filepath.Clean(dir) && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if realPath == real {
+				return nil
+			}
+			if visited[realPath] {
+				return filepath.SkipDir
+			}
+			visited[realPath] = true
+			return nil
+		}
+		// WalkDir does not follow symbolic links, so follow them here.
+		if d.Type()&fs.ModeSymlink != 0 {
+			fi, err := os.Stat(p)
+			if err != nil {
+				log.Println("[Warning] Skipping " + p + ", a broken link")
+				return nil
+			}
+			if fi.IsDir() {
+				return walkSources(p, visited, filenames)
+			}
+		}
+		if _, ok := languages[strings.ToLower(filepath.Ext(p))]; !ok {
+			log.Println("[Warning] Skipping " + p + ", a file of no known language")
+			return nil
+		}
+		*filenames = append(*filenames, p)
+		return nil
+	})
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRewriteComments(t *testing.T) {
+	python := languages[".py"]
+	tests := []struct {
+		name, src string
+		lang      language
+		keep      bool
+		want      string
+	}{
+		{
+			name: "go",
+			src:  "// text\npackage p\n",
+			lang: languages[".go"],
+			want: "// text\npackage p\n",
+		},
+		{
+			name: "comments",
+			src:  "# text\n  # indented\nx = 1  # note\n#\n",
+			lang: python,
+			want: "// text\n  // indented\nx = 1  # note\n//\n",
+		},
+		{
+			name: "shebang",
+			src:  "#!/usr/bin/env bash\n# text\necho\n",
+			lang: languages[".sh"],
+			want: "#!/usr/bin/env bash\n// text\necho\n",
+		},
+		{
+			name: "keep the comments after the first code",
+			src:  "# text\n\nx = 1\n# comment\n",
+			lang: python,
+			keep: true,
+			want: "// text\n\nx = 1\n# comment\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rewriteComments(tt.src, tt.lang, tt.keep); got != tt.want {
+				t.Errorf("got\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShebang(t *testing.T) {
+	const src = "#!/usr/bin/env bash\n# Say hello.\necho hello\n"
+	tests := []struct {
+		keep bool
+		want string
+	}{
+		{true, "```bash\n#!/usr/bin/env bash\n```\n\nSay hello.\n\n```bash\necho hello\n```\n"},
+		{false, "Say hello.\n\n```bash\necho hello\n```\n"},
+	}
+	for _, tt := range tests {
+		got, _, err := render(src, "hello.sh", Options{KeepShebang: tt.keep})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("keep shebang %v: got\n%q\nwant\n%q", tt.keep, got, tt.want)
+		}
+	}
+}
+
+func TestLanguageOf(t *testing.T) {
+	for filename, want := range map[string]string{
+		"a.go":    "go",
+		"a.PY":    "python",
+		"a.bash":  "bash",
+		"a.rb":    "ruby",
+		"a.other": "go",
+	} {
+		if got := languageOf(filename).fence; got != want {
+			t.Errorf("%s: got %s, want %s", filename, got, want)
+		}
+	}
+}
+
+func TestFindSources(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.go", "b.py", "notes.txt", ".hidden/c.go", "sub/d.sh", "real/e.rb"} {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A linked directory, a linked file, and a link back up, which must
+	// not lead into a cycle. The files of a directory are found once, under
+	// the first of its names: real/e.rb as linked/e.rb, and sub/d.sh as
+	// itself, not as z/sub/d.sh.
+	if err := os.Mkdir(filepath.Join(dir, "z"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for link, target := range map[string]string{"linked": "real", "f.go": "a.go", "sub/up": "..", "z/sub": "../sub"} {
+		if err := os.Symlink(target, filepath.Join(dir, filepath.FromSlash(link))); err != nil {
+			t.Skip("no symbolic links:", err)
+		}
+	}
+	got, err := findSources(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for _, name := range []string{"a.go", "b.py", "f.go", "linked/e.rb", "sub/d.sh"} {
+		want = append(want, filepath.Join(dir, filepath.FromSlash(name)))
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,99 @@
+// ## License headers
+//
+// Many Go files start with a license comment. In a document, it is rather
+// in the way. With `-license strip`, `gotomarkdown` drops it, and with
+// `-license collapse`, it wraps it in a `<details>` element that readers
+// can expand if they care. The default, `-license keep`, leaves it alone.
+//
+// Only the first comment of the file qualifies, and only if it contains
+// "Copyright" or "License". The first comment is a run of `//` lines or a
+// block comment, before any code; blank lines and Go directives before it
+// do not count.
+
+package main
+
+import "strings"
+
+// rewriteLicense strips or collapses the license comment at the top of
+// `src`, as `mode` says. The lineMap tells the original line of each line
+// of the result, or is nil if the lines stay where they are.
+func rewriteLicense(src, mode string) (string, lineMap) {
+	if mode != "strip" && mode != "collapse" {
+		return src, nil
+	}
+	lines := strings.Split(strings.TrimPrefix(src, "\uFEFF"), "\n")
+	start, end := licenseComment(lines)
+	if start < 0 {
+		return src, nil
+	}
+	if mode == "strip" {
+		// Blank lines keep the line numbers in messages right.
+		for i := start; i < end; i++ {
+			lines[i] = ""
+		}
+		return strings.Join(lines, "\n"), nil
+	}
+	// The lines around the license belong to its first and last line.
+	out := append([]string{}, lines[:start]...)
+	out = append(out, "// <details>", "// <summary>License</summary>", "//")
+	out = append(out, lines[start:end]...)
+	out = append(out, "//", "// </details>")
+	out = append(out, lines[end:]...)
+	origin := make(lineMap, 0, len(out))
+	for i := 0; i < start; i++ {
+		origin = append(origin, i+1)
+	}
+	origin = append(origin, start+1, start+1, start+1)
+	for i := start; i < end; i++ {
+		origin = append(origin, i+1)
+	}
+	origin = append(origin, end, end)
+	for i := end; i < len(lines); i++ {
+		origin = append(origin, i+1)
+	}
+	return strings.Join(out, "\n"), origin
+}
+
+// licenseComment returns the range of lines `start` to `end` (exclusive)
+// of the first comment in `lines`, or -1 if there is none or if it does not
+// look like a license.
+func licenseComment(lines []string) (start, end int) {
+	start = 0
+	for start < len(lines) {
+		trimmed := strings.TrimSpace(lines[start])
+		if trimmed != "" && !isDirective(trimmed) {
+			break
+		}
+		start++
+	}
+	if start == len(lines) {
+		return -1, 0
+	}
+	first := strings.TrimSpace(lines[start])
+	end = start
+	switch {
+	case strings.HasPrefix(first, "/*"):
+		// The block ends at the line with the `*/`, which may be the
+		// first line, too.
+		rest := first[2:]
+		for !commentEnd.MatchString(rest) {
+			end++
+			if end == len(lines) {
+				return -1, 0
+			}
+			rest = lines[end]
+		}
+		end++
+	case strings.HasPrefix(first, "//"):
+		for end < len(lines) && comment.MatchString(lines[end]) && !isDirective(lines[end]) {
+			end++
+		}
+	default:
+		return -1, 0
+	}
+	text := strings.Join(lines[start:end], "\n")
+	if !strings.Contains(text, "Copyright") && !strings.Contains(text, "License") {
+		return -1, 0
+	}
+	return start, end
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRewriteLicense(t *testing.T) {
+	const (
+		license = "// Copyright 2020 The Authors.\n// Use of this source code is governed by a license.\n\npackage p\n"
+		block   = "/*\nLicense: MIT\n*/\npackage p\n"
+	)
+	tests := []struct {
+		name, src, mode, want string
+		lines                 lineMap
+	}{
+		{
+			name: "keep",
+			src:  license,
+			mode: "keep",
+			want: license,
+		},
+		{
+			name: "strip",
+			src:  license,
+			mode: "strip",
+			want: "\n\n\npackage p\n",
+		},
+		{
+			name: "strip a block comment",
+			src:  block,
+			mode: "strip",
+			want: "\n\n\npackage p\n",
+		},
+		{
+			name:  "collapse",
+			src:   license,
+			mode:  "collapse",
+			want:  "// <details>\n// <summary>License</summary>\n//\n// Copyright 2020 The Authors.\n// Use of this source code is governed by a license.\n//\n// </details>\n\npackage p\n",
+			lines: lineMap{1, 1, 1, 1, 2, 2, 2, 3, 4, 5},
+		},
+		{
+			name:  "after a build constraint",
+			src:   "//go:build linux\n\n/* Copyright 2020 */\npackage p",
+			mode:  "collapse",
+			want:  "//go:build linux\n\n// <details>\n// <summary>License</summary>\n//\n/* Copyright 2020 */\n//\n// </details>\npackage p",
+			lines: lineMap{1, 2, 3, 3, 3, 3, 3, 3, 4},
+		},
+		{
+			name: "no license",
+			src:  "// Package p does things.\npackage p\n",
+			mode: "strip",
+			want: "// Package p does things.\npackage p\n",
+		},
+		{
+			name: "not the first comment",
+			src:  "package p\n\n// Copyright 2020\n",
+			mode: "strip",
+			want: "package p\n\n// Copyright 2020\n",
+		},
+		{
+			name: "unterminated block comment",
+			src:  "/* Copyright 2020\npackage p\n",
+			mode: "strip",
+			want: "/* Copyright 2020\npackage p\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, lines := rewriteLicense(tt.src, tt.mode)
+			if got != tt.want {
+				t.Errorf("got\n%q\nwant\n%q", got, tt.want)
+			}
+			if !reflect.DeepEqual(lines, tt.lines) {
+				t.Errorf("got lines %v, want %v", lines, tt.lines)
+			}
+		})
+	}
+}
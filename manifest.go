@@ -0,0 +1,75 @@
+// ## Writing a manifest
+//
+// For integrating `gotomarkdown` into a pipeline, `-manifest out.json`
+// writes a JSON file that lists what was generated, like:
+//
+//     {
+//       "files": [
+//         {
+//           "input": "gotomarkdown.go",
+//           "output": "out/gotomarkdown.md",
+//           "media": [
+//             "out/gotomarkdown_animation.gif"
+//           ]
+//         }
+//       ]
+//     }
+//
+// The `media` list contains the paths of the copied media files, so it is
+// empty with `-nocopy`.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+)
+
+// manifest is the schema of the manifest file.
+type manifest struct {
+	Files []manifestEntry `json:"files"`
+}
+
+// manifestEntry describes the files generated for one input file.
+type manifestEntry struct {
+	Input  string   `json:"input"`  // path of the source file
+	Output string   `json:"output"` // path of the Markdown (or HTML) file
+	Media  []string `json:"media"`  // paths of the copied media files
+}
+
+// newManifest returns the manifest for the source files `filenames`, in
+// order, and the media files found in each of them.
+func newManifest(filenames []string, media map[string]map[string]string, opts Options) manifest {
+	m := manifest{Files: []manifestEntry{}}
+	for _, filename := range filenames {
+		entry := manifestEntry{
+			Input:  filename,
+			Output: outputName(filename, opts),
+			Media:  []string{},
+		}
+		if copiesMedia(opts) {
+			for _, target := range media[filename] {
+				entry.Media = append(entry.Media, filepath.Join(mediaDir(filename, opts), target))
+			}
+			sort.Strings(entry.Media)
+		}
+		m.Files = append(m.Files, entry)
+	}
+	return m
+}
+
+// writeManifest writes the manifest to the file `name`.
+func writeManifest(name string, filenames []string, media map[string]map[string]string, opts Options) error {
+	content, err := json.MarshalIndent(newManifest(filenames, media, opts), "", "  ")
+	if err != nil {
+		return errors.New("Cannot create manifest\n" + err.Error())
+	}
+	err = ioutil.WriteFile(name, append(content, '\n'), 0644) // -rw-r--r--
+	if err != nil {
+		return errors.New("Cannot write manifest " + name + "\n" + err.Error())
+	}
+	return nil
+}
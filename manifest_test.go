@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWriteManifest(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.go"), filepath.Join(dir, "b.go")
+	for f, src := range map[string]string{
+		a:                              "// ![logo](logo.png)\n// ![diagram](img/diagram.png)\npackage p\n",
+		b:                              "// Text.\npackage p\n",
+		filepath.Join(dir, "logo.png"): "png",
+		filepath.Join(dir, "img"):      "",
+	} {
+		if src == "" {
+			if err := os.Mkdir(f, 0755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.WriteFile(f, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "img", "diagram.png"), []byte("png"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out")
+	for _, tt := range []struct {
+		name string
+		opts Options
+		want manifest
+	}{
+		{
+			name: "media",
+			opts: Options{OutDir: out, SubDir: true},
+			want: manifest{Files: []manifestEntry{
+				{Input: b, Output: filepath.Join(out, "b.md"), Media: []string{}},
+				{Input: a, Output: filepath.Join(out, "a.md"), Media: []string{
+					filepath.Join(out, "a", "img", "diagram.png"),
+					filepath.Join(out, "a", "logo.png"),
+				}},
+			}},
+		},
+		{
+			name: "no copy",
+			opts: Options{OutDir: out, NoCopy: true},
+			want: manifest{Files: []manifestEntry{
+				{Input: b, Output: filepath.Join(out, "b.md"), Media: []string{}},
+				{Input: a, Output: filepath.Join(out, "a.md"), Media: []string{}},
+			}},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			files := []string{b, a}
+			media, err := convertFiles(files, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			name := filepath.Join(dir, "manifest.json")
+			if err := writeManifest(name, files, media, tt.opts); err != nil {
+				t.Fatal(err)
+			}
+			content, err := os.ReadFile(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got manifest
+			if err := json.Unmarshal(content, &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
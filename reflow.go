@@ -0,0 +1,214 @@
+// ## Wrapping prose
+//
+// Comments come wrapped at whatever width their authors liked. For clean
+// diffs of the generated documents, `-wrap 80` reflows each paragraph of
+// prose to lines of at most 80 characters. Lines break at spaces only, and
+// never within a code span like `a b` or within a link like
+// `[some text](url)`. A word that is longer than the width, like a long
+// URL, gets a line of its own.
+//
+// Only plain paragraphs get reflowed. Code, headings, lists, block quotes,
+// tables, HTML, and the content of code fences stay as they are, and so do
+// lines that end with a hard line break.
+
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	// notProse matches the lines that do not belong to a plain paragraph.
+	notProse = regexp.MustCompile(`^(?:\s*$|\s*[-*+]\s|\s*\d+[.)]\s|\s*>|\s*\||\s*<|\s*\[[^\]]*\]:|\[[^\[\]]*\]\s*$| {4}|\t|=+\s*$|-+\s*$|\*+\s*$|_+\s*$|image::)`)
+	// listingDelim matches the delimiter line of an AsciiDoc listing block.
+	listingDelim = regexp.MustCompile(`^-{4,}\s*$`)
+)
+
+// reflow wraps the paragraphs of the document `md` at `width` columns.
+func reflow(md string, width int, opts Options) string {
+	if width <= 0 {
+		return md
+	}
+	lines := strings.Split(md, "\n")
+	out := make([]string, 0, len(lines))
+	// Front matter stays as it is.
+	if start, end := findFrontMatter(lines); start >= 0 {
+		out = append(out, lines[:end+1]...)
+		lines = lines[end+1:]
+	}
+	inFence, inListing := "", false
+	var para []string
+	flush := func() {
+		if len(para) > 0 {
+			out = append(out, wrapWords(strings.Join(para, " "), width)...)
+			para = nil
+		}
+	}
+	for i, line := range lines {
+		switch {
+		case inFence != "":
+			if closesFence(line, inFence) {
+				inFence = ""
+			}
+		case opts.Format == "asciidoc" && listingDelim.MatchString(line):
+			inListing = !inListing
+		case inListing:
+		case fence.MatchString(line):
+			inFence = fence.FindStringSubmatch(line)[1]
+		case heading.MatchString(line), notProse.MatchString(line):
+		case i+1 < len(lines) && len(para) == 0 && setextUnderline(lines[i+1]):
+			// A setext heading, like a line followed by `---`.
+		default:
+			para = append(para, strings.TrimSpace(line))
+			// A hard line break ends the line here.
+			if strings.HasSuffix(line, "  ") {
+				para[len(para)-1] += "  "
+				out = append(out, wrapWords(strings.Join(para, " "), width)...)
+				para = nil
+			}
+			continue
+		}
+		flush()
+		out = append(out, line)
+	}
+	flush()
+	return strings.Join(out, "\n")
+}
+
+// setextUnderline returns true if `line` underlines a setext heading.
+func setextUnderline(line string) bool {
+	t := strings.TrimSpace(line)
+	return t != "" && (strings.Trim(t, "=") == "" || strings.Trim(t, "-") == "")
+}
+
+// wrapWords breaks `text` into lines of at most `width` characters.
+func wrapWords(text string, width int) []string {
+	hardBreak := strings.HasSuffix(text, "  ")
+	var lines []string
+	line := ""
+	for _, word := range proseWords(text) {
+		switch {
+		case line == "":
+			line = word
+		case len([]rune(line))+1+len([]rune(word)) <= width:
+			line += " " + word
+		default:
+			lines = append(lines, line)
+			line = word
+		}
+	}
+	if hardBreak {
+		line += "  "
+	}
+	return append(lines, line)
+}
+
+// proseWords splits `text` at spaces, but not at the spaces within code
+// spans or links.
+func proseWords(text string) []string {
+	var words []string
+	var word strings.Builder
+	links := newLinkFinder(text)
+	for i := 0; i < len(text); i++ {
+		ch := text[i]
+		switch {
+		case ch == '`':
+			// A code span ends at a backtick run of the same length.
+			n := 1
+			for i+n < len(text) && text[i+n] == '`' {
+				n++
+			}
+			run := text[i : i+n]
+			if end := strings.Index(text[i+n:], run); end >= 0 {
+				n += end + len(run)
+			}
+			word.WriteString(text[i : i+n])
+			i += n - 1
+		case ch == '[':
+			if end := links.end(i); end > 0 {
+				word.WriteString(text[i:end])
+				i = end - 1
+			} else {
+				word.WriteByte('[')
+			}
+		case ch == ' ':
+			if word.Len() > 0 {
+				words = append(words, word.String())
+			}
+			word.Reset()
+		default:
+			word.WriteByte(ch)
+		}
+	}
+	if word.Len() > 0 {
+		words = append(words, word.String())
+	}
+	return words
+}
+
+// A linkFinder finds the links in a line of prose, like `[text](url)` or
+// `[text][id]`. A regular expression would search the rest of the line
+// anew at each `[`, which takes quadratic time on a long line like
+// `[[[[...` that has no link at all. A linkFinder remembers where the
+// next `]` and `)` are instead, so it reads the line only once, as long
+// as the positions it gets asked for increase.
+type linkFinder struct {
+	text                    string
+	label, target, refLabel nextByte
+}
+
+func newLinkFinder(text string) *linkFinder {
+	return &linkFinder{
+		text:     text,
+		label:    nextByte{text: text, c: ']', at: -1},
+		target:   nextByte{text: text, c: ')', at: -1},
+		refLabel: nextByte{text: text, c: ']', at: -1},
+	}
+}
+
+// end returns the end of the link that starts at the `[` at `i`, or 0 if
+// no link starts there.
+func (l *linkFinder) end(i int) int {
+	close := l.label.index(i + 1)
+	if close < 0 || close+1 >= len(l.text) {
+		return 0
+	}
+	var end int
+	switch l.text[close+1] {
+	case '(':
+		end = l.target.index(close + 2)
+	case '[':
+		end = l.refLabel.index(close + 2)
+	default:
+		return 0
+	}
+	if end < 0 {
+		return 0
+	}
+	return end + 1
+}
+
+// nextByte finds the next `c` in `text`. `at` is the index of the last
+// one found, or -1 before the first search.
+type nextByte struct {
+	text string
+	c    byte
+	at   int
+}
+
+// index returns the index of the first `c` at or after `i`, or -1 if
+// there is none.
+func (n *nextByte) index(i int) int {
+	// With `at` at the end of the text, there is none from here on.
+	if n.at < i && n.at < len(n.text) {
+		n.at = len(n.text)
+		if j := strings.IndexByte(n.text[i:], n.c); j >= 0 {
+			n.at = i + j
+		}
+	}
+	if n.at == len(n.text) {
+		return -1
+	}
+	return n.at
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProseWords(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"two  words", []string{"two", "words"}},
+		{"a `code span` b", []string{"a", "`code span`", "b"}},
+		{"a ``code ` span`` b", []string{"a", "``code ` span``", "b"}},
+		{"a [some link](x.md) b", []string{"a", "[some link](x.md)", "b"}},
+		{"a [some ref][id] b", []string{"a", "[some ref][id]", "b"}},
+		{"a [no link] b", []string{"a", "[no", "link]", "b"}},
+		{"a [[nested] b", []string{"a", "[[nested]", "b"}},
+	}
+	for _, tt := range tests {
+		if got := proseWords(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+// TestProseWordsLongLine feeds lines of more than 1 MB without links to
+// proseWords. A search from each `[` to the end of the line would take
+// hours; a single pass takes milliseconds.
+func TestProseWordsLongLine(t *testing.T) {
+	const size = 1 << 20
+	lines := map[string]string{
+		"brackets":       strings.Repeat("[", size),
+		"labels":         strings.Repeat("[a]", size/3),
+		"open targets":   strings.Repeat("[a](", size/4),
+		"open ref links": strings.Repeat("[a][", size/4),
+	}
+	for name, line := range lines {
+		done := make(chan []string)
+		go func() { done <- proseWords(line) }()
+		select {
+		case words := <-done:
+			if len(words) != 1 || words[0] != line {
+				t.Errorf("%s: got %d words, want the line as one word", name, len(words))
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("%s: proseWords did not finish within 10 seconds", name)
+		}
+	}
+}
+
+// TestConvertLongLine converts and wraps a comment line of more than
+// 1 MB, a malformed image tag that never ends.
+func TestConvertLongLine(t *testing.T) {
+	line := "// ![" + strings.Repeat("[a](", 1<<18) + "\npackage p\n"
+	done := make(chan error)
+	go func() {
+		_, _, err := render(line, "long.go", Options{Wrap: 80})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the conversion did not finish within 10 seconds")
+	}
+}
+
+func TestConvertWrap(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{
+			name: "long paragraph",
+			in:   "// A long paragraph with many words that go on and on, with `a code span` and [a link](https://example.com/x) too.\n// It continues here.\npackage p\n",
+			want: "A long paragraph with many\nwords that go on and on, with\n`a code span` and\n[a link](https://example.com/x)\ntoo. It continues here.\n",
+		},
+		{
+			name: "long URL",
+			in:   "// See https://example.com/a/very/long/url/that/does/not/fit/into/the/width.\npackage p\n",
+			want: "See\nhttps://example.com/a/very/long/url/that/does/not/fit/into/the/width.\n",
+		},
+		{
+			name: "heading",
+			in:   "// # A heading that is much longer than the width of thirty columns\npackage p\n",
+			want: "# A heading that is much longer than the width of thirty columns\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := render(tt.in, "wrap.go", Options{Wrap: 30})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(got, tt.want) {
+				t.Errorf("got\n%q\nwant prefix\n%q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+// ## Media from elsewhere
+//
+// The media files of a document need not be on the disk. They might live
+// in a CDN bucket, in a content-addressed store, or in an `embed.FS`. A
+// library user who sets `Options.MediaResolver` decides where each media
+// file comes from. The converter then neither checks that the media files
+// exist nor copies them with the tools of the OS. Instead, it asks the
+// resolver for the content of each one and writes it to the output:
+//
+//     type bucket struct{ client *storage.Client }
+//
+//     func (b bucket) Resolve(src string) (io.ReadCloser, error) {
+//         return b.client.Get("media/" + src)
+//     }
+//
+//     opts.MediaResolver = bucket{client}
+//
+// `FSResolver` is the resolver for a file system, like the local one.
+//
+// Hype's `hyperesources` are directories, which a resolver cannot return
+// as a single file, so Hype animations need the default copying.
+
+package main
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"io/ioutil"
+)
+
+// MediaResolver fetches the content of media files.
+type MediaResolver interface {
+	// Resolve opens the media file `srcPath`, as the document refers to it,
+	// with BaseDir in front, if set. The copy gets the name that the
+	// document uses, so that the tags in the document stay valid. The
+	// converter closes `r`.
+	Resolve(srcPath string) (r io.ReadCloser, err error)
+}
+
+// FSResolver resolves media paths in the file system `FS`. An FSResolver
+// with `os.DirFS(".")` reads from the local file system, relative to the
+// current directory, but unlike the default copying, it copies no
+// directories.
+type FSResolver struct {
+	FS fs.FS
+}
+
+func (r FSResolver) Resolve(srcPath string) (io.ReadCloser, error) {
+	return slashFS{r.FS}.Open(srcPath)
+}
+
+// resolverCopier copies media files from a MediaResolver to an output.
+type resolverCopier struct {
+	from MediaResolver
+	to   OutputFS
+}
+
+func (c resolverCopier) copy(src, dest string) error {
+	r, err := c.from.Resolve(src)
+	if err != nil {
+		return errors.New("Cannot resolve media file " + src + "\n" + err.Error())
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return errors.New("Cannot read media file " + src + "\n" + err.Error())
+	}
+	return c.to.WriteFile(dest, data)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// mapResolver resolves media paths from a map.
+type mapResolver map[string]string
+
+func (m mapResolver) Resolve(src string) (io.ReadCloser, error) {
+	content, ok := m[src]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return ioutil.NopCloser(strings.NewReader(content)), nil
+}
+
+func TestMediaResolver(t *testing.T) {
+	// The media files are not in the file system of the Go files.
+	src := fstest.MapFS{
+		"doc.go": {Data: []byte("// ![logo](img/logo.png)\npackage doc\n")},
+	}
+	tests := []struct {
+		name     string
+		resolver MediaResolver
+		want     string // the content of the copy
+		fails    bool
+	}{
+		{"map", mapResolver{"img/logo.png": "png"}, "png", false},
+		{"file system", FSResolver{fstest.MapFS{"img/logo.png": {Data: []byte("fs")}}}, "fs", false},
+		{"missing", mapResolver{}, "", true},
+	}
+	for _, tt := range tests {
+		out := MemOutput{}
+		fc := NewFileConverter(src, out, Options{OutDir: "out", MediaResolver: tt.resolver})
+		media, err := fc.Convert("doc.go")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = fc.CopyMedia("doc.go", media)
+		if tt.fails {
+			if err == nil {
+				t.Errorf("%s: got no error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if got := string(out["out/img/logo.png"]); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
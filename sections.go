@@ -0,0 +1,163 @@
+// ## Sorting sections
+//
+// Reference documents are easier to search when their sections come in
+// alphabetical order. With `-sort-sections`, the converted document gets
+// split at its top-level headings, like `# Options`, and the sections get
+// sorted by their heading text, ignoring case. Each section takes along
+// everything up to the next top-level heading, including its code blocks.
+// Whatever comes before the first heading, like front matter or an
+// introduction, stays at the top.
+//
+// Separators from `-section-breaks` stay between the sections.
+//
+// To embed a single section somewhere else, `-section Usage` keeps only the
+// section with the heading `Usage`, of any level, again ignoring case. The
+// section ends at the next heading of the same or a higher level, so it
+// includes its subsections and code blocks. If there is no such heading,
+// the conversion fails.
+
+package main
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// section is a part of a document that starts with a top-level heading.
+type section struct {
+	title string
+	lines []string
+}
+
+// sortSections sorts the sections of the document `md` by their headings.
+func sortSections(md string, opts Options) string {
+	lines := strings.Split(md, "\n")
+	var preamble []string
+	if _, end := findFrontMatter(lines); end >= 0 {
+		preamble, lines = lines[:end+1], lines[end+1:]
+	}
+	var sections []section
+	inFence, inListing := "", false
+	for i, line := range lines {
+		if level, title := headingAt(lines, i, &inFence, &inListing, opts.Format); level == 1 {
+			sections = append(sections, section{title: strings.ToLower(title)})
+		}
+		if len(sections) == 0 {
+			preamble = append(preamble, line)
+			continue
+		}
+		s := &sections[len(sections)-1]
+		s.lines = append(s.lines, line)
+	}
+	if len(sections) < 2 {
+		return md
+	}
+
+	// A separator between two sections ends the first one. It gets
+	// dropped here and put back between the sorted sections.
+	for i := range sections {
+		s := &sections[i]
+		s.lines = trimBlankLines(s.lines)
+		if opts.SectionBreaks != "" && len(s.lines) > 0 && s.lines[len(s.lines)-1] == opts.SectionBreaks {
+			s.lines = trimBlankLines(s.lines[:len(s.lines)-1])
+		}
+	}
+	sort.SliceStable(sections, func(i, j int) bool {
+		return sections[i].title < sections[j].title
+	})
+	out := preamble
+	for i, s := range sections {
+		if i > 0 {
+			out = append(out, "")
+			if opts.SectionBreaks != "" {
+				out = append(out, opts.SectionBreaks, "")
+			}
+		}
+		out = append(out, s.lines...)
+	}
+	return strings.Join(out, "\n") + "\n"
+}
+
+// extractSection returns the section of the document `md` with the
+// heading `name`, up to the next heading of the same or a higher level.
+func extractSection(md, name, filename string, opts Options) (string, error) {
+	lines := strings.Split(md, "\n")
+	inFence, inListing := "", false
+	start, level := -1, 0
+	for i := range lines {
+		l, title := headingAt(lines, i, &inFence, &inListing, opts.Format)
+		if l == 0 {
+			continue
+		}
+		if start >= 0 && l <= level {
+			lines = lines[:i]
+			break
+		}
+		if start < 0 && strings.EqualFold(title, strings.TrimSpace(name)) {
+			start, level = i, l
+		}
+	}
+	if start < 0 {
+		return "", errors.New("No section " + name + " in " + filename)
+	}
+	lines = trimBlankLines(lines[start:])
+	if opts.SectionBreaks != "" && len(lines) > 0 && lines[len(lines)-1] == opts.SectionBreaks {
+		lines = trimBlankLines(lines[:len(lines)-1])
+	}
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+// adocTitle matches an AsciiDoc section title; submatch 1 is the level.
+var adocTitle = regexp.MustCompile(`^(=+) +(.*)$`)
+
+// headingAt returns the level and the text of the heading that starts at
+// `lines[i]`, or 0 if none does. The block anchor of an AsciiDoc title,
+// like `[#id]`, goes with the title, so the heading starts there.
+// `inFence` and `inListing` keep track of the code blocks, where headings
+// are code; headingAt must see the lines in order.
+func headingAt(lines []string, i int, inFence *string, inListing *bool, format string) (level int, title string) {
+	line := lines[i]
+	switch {
+	case *inFence != "":
+		if closesFence(line, *inFence) {
+			*inFence = ""
+		}
+		return 0, ""
+	case format == "asciidoc" && listingDelim.MatchString(line):
+		*inListing = !*inListing
+		return 0, ""
+	case *inListing:
+		return 0, ""
+	case fence.MatchString(line):
+		*inFence = fence.FindStringSubmatch(line)[1]
+		return 0, ""
+	case format == "asciidoc":
+		if strings.HasPrefix(line, "[#") && i+1 < len(lines) {
+			line = lines[i+1]
+		} else if i > 0 && strings.HasPrefix(lines[i-1], "[#") {
+			return 0, ""
+		}
+		m := adocTitle.FindStringSubmatch(line)
+		if m == nil {
+			return 0, ""
+		}
+		level, title = len(m[1]), m[2]
+	default:
+		h := heading.FindStringSubmatchIndex(line)
+		if h == nil {
+			return 0, ""
+		}
+		level, title = h[5]-h[4], strings.TrimRight(strings.TrimSpace(line[h[1]:]), "#")
+	}
+	return level, strings.TrimSpace(headingID.ReplaceAllString(title, ""))
+}
+
+// trimBlankLines removes the blank lines at the end of `lines`.
+func trimBlankLines(lines []string) []string {
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
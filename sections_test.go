@@ -0,0 +1,78 @@
+package main
+
+import "testing"
+
+func TestSortSections(t *testing.T) {
+	tests := []struct {
+		name, md, want string
+		opts           Options
+	}{
+		{
+			name: "preamble",
+			md:   "---\ntitle: x\n---\n\nIntro.\n\n# Zeta\n\nZ.\n\n```go\n# not a heading\n```\n\n# alpha\n\nA.\n\n## Sub\n\n# Beta\n\nB.\n",
+			want: "---\ntitle: x\n---\n\nIntro.\n\n# alpha\n\nA.\n\n## Sub\n\n# Beta\n\nB.\n\n# Zeta\n\nZ.\n\n```go\n# not a heading\n```\n",
+		},
+		{
+			name: "section breaks",
+			md:   "# Zeta\n\nZ.\n\n---\n\n# Alpha\n\nA.\n",
+			opts: Options{SectionBreaks: "---"},
+			want: "# Alpha\n\nA.\n\n---\n\n# Zeta\n\nZ.\n",
+		},
+		{
+			name: "one section",
+			md:   "Intro.\n\n# Only\n\nText.\n",
+			want: "Intro.\n\n# Only\n\nText.\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sortSections(tt.md, tt.opts); got != tt.want {
+				t.Errorf("got\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractSection(t *testing.T) {
+	const md = "# Intro\n\nText.\n\n## Usage\n\nRun it.\n\n```go\n# not a heading\n```\n\n### Flags\n\nSome.\n\n## Other\n\nMore.\n"
+	tests := []struct {
+		name, section, want string
+	}{
+		{
+			name:    "middle section",
+			section: "usage",
+			want:    "## Usage\n\nRun it.\n\n```go\n# not a heading\n```\n\n### Flags\n\nSome.\n",
+		},
+		{
+			name:    "subsection",
+			section: "Flags",
+			want:    "### Flags\n\nSome.\n",
+		},
+		{
+			name:    "last section",
+			section: " OTHER ",
+			want:    "## Other\n\nMore.\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := extractSection(md, tt.section, "x.go", Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractSectionMissing(t *testing.T) {
+	if _, err := extractSection("# Intro\n\nText.\n", "Usage", "x.go", Options{}); err == nil {
+		t.Error("got no error")
+	}
+	// A heading in a code block is code.
+	if _, err := extractSection("```\n# Usage\n```\n", "Usage", "x.go", Options{}); err == nil {
+		t.Error("in a code block: got no error")
+	}
+}
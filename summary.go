@@ -0,0 +1,50 @@
+// ## The summary of a run
+//
+// At the end of a run, `gotomarkdown` reports what it did: how many files
+// it converted, how many media files it copied, and how many it skipped,
+// because they are remote (like `https://example.com/x.png`) or missing.
+// With `-v`, it also reports each file along the way.
+
+package main
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// runSummary counts what happened during a run. The conversions can run
+// concurrently, so the counters are guarded by a mutex.
+type runSummary struct {
+	mu        sync.Mutex
+	converted int // files converted
+	copied    int // media files copied
+	remote    int // remote media files, which are not copied
+	missing   int // media files that do not exist
+}
+
+// add increments the counter `n` of the summary.
+func (s *runSummary) add(n *int) {
+	s.mu.Lock()
+	*n++
+	s.mu.Unlock()
+}
+
+// report returns the summary as a message, including the time elapsed
+// since `start`.
+func (s *runSummary) report(start time.Time) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return "Done. Converted " + plural(s.converted, "file") +
+		", copied " + plural(s.copied, "media file") +
+		", skipped " + strconv.Itoa(s.remote) + " remote and " + strconv.Itoa(s.missing) + " missing" +
+		", in " + time.Since(start).Round(time.Millisecond).String() + "."
+}
+
+// plural returns `n` and `noun`, with an "s" unless `n` is 1.
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return strconv.Itoa(n) + " " + noun + "s"
+}
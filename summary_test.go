@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunSummary(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.go":     "// ![logo](logo.png)\n// ![remote](https://example.com/r.png)\npackage a\n",
+		"b.go":     "// ![diagram](diagram.png)\n// ![gone](missing.png)\npackage b\n",
+		"c.go":     "package c\n",
+		"logo.png": "png", "diagram.png": "png",
+	}
+	var sources []string
+	for name, content := range files {
+		f := filepath.Join(dir, name)
+		if err := os.WriteFile(f, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasSuffix(name, ".go") {
+			sources = append(sources, f)
+		}
+	}
+	captureLog(t) // the warning about missing.png
+	opts := Options{OutDir: filepath.Join(dir, "out"), run: newRunState()}
+	media, err := convertFiles(sources, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := copyMedia(media, opts); err != nil {
+		t.Fatal(err)
+	}
+	s := opts.run.summary
+	if s.converted != 3 || s.copied != 2 || s.remote != 1 || s.missing != 1 {
+		t.Errorf("got %d converted, %d copied, %d remote, %d missing, want 3, 2, 1, 1", s.converted, s.copied, s.remote, s.missing)
+	}
+	const want = "Done. Converted 3 files, copied 2 media files, skipped 1 remote and 1 missing, in "
+	if got := s.report(time.Now()); !strings.HasPrefix(got, want) {
+		t.Errorf("got %q, want prefix %q", got, want)
+	}
+}
+
+func TestPlural(t *testing.T) {
+	for n, want := range map[int]string{0: "0 files", 1: "1 file", 2: "2 files"} {
+		if got := plural(n, "file"); got != want {
+			t.Errorf("%d: got %q, want %q", n, got, want)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+// ## Watching files for changes
+//
+// With `-watch`, `gotomarkdown` keeps running after the first conversion and
+// converts a file again whenever the file itself or one of its media files
+// changes. The standard library has no file system notifications, so
+// `watch` polls the modification times. A package like fsnotify would
+// notice changes sooner, but it would be the first dependency outside of
+// the standard library, and `gotomarkdown` builds with a plain `go build`.
+// For the handful of files that a document consists of, polling four times
+// a second costs next to nothing. A burst of writes (as some editors
+// do when saving) results in a single conversion, as a file is only
+// converted again after its modification times have settled.
+
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+const (
+	pollInterval = 250 * time.Millisecond // how often to check the modification times
+	settleTime   = 500 * time.Millisecond // how long the modification times must not change
+)
+
+// modTimes returns the modification times of a source file and its media
+// files. Missing files get the zero time.
+func modTimes(filename string, media map[string]string) map[string]time.Time {
+	times := map[string]time.Time{}
+	for _, p := range append([]string{filename}, keys(media)...) {
+		fi, err := os.Stat(p)
+		if err == nil {
+			times[p] = fi.ModTime()
+		} else {
+			times[p] = time.Time{}
+		}
+	}
+	return times
+}
+
+// keys returns the keys of a map of media paths.
+func keys(m map[string]string) (k []string) {
+	for p := range m {
+		k = append(k, p)
+	}
+	return k
+}
+
+// changed returns true if the modification times differ.
+func changed(a, b map[string]time.Time) bool {
+	if len(a) != len(b) {
+		return true
+	}
+	for p, t := range a {
+		if !b[p].Equal(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// watch converts the source files in `media` again whenever they, or the
+// media files they reference, change. `filenames` are all the files of the
+// run, in order. It returns on SIGINT or SIGTERM.
+// Errors are logged but do not stop watching, as they are usually fixed
+// with the next save.
+func watch(filenames []string, media map[string]map[string]string, opts Options) {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stop)
+
+	w := newWatcher(filenames, media, opts)
+	log.Println("Watching for changes. Press Ctrl-C to stop.")
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			log.Println("Stopped watching.")
+			return
+		case now := <-ticker.C:
+			w.poll(now)
+		}
+	}
+}
+
+// A watcher keeps track of the modification times of the watched files.
+type watcher struct {
+	opts    Options
+	media   map[string]map[string]string    // media files per source file
+	seen    map[string]map[string]time.Time // last converted modification times per file
+	current map[string]map[string]time.Time // last polled modification times per file
+	pending map[string]time.Time            // time of the last change per file not yet converted
+}
+
+// newWatcher returns a watcher for the source files in `media`, which
+// were converted with `opts` just now.
+func newWatcher(filenames []string, media map[string]map[string]string, opts Options) *watcher {
+	w := &watcher{
+		// A file that gets converted again is still part of the whole
+		// run: its weight and the links to the other files stay.
+		opts:    forRun(filenames, opts),
+		media:   media,
+		seen:    map[string]map[string]time.Time{},
+		current: map[string]map[string]time.Time{},
+		pending: map[string]time.Time{},
+	}
+	for filename, m := range media {
+		w.seen[filename] = modTimes(filename, m)
+		w.current[filename] = w.seen[filename]
+	}
+	return w
+}
+
+// poll checks the modification times at the time `now`, and converts the
+// files again whose times have settled after a change.
+func (w *watcher) poll(now time.Time) {
+	for filename, m := range w.media {
+		times := modTimes(filename, m)
+		if changed(times, w.current[filename]) {
+			w.current[filename] = times
+			w.pending[filename] = now
+			continue
+		}
+		last, ok := w.pending[filename]
+		if !ok || now.Sub(last) < settleTime {
+			continue
+		}
+		delete(w.pending, filename)
+		if !changed(times, w.seen[filename]) {
+			continue
+		}
+		newMedia, err := convertFiles([]string{filename}, w.opts)
+		if err != nil {
+			log.Println("[Conversion Error] " + err.Error())
+			continue
+		}
+		err = copyMedia(newMedia, w.opts)
+		if err != nil {
+			log.Println("[CopyMedia Error] " + err.Error())
+		}
+		w.media[filename] = newMedia[filename]
+		w.seen[filename] = modTimes(filename, w.media[filename])
+		w.current[filename] = w.seen[filename]
+		log.Println("Regenerated", filename)
+	}
+}
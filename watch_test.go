@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatcher(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.go"), filepath.Join(dir, "b.go")
+	for f, src := range map[string]string{
+		a: "// # A\npackage p\n",
+		b: "// # B\n//\n// See [a](a.go).\npackage p\n",
+	} {
+		if err := os.WriteFile(f, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := []string{a, b}
+	opts := Options{OutDir: filepath.Join(dir, "out"), GoLinks: true, AutoWeight: true, FrontMatter: "hugo-yaml", Date: "2020-01-02"}
+	media, err := convertFiles(files, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := newWatcher(files, media, opts)
+
+	// Change b.go. It gets converted again once its modification time
+	// has settled.
+	if err := os.WriteFile(b, []byte("// # B\n//\n// See [a](a.go) again.\npackage p\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if err := os.Chtimes(b, now, now.Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	read := func() string {
+		md, err := os.ReadFile(filepath.Join(dir, "out", "b.md"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(md)
+	}
+	const before = "---\ntitle: \"B\"\ndate: \"2020-01-02\"\nweight: 2\n---\n\n# B\n\nSee [a](a.md).\n\n```go\npackage p\n```\n"
+	w.poll(now)
+	if got := read(); got != before {
+		t.Errorf("right after the change: got\n%q\nwant\n%q", got, before)
+	}
+	// The weight and the link stay as in the whole run.
+	const after = "---\ntitle: \"B\"\ndate: \"2020-01-02\"\nweight: 2\n---\n\n# B\n\nSee [a](a.md) again.\n\n```go\npackage p\n```\n"
+	w.poll(now.Add(settleTime))
+	if got := read(); got != after {
+		t.Errorf("after settling: got\n%q\nwant\n%q", got, after)
+	}
+}
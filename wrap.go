@@ -0,0 +1,90 @@
+// ## Prefix and suffix
+//
+// With `-prefix banner.md` and `-suffix footer.md`, every document gets
+// wrapped with some boilerplate text, like a banner at the top (but below
+// the front matter) and a footer at the bottom. The texts are templates
+// (see package `text/template`) that can refer to the document:
+//
+// * `{{.Filename}}` is the path of the Go file.
+// * `{{.Title}}` is the title of the document (see `documentTitle`).
+// * `{{.Date}}` is the date of the document (see `fileDate`), like
+//   `2016-04-14`, unless `-date` sets another one.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"text/template"
+)
+
+// wrapData are the variables for the prefix and suffix templates.
+type wrapData struct {
+	Filename string
+	Title    string
+	Date     string
+}
+
+// parseWrapper parses the prefix or suffix template `text`. `name` is the
+// name of the template for error messages.
+func parseWrapper(name, text string) (*template.Template, error) {
+	t, err := template.New(name).Parse(text)
+	if err != nil {
+		return nil, errors.New("Cannot parse " + name + "\n" + err.Error())
+	}
+	return t, nil
+}
+
+// wrap adds the prefix and the suffix from `opts` to the document `md`
+// converted from the file `filename`.
+func wrap(md, filename string, opts Options) (string, error) {
+	if opts.Prefix == "" && opts.Suffix == "" {
+		return md, nil
+	}
+	data := wrapData{
+		Filename: filename,
+		Title:    documentTitle(md, filename, opts),
+		Date:     opts.Date,
+	}
+	if data.Date == "" {
+		data.Date = fileDate(opts.files(), filename, opts.DateSource).Format("2006-01-02")
+	}
+	prefix, err := execWrapper("prefix", opts.Prefix, data)
+	if err != nil {
+		return "", err
+	}
+	suffix, err := execWrapper("suffix", opts.Suffix, data)
+	if err != nil {
+		return "", err
+	}
+	// The prefix goes below the front matter, if there is any.
+	lines := strings.Split(md, "\n")
+	if start, end := findFrontMatter(lines); start >= 0 {
+		i := end + 1
+		for i < len(lines)-1 && strings.TrimSpace(lines[i]) == "" {
+			i++
+		}
+		md = strings.Join(lines[:i], "\n") + "\n" + prefix + strings.Join(lines[i:], "\n")
+	} else {
+		md = prefix + md
+	}
+	return md + suffix, nil
+}
+
+// execWrapper executes the template `text` with `data`.
+func execWrapper(name, text string, data wrapData) (string, error) {
+	if text == "" {
+		return "", nil
+	}
+	t, err := parseWrapper(name, text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	err = t.Execute(&buf, data)
+	if err != nil {
+		return "", errors.New("Cannot fill in the " + name + "\n" + err.Error())
+	}
+	return buf.String(), nil
+}
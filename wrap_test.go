@@ -0,0 +1,55 @@
+package main
+
+import "testing"
+
+func TestWrap(t *testing.T) {
+	const prefix = "> {{.Title}}, from {{.Filename}}, {{.Date}}\n\n"
+	tests := []struct {
+		name, md string
+		opts     Options
+		want     string
+	}{
+		{
+			name: "prefix and suffix",
+			md:   "# Doc\n",
+			opts: Options{Prefix: prefix, Suffix: "\n---\n{{.Title}}\n", Date: "2020-01-02"},
+			want: "> Doc, from dir/doc.go, 2020-01-02\n\n# Doc\n\n---\nDoc\n",
+		},
+		{
+			name: "below the front matter",
+			md:   "---\ntitle: T\n---\n\n# Doc\n",
+			opts: Options{Prefix: prefix, Title: "Explicit", Date: "2020-01-02"},
+			want: "---\ntitle: T\n---\n\n> Explicit, from dir/doc.go, 2020-01-02\n\n# Doc\n",
+		},
+		{
+			name: "suffix only",
+			md:   "Text.\n",
+			opts: Options{Suffix: "The end of {{.Title}}.\n"},
+			want: "Text.\nThe end of doc.\n",
+		},
+		{
+			name: "no prefix or suffix",
+			md:   "Text.\n",
+			want: "Text.\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := wrap(tt.md, "dir/doc.go", tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWrapErrors(t *testing.T) {
+	for _, opts := range []Options{{Prefix: "{{.Title"}, {Suffix: "{{.Missing}}"}} {
+		if _, err := wrap("Text.\n", "doc.go", opts); err == nil {
+			t.Errorf("%q: got no error", []string{opts.Prefix, opts.Suffix})
+		}
+	}
+}